@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/dsp"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/fish"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+)
+
+// alertPoll is how often the queue is checked for alerts while the fish is
+// busy.
+const alertPoll = time.Second
+
+// isAlert reports whether item is a clip in the alert category. Alerts
+// interrupt whatever the fish is playing.
+func isAlert(item playlist.QueueItem) bool {
+	if item.Type != "song" {
+		return false
+	}
+	clip, err := library.GetClip(item.Name)
+	return err == nil && clip != nil && clip.Category == library.CategoryAlert
+}
+
+// watchAlerts plays alerts as soon as they are queued, on top of what the
+// fish is playing meanwhile, which pauses until the alert has played. The
+// returned function stops watching; it waits for an alert that is playing.
+func watchAlerts(ctx context.Context, myFish *fish.Fish) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(alertPoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			item, err := playlist.TakeQueueItem(isAlert)
+			if err != nil {
+				slog.Error("Error checking queue for alerts", "error", err)
+				continue
+			}
+			if item == nil {
+				continue
+			}
+			slog.Info("Interrupting for alert", "name", item.Name, "requested_by", item.RequestedBy)
+			// Once started, the alert plays to the end
+			if err := playQueuedSong(context.WithoutCancel(ctx), myFish, item); err != nil {
+				slog.Error("Failed to play alert", "file", item.Name, "error", err)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// playQueuedSong plays the clip of a queued item with its effects.
+func playQueuedSong(ctx context.Context, myFish *fish.Fish, item *playlist.QueueItem) error {
+	source := playlist.SourceQueue
+	if item.Source != "" {
+		source = item.Source
+	}
+	ctx = fish.WithRequester(fish.WithSource(ctx, source), item.RequestedBy)
+	chain, err := dsp.Parse(item.Effects)
+	if err != nil {
+		slog.Warn("Invalid effects, playing the song as is", "file", item.Name, "error", err)
+	}
+	return myFish.PlaySoundFile(fish.WithEffects(ctx, chain), item.Name)
+}
@@ -5,13 +5,15 @@ import (
 	"log/slog"
 	"math/rand"
 	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/fish"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/logger"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/tts"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
@@ -36,21 +38,42 @@ func init() {
 type Phrase struct {
 	Text   string
 	Weight int
+	// Voice is the name of the voice profile, empty for the default voice.
+	Voice string
+	// Effects is the effects chain the phrase is said with, see dsp.Parse.
+	Effects string
 }
 
-func getWeightedRandomPhrase() string {
-	now := time.Now()
-	hour := now.Hour()
-	day := now.Day()
+// voiceProfilesPath is the file with custom voice profiles, see
+// piper.LoadProfiles.
+var voiceProfilesPath = "./sound-data/voices.json"
+
+// voiceFor returns the voice of the profile called name. Unknown profiles
+// fall back to the default voice, so a typo does not silence the fish.
+func voiceFor(name string) piper.Voice {
+	profiles, err := piper.LoadProfiles(voiceProfilesPath)
+	if err != nil {
+		slog.Error("Failed to load voice profiles", "error", err)
+	}
+	voice, ok := profiles.Get(name)
+	if !ok {
+		slog.Warn("Unknown voice profile, using the default voice", "profile", name)
+		voice, _ = profiles.Get("")
+	}
+	return voice
+}
 
-	phrases := []Phrase{
+// phraseCatalog returns every phrase the fish says on its own, with its
+// base weight.
+func phraseCatalog() []Phrase {
+	return []Phrase{
 		{Text: "Bald ist Mittag", Weight: 10},
 		{Text: "Bald ist Feierabend", Weight: 10},
 		{Text: "Es ist spät, Zeit für Magic!", Weight: 10},
 		{Text: "Feierabend, wie das duftet. Kräftig, deftig, würzig gut!", Weight: 10},
 		{Text: "Es ist Mittwoch, meine Kerle.", Weight: 50},
 		{Text: "Freitag ab eins macht jeder seins!", Weight: 50},
-		{Text: "WOCHENENDE! SAUFEN!", Weight: 50},
+		{Text: "WOCHENENDE! SAUFEN!", Weight: 50, Voice: "hectic"},
 		{Text: "Komm in die Gruppe! Hinterbüro ist beste!", Weight: 50},
 		{Text: "Hallo, I bims. Vong Fisch Sprache her.", Weight: 50},
 		{Text: "Der Gerät wird nie müde. Der Gerät schläft nie ein. Der Gerät ist immer vor die Chef im Geschäft.", Weight: 40},
@@ -72,19 +95,27 @@ func getWeightedRandomPhrase() string {
 		{Text: "Schauen wir mal was wird. Was wird.", Weight: 50},
 		{Text: "Hey was machst du den hier? Das wolltest du wohl klauen?! ALARM!", Weight: 50},
 		{Text: "Was ist denn mit Thorsten los?", Weight: 50},
-		{Text: "ROOOOOOOOOBERT!!!", Weight: 50},
+		{Text: "ROOOOOOOOOBERT!!!", Weight: 50, Voice: "hectic"},
 		{Text: "Meine Mama hat gesagt ich darf Fortnite spielen!", Weight: 50},
 		{Text: "Was hast du denn da gekauft?! Coca Cola Light?? Ich wollte doch eine ZERROO!!", Weight: 50},
 		{Text: "Was guckst du? Schau weg!", Weight: 50},
 		{Text: "Lass mich in Ruhe!", Weight: 50},
-		{Text: "Still hier. Sus.", Weight: 50},
+		{Text: "Still hier. Sus.", Weight: 50, Voice: "sleepy"},
 		{Text: "Lügen darf man nicht sagen.", Weight: 50},
-		{Text: "Ich muss raus. Ich muss rauuuus!", Weight: 50},
+		{Text: "Ich muss raus. Ich muss rauuuus!", Weight: 50, Voice: "hectic"},
 		{Text: "EGAL!", Weight: 50},
 		{Text: "Ich bin der Uwe, ich bin auch dabei.", Weight: 50},
 		{Text: "Warum liegt hier Stroh?", Weight: 50},
 		{Text: "Dunkel war′s, der Mond schien helle,\n\nschneebedeckt die grüne Flur,\n\nals ein Wagen blitzesschnelle\n\nlangsam um die Ecke fuhr.\n\n \n\nDrinnen saßen stehend Leute\n\nschweigend ins Gespräch vertieft\n\nals ein totgeschossner Hase\n\nauf der Sandbank Schlittschuh lief.\n\n \n\nUnd der Wagen fuhr im Trabe\n\nrückwärts einen Berg hinauf.\n\nDroben zog ein alter Rabe\n\ngrade eine Turmuhr auf.\n\n \n\nRingsumher herrscht tiefes Schweigen\n\nund mit fürchterlichem Krach\n\nspielen in des Grases Zweigen\n\nzwei Kamele lautlos Schach.\n\n \n\nUnd auf einer roten Bank,\n\ndie blau angestrichen war\n\nsaß ein blondgelockter Jüngling\n\nmit kohlrabenschwarzem Haar.\n\n \n\nNeben ihm ne alte Schrulle,\n\ndie kaum siebzehn Jahr alt war,\n\nin der Hand ne Butterstulle,\n\ndie mit Schmalz bestrichen war.\n\n \n\nOben auf dem Apfelbaume,\n\nder sehr süße Birnen trug,\n\nhing des Frühlings letzte Pflaume\n\nund an Nüssen noch genug.\n\n \n\nVon der regennassen Straße\n\nwirbelte der Staub empor.\n\nUnd ein Junge bei der Hitze\n\nmächtig an den Ohren fror.\n\n \n\nBeide Hände in den Taschen\n\nhielt er sich die Augen zu.\n\nDenn er konnte nicht ertragen,\n\nwie nach Veilchen roch die Kuh.\n\n \n\nUnd zwei Fische liefen munter\n\ndurch das blaue Kornfeld hin.\n\nEndlich ging die Sonne unter\n\nund der graue Tag erschien.\n\n \n\nHolder Engel, süßer Bengel,\n\nfurchtbar liebes Trampeltier.\n\nDu hast Augen wie Sardellen,\n\nalle Ochsen gleichen Dir.\n\n \n\nEine Kuh, die saß im Schwalbennest\n\nmit sieben jungen Ziegen,\n\ndie feierten ihr Jubelfest\n\nund fingen an zu fliegen.\n\nDer Esel zog Pantoffeln an,\n\nist übers Haus geflogen,\n\nund wenn das nicht die Wahrheit ist,\n\nso ist es doch gelogen.", Weight: 20},
 	}
+}
+
+func getWeightedRandomPhrase() Phrase {
+	now := time.Now()
+	hour := now.Hour()
+	day := now.Day()
+
+	phrases := phraseCatalog()
 
 	if hour >= 11 && hour <= 12 {
 		phrases[0].Weight += 70
@@ -153,7 +184,7 @@ func getWeightedRandomPhrase() string {
 
 	if totalWeight == 0 {
 		slog.Info("No phrases to say after filtering and potential reset.")
-		return ""
+		return Phrase{}
 	}
 
 	r := rand.Intn(totalWeight)
@@ -164,37 +195,153 @@ func getWeightedRandomPhrase() string {
 				Name:      p.Text,
 				Type:      "text",
 				Timestamp: time.Now(),
+				Source:    playlist.SourceRandom,
 			}
-			if err := playlist.AddPlayedItem(item, 1*time.Hour); err != nil {
+			if err := playlist.AddPlayedItem(item, playlist.HistoryRetention); err != nil {
 				slog.Error("Error adding played item", "error", err)
 			}
-			return p.Text
+			return p
 		}
 	}
 
 	// Fallback, should ideally not be reached if totalWeight > 0
 	if len(phrases) > 0 {
-		return phrases[0].Text
+		return phrases[0]
+	}
+	return Phrase{}
+}
+
+// singFilterFromEnv returns the filter for songs the fish picks on its own.
+// FISH_SING_CATEGORIES and FISH_SING_TAGS take comma separated lists; by
+// default only clips in the song category are picked, regardless of tags.
+func singFilterFromEnv() library.Filter {
+	categories := library.ParseTags(os.Getenv("FISH_SING_CATEGORIES"))
+	if len(categories) == 0 {
+		categories = []string{library.CategorySong}
+	}
+	return library.Filter{
+		Categories: categories,
+		Tags:       library.ParseTags(os.Getenv("FISH_SING_TAGS")),
+	}
+}
+
+// piperFromEnv returns a client for the Piper server at url that caches
+// synthesized audio in cacheDir. FISH_PIPER_URL overrides url, e.g. to use
+// piper-fake.
+// FISH_PIPER_TIMEOUT bounds each request, e.g. "45s", and FISH_PIPER_RETRIES
+// sets how often failed requests are retried. FISH_TTS_CACHE_DIR overrides
+// cacheDir, "off" disables the cache, and FISH_TTS_CACHE_MB bounds its size.
+func piperFromEnv(url, cacheDir string) *piper.PiperClient {
+	if env := os.Getenv("FISH_PIPER_URL"); env != "" {
+		url = env
+	}
+	client := piper.NewPiperClient(url)
+	if env := os.Getenv("FISH_PIPER_TIMEOUT"); env != "" {
+		timeout, err := time.ParseDuration(env)
+		if err != nil || timeout <= 0 {
+			logger.Fatal("Invalid FISH_PIPER_TIMEOUT", "value", env)
+		}
+		client.Timeout = timeout
+	}
+	if env := os.Getenv("FISH_PIPER_RETRIES"); env != "" {
+		retries, err := strconv.Atoi(env)
+		if err != nil || retries < 0 {
+			logger.Fatal("Invalid FISH_PIPER_RETRIES", "value", env)
+		}
+		client.Retries = retries
+	}
+
+	if env := os.Getenv("FISH_TTS_CACHE_DIR"); env != "" {
+		cacheDir = env
+	}
+	if cacheDir == "off" {
+		return client
+	}
+	cacheSize := int64(piper.DefaultCacheSize)
+	if env := os.Getenv("FISH_TTS_CACHE_MB"); env != "" {
+		megabytes, err := strconv.Atoi(env)
+		if err != nil || megabytes <= 0 {
+			logger.Fatal("Invalid FISH_TTS_CACHE_MB", "value", env)
+		}
+		cacheSize = int64(megabytes) << 20
 	}
-	return ""
+	cache, err := piper.NewCache(cacheDir, cacheSize)
+	if err != nil {
+		slog.Error("Running without tts cache", "error", err)
+		return client
+	}
+	client.Cache = cache
+	return client
+}
+
+// ttsFromEnv returns the engines to speak with, in the order given by
+// FISH_TTS_ENGINES: "piper" for piperClient and "espeak" for a local
+// espeak-ng. By default Piper is used with espeak-ng as fallback.
+func ttsFromEnv(piperClient *piper.PiperClient) *tts.Failover {
+	names := library.ParseTags(os.Getenv("FISH_TTS_ENGINES"))
+	if len(names) == 0 {
+		names = []string{"piper", "espeak"}
+	}
+	var engines []tts.Synthesizer
+	for _, name := range names {
+		switch name {
+		case "piper":
+			engines = append(engines, piperClient)
+		case "espeak":
+			engines = append(engines, tts.NewEspeak())
+		default:
+			logger.Fatal("Invalid FISH_TTS_ENGINES", "engine", name)
+		}
+	}
+	return tts.NewFailover(engines...)
+}
+
+// warmPhraseCache synthesizes the phrase catalog ahead of time, so the
+// phrases play at once and keep working while Piper is down. Phrases that
+// are cached already cost nothing.
+func warmPhraseCache(ctx context.Context, piperClient *piper.PiperClient) {
+	if piperClient.Cache == nil {
+		return
+	}
+	warmed := 0
+	for _, phrase := range phraseCatalog() {
+		steps, err := parsePerformance(phrase.Text, phrase.Voice, phrase.Effects, true)
+		if err != nil {
+			slog.Warn("Invalid markup in phrase", "text", phrase.Text, "error", err)
+			continue
+		}
+		for _, step := range steps {
+			// Texts are synthesized sentence by sentence, so cache those
+			for _, sentence := range tts.Sentences(step.Text) {
+				if ctx.Err() != nil {
+					return
+				}
+				if _, err := piperClient.Synthesize(ctx, sentence, step.Voice); err != nil {
+					slog.Warn("Failed to warm tts cache, trying again on the next start", "text", sentence, "error", err)
+					return
+				}
+			}
+		}
+		warmed++
+	}
+	slog.Info("Warmed tts cache", "phrases", warmed)
 }
 
-func sing(ctx context.Context, myFish *fish.Fish, soundDir string) {
-	allFiles, err := os.ReadDir(soundDir)
+func sing(ctx context.Context, myFish *fish.Fish, filter library.Filter) {
+	clips, err := library.ListClips()
 	if err != nil {
-		slog.Error("failed to read sound directory", "directory", soundDir, "error", err)
+		slog.Error("failed to list sound clips", "error", err)
 		return
 	}
 
-	var audioFiles []os.DirEntry
-	for _, file := range allFiles {
-		ext := strings.ToLower(filepath.Ext(file.Name()))
-		if !file.IsDir() && (ext == ".wav" || ext == ".mp3") {
-			audioFiles = append(audioFiles, file)
+	var audioFiles []library.Clip
+	for _, clip := range clips {
+		if filter.Allows(clip) {
+			audioFiles = append(audioFiles, clip)
 		}
 	}
 
-	var availableFiles []os.DirEntry
+	var availableFiles []library.Clip
 	playedItems, err := playlist.GetPlayedItems()
 	if err != nil {
 		slog.Error("Error getting played items", "error", err)
@@ -209,7 +356,7 @@ func sing(ctx context.Context, myFish *fish.Fish, soundDir string) {
 		}
 
 		for _, file := range audioFiles {
-			if !recentlyPlayed[file.Name()] {
+			if !recentlyPlayed[file.Name] {
 				availableFiles = append(availableFiles, file)
 			}
 		}
@@ -222,20 +369,42 @@ func sing(ctx context.Context, myFish *fish.Fish, soundDir string) {
 	}
 
 	if len(availableFiles) == 0 {
-		slog.Info("no .wav or .mp3 files found to sing, skipping.")
+		slog.Info("no enabled .wav or .mp3 files match the sing filter, skipping.", "categories", filter.Categories, "tags", filter.Tags)
 		return
 	}
 
 	randomFile := availableFiles[rand.Intn(len(availableFiles))]
-	if err := myFish.PlaySoundFile(ctx, randomFile.Name()); err != nil {
-		slog.Error("Failed to play song", "file", randomFile.Name(), "error", err)
+	if err := myFish.PlaySoundFile(ctx, randomFile.Name); err != nil {
+		slog.Error("Failed to play song", "file", randomFile.Name, "error", err)
+	}
+}
+
+// promoteScheduledItems moves scheduled items that are due into the playback queue.
+// Cron expressions are evaluated in loc, the same location the fish cycle runs in.
+func promoteScheduledItems(loc *time.Location) {
+	promoted, err := playlist.PromoteDueItems(time.Now().In(loc), func(name string) bool {
+		exists, err := library.Exists(name)
+		if err != nil {
+			// Let playing it report the problem
+			slog.Error("Failed to look up scheduled sound", "name", name, "error", err)
+			return true
+		}
+		return exists
+	})
+	if err != nil {
+		slog.Error("Error promoting scheduled items", "error", err)
+	}
+	for _, item := range promoted {
+		slog.Info("Queued scheduled item", "name", item.Name, "type", item.Type)
 	}
 }
 
-func runFishCycle(myFish *fish.Fish, piperClient *piper.PiperClient, soundDir string, enableTTS bool) {
+func runFishCycle(myFish *fish.Fish, synth tts.Synthesizer, singFilter library.Filter, enableTTS bool, loc *time.Location) {
 	ctx, span := otel.Tracer("fish-cycle").Start(context.Background(), "RunFishCycle")
 	defer span.End()
 
+	promoteScheduledItems(loc)
+
 	slog.Info("Raising body...")
 	myFish.Lock()
 	if err := myFish.RaiseBody(); err != nil {
@@ -245,6 +414,9 @@ func runFishCycle(myFish *fish.Fish, piperClient *piper.PiperClient, soundDir st
 	myFish.Unlock()
 	time.Sleep(1 * time.Second)
 
+	// Alerts queued meanwhile interrupt whatever the fish does
+	stopAlerts := watchAlerts(ctx, myFish)
+
 	// Check for queued items first
 	queueItem, err := playlist.GetNextQueueItem()
 	if err != nil {
@@ -253,10 +425,15 @@ func runFishCycle(myFish *fish.Fish, piperClient *piper.PiperClient, soundDir st
 	}
 
 	if queueItem != nil {
-		slog.Info("Playing queued item", "name", queueItem.Name, "type", queueItem.Type)
+		source := playlist.SourceQueue
+		if queueItem.Source != "" {
+			source = queueItem.Source
+		}
+		ctx := fish.WithRequester(fish.WithSource(ctx, source), queueItem.RequestedBy)
+		slog.Info("Playing queued item", "name", queueItem.Name, "type", queueItem.Type, "source", source, "requested_by", queueItem.RequestedBy)
 		actionCounter.Add(ctx, 1, metric.WithAttributes(
 			attribute.String("type", queueItem.Type),
-			attribute.String("source", "queue"),
+			attribute.String("source", source),
 		))
 		span.SetAttributes(
 			attribute.String("action.type", "queue"),
@@ -265,13 +442,25 @@ func runFishCycle(myFish *fish.Fish, piperClient *piper.PiperClient, soundDir st
 		)
 		switch queueItem.Type {
 		case "song":
-			if err := myFish.PlaySoundFile(ctx, queueItem.Name); err != nil {
+			if err := playQueuedSong(ctx, myFish, queueItem); err != nil {
 				slog.Error("Failed to play sound file", "file", queueItem.Name, "error", err)
 				span.RecordError(err)
 			}
 		case "text":
+			item := playlist.PlayedItem{
+				Name:        queueItem.Name,
+				Type:        "text",
+				Timestamp:   time.Now(),
+				RequestedBy: queueItem.RequestedBy,
+				Source:      source,
+			}
+			if err := playlist.AddPlayedItem(item, playlist.HistoryRetention); err != nil {
+				slog.Error("Error adding played item", "error", err)
+			}
 			if enableTTS {
-				if err := myFish.Say(ctx, piperClient, queueItem.Name); err != nil {
+				// Only scheduled texts may contain markup, like the catalog
+				markup := queueItem.Source == playlist.SourceSchedule
+				if err := say(ctx, myFish, synth, queueItem.Name, queueItem.Voice, queueItem.Effects, markup); err != nil {
 					slog.Error("Failed to say text", "text", queueItem.Name, "error", err)
 					span.RecordError(err)
 				}
@@ -281,34 +470,36 @@ func runFishCycle(myFish *fish.Fish, piperClient *piper.PiperClient, soundDir st
 		}
 	} else {
 		// No queued items, do random action
+		ctx := fish.WithSource(ctx, playlist.SourceRandom)
 		action := rand.Intn(2)
 		if action == 0 {
-			phraseToSay := getWeightedRandomPhrase()
+			phrase := getWeightedRandomPhrase()
 			actionCounter.Add(ctx, 1, metric.WithAttributes(
 				attribute.String("type", "text"),
-				attribute.String("source", "random"),
+				attribute.String("source", playlist.SourceRandom),
 			))
 			span.SetAttributes(
 				attribute.String("action.type", "random_phrase"),
-				attribute.String("phrase", phraseToSay),
+				attribute.String("phrase", phrase.Text),
 			)
 			if enableTTS {
-				if err := myFish.Say(ctx, piperClient, phraseToSay); err != nil {
-					slog.Error("Failed to say phrase", "text", phraseToSay, "error", err)
+				if err := say(ctx, myFish, synth, phrase.Text, phrase.Voice, phrase.Effects, true); err != nil {
+					slog.Error("Failed to say phrase", "text", phrase.Text, "error", err)
 					span.RecordError(err)
 				}
 			} else {
-				slog.Info("Would say", "text", phraseToSay)
+				slog.Info("Would say", "text", phrase.Text)
 			}
 		} else {
 			actionCounter.Add(ctx, 1, metric.WithAttributes(
 				attribute.String("type", "song"),
-				attribute.String("source", "random"),
+				attribute.String("source", playlist.SourceRandom),
 			))
 			span.SetAttributes(attribute.String("action.type", "random_song"))
-			sing(ctx, myFish, soundDir)
+			sing(ctx, myFish, singFilter)
 		}
 	}
+	stopAlerts()
 
 	time.Sleep(1 * time.Second)
 	slog.Info("Stopping body...")
@@ -9,10 +9,11 @@ import (
 
 	"github.com/robfig/cron/v3"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/fish"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/logger"
-	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/telemetry"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
 )
 
 func main() {
@@ -31,9 +32,18 @@ func main() {
 		}()
 	}
 
+	// Schedules and volume curves follow local office time
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		logger.Fatal("Error loading location", "error", err)
+	}
+
 	// Initialize Playlist with the correct mount path
 	// The container mounts the volume at /sound-data
 	playlist.Init("/sound-data")
+	library.Init("/sound-data")
+	volume.Init("/sound-data", loc)
+	voiceProfilesPath = "/sound-data/voices.json"
 
 	myFish, err := fish.NewFish("gpiochip0")
 	if err != nil {
@@ -43,31 +53,30 @@ func main() {
 
 	slog.Info("Audio system ready.")
 
-	piperClient := piper.NewPiperClient("http://piper:5000")
+	piperClient := piperFromEnv("http://piper:5000", "/sound-data/tts-cache")
+
+	synth := ttsFromEnv(piperClient)
+	go synth.Watch(ctx, 30*time.Second)
 
-	if err := myFish.Say(context.Background(), piperClient, "Hallo Ich bins! Bin wieder da und ready!"); err != nil {
+	if err := myFish.Say(context.Background(), synth, "Hallo Ich bins! Bin wieder da und ready!", voiceFor("")); err != nil {
 		slog.Error("Failed to say greeting", "error", err)
 	}
+	go warmPhraseCache(ctx, piperClient)
 	myFish.Lock()
 	myFish.StopBody()
 	myFish.StopMouth()
 	myFish.Unlock()
 
-	loc, err := time.LoadLocation("Europe/Berlin")
-	if err != nil {
-		logger.Fatal("Error loading location", "error", err)
-	}
-
 	c := cron.New(
 		cron.WithLocation(loc),
 		cron.WithChain(cron.SkipIfStillRunning(&logger.CronLogger{Logger: slog.Default()})),
 	)
 
-	soundDir := "/sound-data"
+	singFilter := singFilterFromEnv()
 	enableTTS := true
 
 	c.AddFunc("* * * * *", func() {
-		runFishCycle(myFish, piperClient, soundDir, enableTTS)
+		runFishCycle(myFish, synth, singFilter, enableTTS, loc)
 	})
 	go c.Start()
 
@@ -9,10 +9,11 @@ import (
 
 	"github.com/robfig/cron/v3"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/fish"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/logger"
-	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/telemetry"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
 )
 
 func main() {
@@ -31,8 +32,16 @@ func main() {
 		}()
 	}
 
+	// Schedules and volume curves follow local office time
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		logger.Fatal("Error loading location", "error", err)
+	}
+
 	// Initialize Playlist with local path for development
 	playlist.Init("./sound-data")
+	library.Init("./sound-data")
+	volume.Init("./sound-data", loc)
 
 	myFish, err := fish.NewFish("") // Empty string for chipName on macOS
 	if err != nil {
@@ -46,7 +55,8 @@ func main() {
 	// 1. Comment out the piper client and Say() call (default)
 	// 2. Run a local piper server
 	// 3. Change the URL to a remote piper server
-	piperClient := piper.NewPiperClient("http://localhost:10200")
+	piperClient := piperFromEnv("http://localhost:10200", "./sound-data/tts-cache")
+	synth := ttsFromEnv(piperClient)
 
 	// Test audio without TTS
 	slog.Info("Starting fish in macOS mode...")
@@ -55,21 +65,20 @@ func main() {
 	myFish.StopMouth()
 	myFish.Unlock()
 
-	loc, err := time.LoadLocation("Europe/Berlin")
-	if err != nil {
-		logger.Fatal("Error loading location", "error", err)
-	}
-
 	c := cron.New(
 		cron.WithLocation(loc),
 		cron.WithChain(cron.SkipIfStillRunning(&logger.CronLogger{Logger: slog.Default()})),
 	)
 
-	soundDir := "./sound-data"
+	singFilter := singFilterFromEnv()
 	enableTTS := true // Set to true if you have piper running
+	if enableTTS {
+		go synth.Watch(ctx, 30*time.Second)
+		go warmPhraseCache(ctx, piperClient)
+	}
 
 	c.AddFunc("* * * * *", func() {
-		runFishCycle(myFish, piperClient, soundDir, enableTTS)
+		runFishCycle(myFish, synth, singFilter, enableTTS, loc)
 	})
 	go c.Start()
 
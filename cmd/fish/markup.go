@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/dsp"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/fish"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/tts"
+)
+
+// maxPause bounds [pause], so a typo cannot silence the fish for hours.
+const maxPause = 10 * time.Second
+
+// emphasisStretch slows down emphasized text, see emphasize.
+const emphasisStretch = 1.3
+
+// emphasize returns voice slowed down by emphasisStretch, within the range
+// Piper handles.
+func emphasize(voice piper.Voice) piper.Voice {
+	scale := voice.LengthScale
+	if scale == 0 {
+		scale = 1
+	}
+	voice.LengthScale = min(scale*emphasisStretch, 5)
+	return voice
+}
+
+// parsePerformance compiles text with markup into the steps of a
+// performance, starting in the voice profile called voice with the effects
+// chain effects (see dsp.Parse):
+//
+//	[pause 500ms]        a silence of up to maxPause
+//	[sound airhorn.mp3]  a clip from the library
+//	[bed waves.mp3]      a clip from the library looped under the following
+//	                     text, ducked while the fish speaks; [bed] stops it
+//	[voice english]      the voice profile of the following text, [voice]
+//	                     returns to the starting voice
+//	[effects radio]      the effects of the following text and sounds,
+//	                     [effects] returns to the starting effects
+//	[emphasis]…[/emphasis]
+//	                     text said slower in the current voice, for
+//	                     emphasis
+//	[move tail]          a move: body, tail or stop
+//
+// Text between the tags is said. Unknown tags are an error. Without markup
+// the whole text is said as it is, tags included.
+func parsePerformance(text, voice, effects string, markup bool) ([]fish.Step, error) {
+	profiles, err := piper.LoadProfiles(voiceProfilesPath)
+	if err != nil {
+		slog.Error("Failed to load voice profiles", "error", err)
+	}
+	current, ok := profiles.Get(voice)
+	if !ok {
+		return nil, fmt.Errorf("unknown voice %q", voice)
+	}
+	start := current
+	startChain, err := dsp.Parse(effects)
+	if err != nil {
+		return nil, err
+	}
+	chain := startChain
+
+	emphasized := false
+
+	var steps []fish.Step
+	addText := func(text string) {
+		if text = strings.TrimSpace(text); text != "" {
+			voice := current
+			if emphasized {
+				voice = emphasize(voice)
+			}
+			steps = append(steps, fish.Step{Text: text, Voice: voice, Effects: chain})
+		}
+	}
+
+	var matches [][]int
+	if markup {
+		matches = tts.MarkupTag.FindAllStringSubmatchIndex(text, -1)
+	}
+	last := 0
+	for _, match := range matches {
+		addText(text[last:match[0]])
+		last = match[1]
+
+		name := strings.ToLower(text[match[2]:match[3]])
+		var arg string
+		if match[4] >= 0 {
+			arg = strings.TrimSpace(text[match[4]:match[5]])
+		}
+		switch name {
+		case "pause":
+			pause, err := time.ParseDuration(arg)
+			if err != nil || pause <= 0 || pause > maxPause {
+				return nil, fmt.Errorf("invalid pause %q, want e.g. 500ms up to %v", arg, maxPause)
+			}
+			steps = append(steps, fish.Step{Pause: pause})
+		case "sound":
+			if arg == "" || library.SafeName(arg, filepath.Ext(arg)) != arg || library.IsReservedName(arg) {
+				return nil, fmt.Errorf("invalid sound %q", arg)
+			}
+			steps = append(steps, fish.Step{Sound: arg, Effects: chain})
+		case "bed":
+			if arg == "" {
+				steps = append(steps, fish.Step{StopBed: true})
+				continue
+			}
+			if library.SafeName(arg, filepath.Ext(arg)) != arg || library.IsReservedName(arg) {
+				return nil, fmt.Errorf("invalid bed %q", arg)
+			}
+			steps = append(steps, fish.Step{Bed: arg, Effects: chain})
+		case "voice":
+			if arg == "" {
+				current = start
+				continue
+			}
+			if current, ok = profiles.Get(arg); !ok {
+				return nil, fmt.Errorf("unknown voice %q", arg)
+			}
+		case "effects":
+			if arg == "" {
+				chain = startChain
+				continue
+			}
+			if chain, err = dsp.Parse(arg); err != nil {
+				return nil, err
+			}
+		case "emphasis":
+			if emphasized {
+				return nil, fmt.Errorf("[emphasis] within [emphasis]")
+			}
+			emphasized = true
+		case "/emphasis":
+			if !emphasized {
+				return nil, fmt.Errorf("[/emphasis] without [emphasis]")
+			}
+			emphasized = false
+		case "move":
+			move := fish.Move(strings.ToLower(arg))
+			if !slices.Contains(fish.Moves, move) {
+				return nil, fmt.Errorf("invalid move %q, want one of %v", arg, fish.Moves)
+			}
+			steps = append(steps, fish.Step{Move: move})
+		default:
+			return nil, fmt.Errorf("unknown tag [%s]", name)
+		}
+	}
+	addText(text[last:])
+	return steps, nil
+}
+
+// say performs text starting in the voice profile called voice with the
+// effects chain effects. Markup is only performed if markup is set, which
+// it is for catalog phrases and scheduled texts. Texts users queue are said
+// as they are. Text with invalid markup is said as is.
+func say(ctx context.Context, myFish *fish.Fish, synth tts.Synthesizer, text, voice, effects string, markup bool) error {
+	steps, err := parsePerformance(text, voice, effects, markup)
+	if err != nil {
+		slog.Warn("Invalid markup, saying the text as is", "text", text, "error", err)
+		return myFish.Say(ctx, synth, text, voiceFor(voice))
+	}
+	title := text
+	if markup {
+		title = tts.StripMarkup(text)
+	}
+	if title == "" {
+		title = text
+	}
+	slog.Info("performing", "text", text, "steps", len(steps))
+	return myFish.Perform(ctx, synth, title, steps)
+}
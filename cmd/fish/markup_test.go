@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/dsp"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/fish"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper/pipertest"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+)
+
+// useDefaultProfiles points the fish at a missing profiles file, so only
+// piper.DefaultProfiles exist.
+func useDefaultProfiles(t *testing.T) {
+	previous := voiceProfilesPath
+	voiceProfilesPath = filepath.Join(t.TempDir(), "voices.json")
+	t.Cleanup(func() { voiceProfilesPath = previous })
+}
+
+func TestParsePerformancePause(t *testing.T) {
+	useDefaultProfiles(t)
+
+	steps, err := parsePerformance("Achtung [pause 10s] Blubb", "", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 || steps[0].Text != "Achtung" || steps[1].Pause != maxPause || steps[2].Text != "Blubb" {
+		t.Errorf("Expected text, the longest pause and text, got %+v", steps)
+	}
+
+	for _, pause := range []string{"11s", "0s", "-1s", "1h", "soon", ""} {
+		if _, err := parsePerformance("Achtung [pause "+pause+"] Blubb", "", "", true); err == nil {
+			t.Errorf("Expected pause %q to be rejected", pause)
+		}
+	}
+}
+
+func TestParsePerformanceUnknownTag(t *testing.T) {
+	useDefaultProfiles(t)
+
+	for _, text := range []string{"[shout] Hallo", "Hallo [/pause]", "[voice pirate] Ahoi", "[effects chorus] Hallo", "[move fins]"} {
+		if _, err := parsePerformance(text, "", "", true); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestParsePerformanceResets(t *testing.T) {
+	useDefaultProfiles(t)
+
+	steps, err := parsePerformance("Moin [voice english] Hello [voice] Tschüss", "karlsson", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	karlsson, _ := piper.DefaultProfiles.Get("karlsson")
+	english, _ := piper.DefaultProfiles.Get("english")
+	if len(steps) != 3 || steps[0].Voice != karlsson || steps[1].Voice != english || steps[2].Voice != karlsson {
+		t.Errorf("Expected [voice] to return to the starting voice, got %+v", steps)
+	}
+
+	steps, err = parsePerformance("Moin [effects pitch] Blubb [effects] Tschüss [sound bell.wav]", "", "radio", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	radio, _ := dsp.Parse("radio")
+	pitch, _ := dsp.Parse("pitch")
+	if len(steps) != 4 ||
+		!reflect.DeepEqual(steps[0].Effects, radio) ||
+		!reflect.DeepEqual(steps[1].Effects, pitch) ||
+		!reflect.DeepEqual(steps[2].Effects, radio) ||
+		!reflect.DeepEqual(steps[3].Effects, radio) {
+		t.Errorf("Expected [effects] to return to the starting effects, got %+v", steps)
+	}
+}
+
+func TestParsePerformanceEmphasis(t *testing.T) {
+	useDefaultProfiles(t)
+
+	steps, err := parsePerformance("Das ist [emphasis]sehr wichtig[/emphasis], wirklich", "sleepy", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sleepy, _ := piper.DefaultProfiles.Get("sleepy")
+	if len(steps) != 3 || steps[0].Voice != sleepy || steps[2].Voice != sleepy {
+		t.Fatalf("Expected the text around the emphasis in the starting voice, got %+v", steps)
+	}
+	if want := sleepy.LengthScale * emphasisStretch; steps[1].Text != "sehr wichtig" || steps[1].Voice.LengthScale != want {
+		t.Errorf("Expected the emphasized text at length scale %g, got %+v", want, steps[1])
+	}
+
+	steps, err = parsePerformance("[emphasis][voice english]Stop[/emphasis]", "", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 1 || steps[0].Voice.Voice != "en_US-lessac-low" || steps[0].Voice.LengthScale != emphasisStretch {
+		t.Errorf("Expected the emphasis to slow down the current voice, got %+v", steps)
+	}
+
+	for _, text := range []string{"[emphasis] a [emphasis] b", "a [/emphasis]"} {
+		if _, err := parsePerformance(text, "", "", true); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestParsePerformanceWithoutMarkup(t *testing.T) {
+	useDefaultProfiles(t)
+
+	text := "Sag mal [pause 1h] und [shout] "
+	steps, err := parsePerformance(text, "english", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	english, _ := piper.DefaultProfiles.Get("english")
+	if len(steps) != 1 || steps[0].Text != strings.TrimSpace(text) || steps[0].Voice != english {
+		t.Errorf("Expected the text to be said as is, got %+v", steps)
+	}
+}
+
+func TestSayInvalidMarkup(t *testing.T) {
+	useDefaultProfiles(t)
+	playlist.Init(t.TempDir())
+
+	myFish, err := fish.NewFish("mock-chip")
+	if err != nil {
+		t.Skipf("Audio initialization failed (expected in headless env): %v", err)
+	}
+	defer myFish.Close()
+
+	server := pipertest.NewServer()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	text := "Mittag [pause 1h] jetzt"
+	if err := say(context.Background(), myFish, piper.NewPiperClient(ts.URL), text, "", "", true); err != nil {
+		t.Fatal(err)
+	}
+	requests := server.Requests()
+	if len(requests) == 0 {
+		t.Fatal("Expected the text to be synthesized")
+	}
+	var said []string
+	for _, req := range requests {
+		said = append(said, req.Text)
+	}
+	if got := strings.Join(said, " "); got != text {
+		t.Errorf("Expected the invalid markup to be said as is, got %q", got)
+	}
+}
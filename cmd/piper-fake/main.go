@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/logger"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper/pipertest"
+)
+
+func main() {
+	logger.Setup()
+	fake := pipertest.NewServer()
+	var addr string
+	var voices string
+
+	flag.StringVar(&addr, "addr", ":10200", "Address to listen on")
+	flag.StringVar(&voices, "voices", strings.Join(pipertest.DefaultVoices, ","), "Comma separated voices to offer")
+	flag.IntVar(&fake.SampleRate, "sample-rate", pipertest.DefaultSampleRate, "Sample rate of the audio")
+	flag.DurationVar(&fake.Latency, "latency", 0, "Delay of every synthesis, e.g. 2s")
+	flag.IntVar(&fake.FailEvery, "fail-every", 0, "Fail every nth synthesis, 0 never fails")
+	flag.IntVar(&fake.FailStatus, "fail-status", http.StatusServiceUnavailable, "Status of failed syntheses")
+	flag.Parse()
+
+	fake.Voices = nil
+	for _, voice := range strings.Split(voices, ",") {
+		if voice = strings.TrimSpace(voice); voice != "" {
+			fake.Voices = append(fake.Voices, voice)
+		}
+	}
+	if len(fake.Voices) == 0 {
+		logger.Fatal("At least one voice is needed")
+	}
+	if fake.SampleRate <= 0 || fake.Latency < 0 || fake.FailEvery < 0 || fake.FailStatus < 400 || fake.FailStatus > 599 {
+		logger.Fatal("Invalid flags")
+	}
+
+	slog.Info("Serving fake Piper", "addr", addr, "voices", fake.Voices, "latency", fake.Latency, "fail_every", fake.FailEvery)
+	server := &http.Server{Addr: addr, Handler: fake, ReadHeaderTimeout: 10 * time.Second}
+	if err := server.ListenAndServe(); err != nil {
+		logger.Fatal("Failed to serve", "error", err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log/slog"
 	"os"
@@ -13,17 +14,32 @@ func main() {
 	logger.Setup()
 	var text string
 	var outputFile string
+	var profile string
+	var profilesFile string
+	var url string
 
 	flag.StringVar(&text, "text", "Hallo Yebba", "Text to synthesize")
 	flag.StringVar(&outputFile, "output", "test.wav", "Output file path")
+	flag.StringVar(&profile, "voice", piper.DefaultProfile, "Voice profile to speak in")
+	flag.StringVar(&profilesFile, "profiles", "./sound-data/voices.json", "File with custom voice profiles")
+	flag.StringVar(&url, "url", "http://localhost:10200", "URL of the Piper server, e.g. of piper-fake")
 	flag.Parse()
 
 	if text == "" {
 		logger.Fatal("Text to synthesize cannot be empty")
 	}
 
-	client := piper.NewPiperClient("http://localhost:10200")
-	audioData, err := client.Synthesize(text)
+	profiles, err := piper.LoadProfiles(profilesFile)
+	if err != nil {
+		logger.Fatal("Failed to load voice profiles", "error", err)
+	}
+	voice, ok := profiles.Get(profile)
+	if !ok {
+		logger.Fatal("Unknown voice profile", "voice", profile, "available", profiles.Names())
+	}
+
+	client := piper.NewPiperClient(url)
+	audioData, err := client.Synthesize(context.Background(), text, voice)
 	if err != nil {
 		logger.Fatal("Failed to synthesize text", "error", err)
 	}
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/middleware"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/api"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/camera"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/dsp"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/quota"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
+)
+
+// APIHandler serves the JSON API under api.BasePath. Its types live in
+// pkg/api, the routes are documented in static/openapi.yaml.
+type APIHandler struct {
+	Location *time.Location
+	Cam      *camera.Camera
+	// Quota limits how much each user may queue.
+	Quota *quota.Quota
+}
+
+func apiError(c *gin.Context, status int, message string) {
+	c.JSON(status, api.Error{Message: message})
+}
+
+func newAPISound(clip library.Clip) api.Sound {
+	sound := api.Sound{
+		Name:       clip.Name,
+		Title:      clip.Title,
+		Category:   clip.Category,
+		Tags:       clip.Tags,
+		Enabled:    clip.Enabled,
+		Duration:   clip.PlaybackDuration().Seconds(),
+		PlayCount:  clip.PlayCount,
+		Uploader:   clip.Uploader,
+		UploadedAt: clip.UploadedAt,
+	}
+	if sound.Tags == nil {
+		sound.Tags = []string{}
+	}
+	if clip.Loudness != nil {
+		sound.Loudness = &clip.Loudness.Integrated
+	}
+	return sound
+}
+
+func newAPIQueueEntry(eta playlist.QueueETA) api.QueueEntry {
+	return api.QueueEntry{
+		ID:          eta.ID,
+		Name:        eta.Name,
+		Type:        eta.Type,
+		Source:      eta.Source,
+		Duration:    eta.Duration.Seconds(),
+		StartsAt:    eta.StartsAt,
+		RequestedBy: eta.RequestedBy,
+		Voice:       eta.Voice,
+		Effects:     eta.Effects,
+	}
+}
+
+// Me returns the user the token belongs to.
+func (h *APIHandler) Me(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	c.JSON(http.StatusOK, api.User{Name: user.Name, Role: string(user.Role)})
+}
+
+// Sounds lists the library, filtered like the search in the web UI.
+func (h *APIHandler) Sounds(c *gin.Context) {
+	sounds := []api.Sound{}
+	for _, soundFile := range SearchSoundFiles(c.Query("q"), c.Query("category")) {
+		sounds = append(sounds, newAPISound(soundFile.Clip))
+	}
+	c.JSON(http.StatusOK, sounds)
+}
+
+// Sound returns a single clip of the library.
+func (h *APIHandler) Sound(c *gin.Context) {
+	name := c.Param("name")
+	exists, err := library.Exists(name)
+	if err != nil {
+		slog.Error("Failed to look up sound", "name", name, "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to look up sound")
+		return
+	}
+	if !exists {
+		apiError(c, http.StatusNotFound, "Sound not found")
+		return
+	}
+	clip, err := library.GetClip(name)
+	if err != nil {
+		slog.Error("Failed to get clip", "name", name, "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to look up sound")
+		return
+	}
+	if clip == nil {
+		defaults := library.DefaultClip(name)
+		clip = &defaults
+	}
+	c.JSON(http.StatusOK, newAPISound(*clip))
+}
+
+// Upload adds the file in the multipart field "file" to the library.
+func (h *APIHandler) Upload(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, library.MaxUploadSize+1<<20)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, api.Error{Message: "File is too large", Reason: library.ReasonTooLarge})
+			return
+		}
+		apiError(c, http.StatusBadRequest, "The multipart field \"file\" is required")
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "Failed to read upload")
+		return
+	}
+	defer src.Close()
+
+	clip, err := library.Ingest(src, file.Filename, file.Size, currentUser(c))
+	if err != nil {
+		var ingestErr *library.IngestError
+		if errors.As(err, &ingestErr) {
+			slog.Warn("Rejected upload", "filename", file.Filename, "reason", ingestErr.Reason, "error", err)
+			c.JSON(http.StatusUnprocessableEntity, api.Error{Message: ingestErr.Message, Reason: ingestErr.Reason})
+			return
+		}
+		slog.Error("Failed to save upload", "filename", file.Filename, "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to save file")
+		return
+	}
+	slog.Info("Uploaded sound", "name", clip.Name, "original", file.Filename, "duration", clip.Duration, "via", "api")
+	record(c, audit.ActionUpload, clip.Name, file.Filename)
+	uploadsCounter.Add(c.Request.Context(), 1)
+	c.JSON(http.StatusCreated, newAPISound(*clip))
+}
+
+// DeleteSound moves a clip to the trash.
+func (h *APIHandler) DeleteSound(c *gin.Context) {
+	if err := deleteSound(c, c.Param("name")); err != nil {
+		if errors.Is(err, library.ErrClipNotFound) {
+			apiError(c, http.StatusNotFound, "Sound not found")
+			return
+		}
+		slog.Error("Failed to delete sound", "name", c.Param("name"), "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to delete sound")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// queueEntries returns the queue with the expected start of every entry.
+func (h *APIHandler) queueEntries() ([]api.QueueEntry, error) {
+	queueItems, err := playlist.GetQueueItems()
+	if err != nil {
+		return nil, err
+	}
+	entries := []api.QueueEntry{}
+	for _, eta := range GetQueueETAs(queueItems, h.Location) {
+		entries = append(entries, newAPIQueueEntry(eta))
+	}
+	return entries, nil
+}
+
+// Queue returns the queued songs and texts in playback order.
+func (h *APIHandler) Queue(c *gin.Context) {
+	entries, err := h.queueEntries()
+	if err != nil {
+		slog.Error("Failed to get queue items", "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to get queue")
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// enqueue adds item to the queue and responds with its queue entry.
+func (h *APIHandler) enqueue(c *gin.Context, item playlist.QueueItem) {
+	if err := takeQuota(c, h.Quota, item); err != nil {
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			c.JSON(http.StatusTooManyRequests, api.Error{Message: exceeded.Message, Reason: exceeded.Reason})
+			return
+		}
+		slog.Error("Failed to get queue items", "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to queue playback")
+		return
+	}
+	queued, err := playlist.AddToQueue(item)
+	if err != nil {
+		slog.Error("Failed to add to queue", "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to queue playback")
+		return
+	}
+	slog.Info("Queued playback", "name", item.Name, "type", item.Type, "by", currentUser(c), "via", "api")
+	if item.Type == "text" {
+		record(c, audit.ActionSay, item.Name, "")
+	} else {
+		record(c, audit.ActionQueue, item.Name, "")
+	}
+
+	entries, err := h.queueEntries()
+	if err != nil {
+		slog.Error("Failed to get queue items", "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to get queue")
+		return
+	}
+	queueDepthGauge.Record(c.Request.Context(), int64(len(entries)))
+	for _, entry := range entries {
+		if entry.ID == queued.ID {
+			c.JSON(http.StatusCreated, entry)
+			return
+		}
+	}
+	// The fish already took the item off the queue
+	c.JSON(http.StatusCreated, newAPIQueueEntry(playlist.QueueETA{QueueItem: *queued, StartsAt: time.Now()}))
+}
+
+// Enqueue queues a sound from the library.
+func (h *APIHandler) Enqueue(c *gin.Context) {
+	var req api.EnqueueRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	exists, err := library.Exists(req.Name)
+	if err != nil {
+		slog.Error("Failed to look up sound", "name", req.Name, "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to look up sound")
+		return
+	}
+	if !exists {
+		apiError(c, http.StatusNotFound, "Sound not found")
+		return
+	}
+	effects, ok := validEffects(c, req.Effects)
+	if !ok {
+		return
+	}
+	h.enqueue(c, playlist.QueueItem{Name: req.Name, Type: "song", Effects: effects, RequestedBy: currentUser(c)})
+}
+
+// Say queues a text for the fish to say.
+func (h *APIHandler) Say(c *gin.Context) {
+	var req api.SayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		apiError(c, http.StatusUnprocessableEntity, "Text is required")
+		return
+	}
+	if utf8.RuneCountInString(text) > api.MaxSayLength {
+		apiError(c, http.StatusUnprocessableEntity, "Text is too long")
+		return
+	}
+	voice := req.Voice
+	if voice == piper.DefaultProfile {
+		voice = ""
+	}
+	if !knownVoice(voice) {
+		apiError(c, http.StatusUnprocessableEntity, "Unknown voice")
+		return
+	}
+	effects, ok := validEffects(c, req.Effects)
+	if !ok {
+		return
+	}
+	h.enqueue(c, playlist.QueueItem{Name: text, Type: "text", Voice: voice, Effects: effects, RequestedBy: currentUser(c)})
+}
+
+// Volume returns the volume settings of the fish.
+func (h *APIHandler) Volume(c *gin.Context) {
+	settings, err := volume.Get()
+	if err != nil {
+		slog.Error("Failed to read volume settings", "error", err)
+		apiError(c, http.StatusInternalServerError, "Failed to read volume settings")
+		return
+	}
+	c.JSON(http.StatusOK, newAPIVolume(settings))
+}
+
+// SetVolume replaces the volume settings of the fish.
+func (h *APIHandler) SetVolume(c *gin.Context) {
+	var req api.Volume
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	settings := volume.Settings{Master: req.Master, Gains: map[volume.Source]float64{}, Duck: req.Duck}
+	for source, gain := range req.Gains {
+		settings.Gains[volume.Source(source)] = gain
+	}
+	for _, point := range req.Curve {
+		settings.Curve = append(settings.Curve, volume.Point{Time: point.Time, Volume: point.Volume})
+	}
+	if err := saveVolume(c, settings); err != nil {
+		if errors.Is(err, volume.ErrInvalidSettings) {
+			apiError(c, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		apiError(c, http.StatusInternalServerError, "Failed to save volume settings")
+		return
+	}
+	h.Volume(c)
+}
+
+func newAPIVolume(settings volume.Settings) api.Volume {
+	v := api.Volume{
+		Master: settings.Master,
+		Gains:  map[string]float64{},
+		Curve:  []api.VolumePoint{},
+		Duck:   settings.Duck,
+		Level:  math.Round(settings.Level(time.Now())*10) / 10,
+	}
+	for source, gain := range settings.Gains {
+		v.Gains[string(source)] = gain
+	}
+	for _, point := range settings.Curve {
+		v.Curve = append(v.Curve, api.VolumePoint{Time: point.Time, Volume: point.Volume})
+	}
+	return v
+}
+
+// validEffects trims the effects chain spec and answers 422 if it is
+// invalid.
+func validEffects(c *gin.Context, spec string) (string, bool) {
+	spec = strings.TrimSpace(spec)
+	if _, err := dsp.Parse(spec); err != nil {
+		apiError(c, http.StatusUnprocessableEntity, "Invalid effects: "+err.Error())
+		return "", false
+	}
+	return spec, true
+}
+
+// Voices lists the voice profiles texts can be said in.
+func (h *APIHandler) Voices(c *gin.Context) {
+	c.JSON(http.StatusOK, voiceProfiles().Names())
+}
+
+// History returns what the fish played recently, newest first.
+func (h *APIHandler) History(c *gin.Context) {
+	history := []api.HistoryEntry{}
+	for _, item := range GetPlayedItems() {
+		history = append(history, api.HistoryEntry{
+			Name:        item.Name,
+			Type:        item.Type,
+			PlayedAt:    item.Timestamp.In(h.Location),
+			RequestedBy: item.RequestedBy,
+		})
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// Snapshot returns the latest camera frame as JPEG.
+func (h *APIHandler) Snapshot(c *gin.Context) {
+	if !h.Cam.IsStreaming() {
+		apiError(c, http.StatusServiceUnavailable, "Camera not available")
+		return
+	}
+	frame := h.Cam.GetFrame()
+	if frame == nil {
+		apiError(c, http.StatusServiceUnavailable, "No camera frame yet")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/jpeg", frame)
+}
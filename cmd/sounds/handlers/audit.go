@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/middleware"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+)
+
+// auditPageSize is the number of entries shown on the audit page.
+const auditPageSize = 500
+
+// record adds an action of the logged in user to the audit trail.
+func record(c *gin.Context, action, target, detail string) {
+	recordAs(c, currentUser(c), action, target, detail)
+}
+
+// recordAs adds an action of user to the audit trail, for actions taken
+// before anyone is logged in.
+func recordAs(c *gin.Context, user, action, target, detail string) {
+	entry := audit.Entry{
+		User:   user,
+		IP:     c.ClientIP(),
+		Action: action,
+		Target: target,
+		Detail: detail,
+	}
+	if err := audit.Record(entry); err != nil {
+		slog.Error("Failed to record audit entry", "action", action, "user", user, "error", err)
+	}
+}
+
+// AuditHandler lets admins review and export the audit trail.
+type AuditHandler struct {
+	TemplateFS embed.FS
+	Location   *time.Location
+}
+
+// filter reads the filter form. Dates are whole days in local office time.
+func (h *AuditHandler) filter(c *gin.Context) (audit.Filter, error) {
+	filter := audit.Filter{
+		User:   c.Query("user"),
+		Action: c.Query("action"),
+		Query:  c.Query("q"),
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, h.Location)
+		if err != nil {
+			return filter, fmt.Errorf("invalid start date")
+		}
+		filter.Since = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, h.Location)
+		if err != nil {
+			return filter, fmt.Errorf("invalid end date")
+		}
+		filter.Until = t.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func (h *AuditHandler) listData(c *gin.Context) gin.H {
+	filter, err := h.filter(c)
+	if err != nil {
+		return gin.H{"entries": []audit.Entry{}, "error": err.Error()}
+	}
+	entries, err := audit.Search(filter, auditPageSize+1)
+	if err != nil {
+		slog.Error("Failed to read audit trail", "error", err)
+		return gin.H{"entries": []audit.Entry{}, "error": "Failed to read audit trail"}
+	}
+	more := len(entries) > auditPageSize
+	if more {
+		entries = entries[:auditPageSize]
+	}
+	for i := range entries {
+		entries[i].Time = entries[i].Time.In(h.Location)
+	}
+	// Encoded again, so the link carries only the parsed filter
+	query := template.URL(c.Request.URL.Query().Encode())
+	return gin.H{"entries": entries, "more": more, "query": query}
+}
+
+// Page renders the audit page.
+func (h *AuditHandler) Page(c *gin.Context) {
+	data := h.listData(c)
+	data["actions"] = audit.Actions
+	data["filter"] = gin.H{
+		"user":   c.Query("user"),
+		"action": c.Query("action"),
+		"q":      c.Query("q"),
+		"from":   c.Query("from"),
+		"to":     c.Query("to"),
+	}
+	data["csrfToken"] = middleware.CSRFToken(c)
+	if err := auditTemplate(h.TemplateFS).Execute(c.Writer, data); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to render page")
+	}
+}
+
+// List renders the entries matching the filter form.
+func (h *AuditHandler) List(c *gin.Context) {
+	auditTemplate(h.TemplateFS).ExecuteTemplate(c.Writer, "audit-list", h.listData(c))
+}
+
+// Export downloads the entries matching the filter form as JSON lines.
+func (h *AuditHandler) Export(c *gin.Context) {
+	filter, err := h.filter(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	filename := fmt.Sprintf("sebaschtian-audit-%s.jsonl", time.Now().In(h.Location).Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Content-Type", "application/jsonl")
+	if err := audit.Export(c.Writer, filter); err != nil {
+		slog.Error("Failed to export audit trail", "error", err)
+	}
+}
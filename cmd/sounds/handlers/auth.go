@@ -2,53 +2,129 @@ package handlers
 
 import (
 	"embed"
+	"errors"
+	"fmt"
+	"html/template"
 	"log/slog"
 	"net/http"
-	"text/template"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/middleware"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/oidc"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/throttle"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
 )
 
 type AuthHandler struct {
-	User       string
-	Password   string
 	TemplateFS embed.FS
+	// OIDC enables single sign-on next to the login form if it is set
+	OIDC *oidc.Provider
+	// IPLimiter and UserLimiter lock out clients and accounts after too
+	// many failed logins. Either may be nil to disable it.
+	IPLimiter   *throttle.Limiter
+	UserLimiter *throttle.Limiter
 }
 
 func (h *AuthHandler) LoginPage(c *gin.Context) {
+	h.renderLogin(c, "")
+}
+
+func (h *AuthHandler) renderLogin(c *gin.Context, errMsg string) {
 	tmpl := template.Must(template.ParseFS(h.TemplateFS, "templates/login.html"))
-	tmpl.Execute(c.Writer, nil)
+	data := gin.H{"sso": h.OIDC != nil, "csrfToken": middleware.CSRFToken(c)}
+	if errMsg != "" {
+		data["error"] = errMsg
+	}
+	tmpl.Execute(c.Writer, data)
+}
+
+// lockedOut returns how long a login from ip as name must wait.
+func (h *AuthHandler) lockedOut(ip, name string, now time.Time) time.Duration {
+	var wait time.Duration
+	if h.IPLimiter != nil {
+		wait = h.IPLimiter.Locked(ip, now)
+	}
+	if h.UserLimiter != nil {
+		wait = max(wait, h.UserLimiter.Locked(strings.ToLower(name), now))
+	}
+	return wait
+}
+
+// loginFailed counts a failed login towards the lockouts.
+func (h *AuthHandler) loginFailed(ip, name string, now time.Time) {
+	if h.IPLimiter != nil && h.IPLimiter.Fail(ip, now) {
+		slog.Warn("Locked out client after failed logins", "ip", ip, "duration", h.IPLimiter.Lockout)
+	}
+	if h.UserLimiter != nil && h.UserLimiter.Fail(strings.ToLower(name), now) {
+		slog.Warn("Locked out user after failed logins", "user", name, "ip", ip, "duration", h.UserLimiter.Lockout)
+	}
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
-	session := sessions.Default(c)
 	formUser := c.PostForm("username")
 	formPassword := c.PostForm("password")
+	ip := c.ClientIP()
+	now := time.Now()
 
-	if formUser == h.User && formPassword == h.Password {
-		session.Set("user", h.User)
-		if err := session.Save(); err != nil {
-			slog.Error("Failed to save session", "error", err)
-			c.String(http.StatusInternalServerError, "Failed to save session")
-			return
-		}
-		if c.GetHeader("HX-Request") == "true" {
-			c.Header("HX-Redirect", "/")
-			return
+	if wait := h.lockedOut(ip, formUser, now); wait > 0 {
+		minutes := int(wait.Round(time.Minute).Minutes())
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.Status(http.StatusTooManyRequests)
+		recordAs(c, formUser, audit.ActionLoginFailed, "", "locked out")
+		h.renderLogin(c, fmt.Sprintf("Too many failed logins, please try again in %d minutes", max(minutes, 1)))
+		return
+	}
+
+	user, err := users.Authenticate(formUser, formPassword)
+	if err != nil {
+		if !errors.Is(err, users.ErrInvalidCredentials) {
+			slog.Error("Failed to authenticate", "user", formUser, "error", err)
+		} else {
+			h.loginFailed(ip, formUser, now)
+			recordAs(c, formUser, audit.ActionLoginFailed, "", "invalid credentials")
 		}
-		// Otherwise, do a standard server-side redirect.
-		c.Redirect(http.StatusFound, "/")
+		h.renderLogin(c, "Invalid credentials")
+		return
+	}
+
+	if h.UserLimiter != nil {
+		h.UserLimiter.Reset(strings.ToLower(user.Name))
+	}
+	if err := middleware.StartSession(c, user); err != nil {
+		slog.Error("Failed to save session", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to save session")
+		return
+	}
+	recordAs(c, user.Name, audit.ActionLogin, "", "password")
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/")
 		return
-	} else {
-		tmpl := template.Must(template.ParseFS(h.TemplateFS, "templates/login.html"))
-		tmpl.Execute(c.Writer, gin.H{"error": "Invalid credentials"})
 	}
+	// Otherwise, do a standard server-side redirect.
+	c.Redirect(http.StatusFound, "/")
 }
 
+// Logout ends the session. It only answers POST requests with the CSRF
+// token, so other sites cannot log users out.
 func (h *AuthHandler) Logout(c *gin.Context) {
+	record(c, audit.ActionLogout, "", "")
 	session := sessions.Default(c)
 	session.Clear()
 	session.Save()
 	c.Redirect(http.StatusFound, "/login")
 }
+
+// currentUser returns the name of the logged in user, or "" if there is none.
+// API requests are logged in by their token.
+func currentUser(c *gin.Context) string {
+	if user := middleware.CurrentUser(c); user != nil {
+		return user.Name
+	}
+	user, _ := sessions.Default(c).Get("user").(string)
+	return user
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+)
+
+// sseHeartbeatInterval keeps idle connections from being closed by proxies.
+const sseHeartbeatInterval = 30 * time.Second
+
+// EventsHandler streams playlist changes to the browser as server-sent events.
+// Each event carries the re-rendered HTML fragment for the affected part of
+// the page, so the HTMX sse extension can swap it in directly.
+type EventsHandler struct {
+	TemplateFS embed.FS
+	Location   *time.Location
+}
+
+func (h *EventsHandler) Stream(c *gin.Context) {
+	changes, unsubscribe := playlist.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	tmpl := soundsTemplate(h.TemplateFS)
+	ctx := c.Request.Context()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-heartbeat.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case change, ok := <-changes:
+			if !ok {
+				return false
+			}
+			for _, change := range affectedBy(change) {
+				fragment, data := h.fragmentFor(change)
+				var buf bytes.Buffer
+				if err := tmpl.ExecuteTemplate(&buf, fragment, data); err != nil {
+					slog.Error("Failed to render event fragment", "change", change, "error", err)
+					continue
+				}
+				c.SSEvent(string(change), buf.String())
+			}
+			return true
+		}
+	})
+}
+
+// affectedBy returns the parts of the page that must be re-rendered after change.
+// Queue ETAs depend on the currently playing item, so they move along with it.
+func affectedBy(change playlist.Change) []playlist.Change {
+	if change == playlist.ChangeNowPlaying {
+		return []playlist.Change{playlist.ChangeNowPlaying, playlist.ChangeQueue}
+	}
+	return []playlist.Change{change}
+}
+
+// fragmentFor returns the template and data to render for a change.
+func (h *EventsHandler) fragmentFor(change playlist.Change) (string, gin.H) {
+	switch change {
+	case playlist.ChangeQueue:
+		queueItems, err := playlist.GetQueueItems()
+		if err != nil {
+			slog.Error("Failed to get queue items", "error", err)
+		}
+		return "queue-list", gin.H{"queueItems": GetQueueETAs(queueItems, h.Location)}
+	case playlist.ChangeHistory:
+		return "played-list", gin.H{"playedItems": GetPlayedItems()}
+	case playlist.ChangeSchedule:
+		return "schedule-list", gin.H{"scheduledItems": GetScheduledItems(h.Location)}
+	default:
+		return "now-playing", gin.H{"nowPlaying": GetNowPlaying(h.Location)}
+	}
+}
+
+// GetNowPlaying returns what the fish is currently playing with its start
+// time converted to loc, or nil if the fish is idle.
+func GetNowPlaying(loc *time.Location) *playlist.NowPlaying {
+	nowPlaying, err := playlist.GetNowPlaying()
+	if err != nil {
+		slog.Error("Failed to get now playing", "error", err)
+		return nil
+	}
+	if nowPlaying != nil {
+		nowPlaying.StartedAt = nowPlaying.StartedAt.In(loc)
+	}
+	return nowPlaying
+}
@@ -2,15 +2,21 @@ package handlers
 
 import (
 	"embed"
+	"errors"
+	"fmt"
+	"html/template"
 	"log/slog"
 	"net/http"
-	"os"
 	"path/filepath"
 	"sort"
-	"text/template"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/middleware"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/metric"
 )
@@ -34,67 +40,133 @@ func init() {
 type SoundFile struct {
 	Name string
 	Path string
+	// Clip holds the stored metadata, or the defaults for clips never edited
+	Clip library.Clip
+	// Error is an edit validation error shown on the card
+	Error string
+	// Editable shows the edit, rename and delete controls on the card
+	Editable bool
 }
 
 type FileHandler struct {
 	TemplateFS embed.FS
+	Location   *time.Location
 }
 
+// GetSoundFiles returns all clips in the library.
 func GetSoundFiles() []SoundFile {
-	dataDir := "./sound-data"
-	files, err := os.ReadDir(dataDir)
+	return SearchSoundFiles("", "")
+}
+
+// SearchSoundFiles returns the clips matching query in their name, title,
+// category or tags, restricted to category unless it is empty.
+func SearchSoundFiles(query, category string) []SoundFile {
+	clips, err := library.ListClips()
 	if err != nil {
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(dataDir, 0755); err != nil {
-				slog.Error("Failed to create data directory", "error", err)
-			}
-			return []SoundFile{}
-		}
-		slog.Error("Failed to read data directory", "error", err)
+		slog.Error("Failed to list clips", "error", err)
 		return []SoundFile{}
 	}
 
 	var soundFiles []SoundFile
-	for _, file := range files {
-		if !file.IsDir() && (filepath.Ext(file.Name()) == ".mp3" || filepath.Ext(file.Name()) == ".wav" || filepath.Ext(file.Name()) == ".json") {
-			// Skip JSON files from sound list, but read audio
-			if filepath.Ext(file.Name()) != ".json" {
-				soundFiles = append(soundFiles, SoundFile{
-					Name: file.Name(),
-					Path: filepath.Join("/sounds", file.Name()),
-				})
-			}
+	for _, clip := range clips {
+		if (category != "" && clip.Category != category) || !clip.Matches(query) {
+			continue
 		}
+		soundFiles = append(soundFiles, newSoundFile(clip))
 	}
 	return soundFiles
 }
 
-func (h *FileHandler) Index(c *gin.Context) {
-	soundFiles := GetSoundFiles()
-	playedItems, err := playlist.GetPlayedItems()
+// canEdit reports whether the logged in user may change the library.
+func canEdit(c *gin.Context) bool {
+	user := middleware.CurrentUser(c)
+	return user != nil && user.Can(users.RoleAdmin)
+}
+
+// editableBy marks soundFiles as editable if the logged in user may change
+// the library.
+func editableBy(c *gin.Context, soundFiles []SoundFile) []SoundFile {
+	editable := canEdit(c)
+	for i := range soundFiles {
+		soundFiles[i].Editable = editable
+	}
+	return soundFiles
+}
+
+func newSoundFile(clip library.Clip) SoundFile {
+	return SoundFile{
+		Name: clip.Name,
+		Path: filepath.Join("/sounds", clip.Name),
+		Clip: clip,
+	}
+}
+
+// Sound serves a clip for playback in the browser. Only clips are served,
+// the state files next to them in the data directory are private.
+func (h *FileHandler) Sound(c *gin.Context) {
+	name := c.Param("name")
+	exists, err := library.Exists(name)
 	if err != nil {
-		slog.Error("Failed to get played items", "error", err)
-		playedItems = []playlist.PlayedItem{}
+		slog.Error("Failed to look up sound", "name", name, "error", err)
+		c.String(http.StatusInternalServerError, "Failed to look up sound")
+		return
+	}
+	if !exists {
+		c.String(http.StatusNotFound, "Sound not found")
+		return
 	}
+	c.File(filepath.Join("./sound-data", name))
+}
 
-	queueItems, err := playlist.GetQueueItems()
+// GetPlayedItems returns the items played within the last hour, newest
+// first. The history keeps more for the quota.
+func GetPlayedItems() []playlist.PlayedItem {
+	history, err := playlist.GetPlayedItems()
 	if err != nil {
-		slog.Error("Failed to get queue items", "error", err)
-		queueItems = []playlist.QueueItem{}
+		slog.Error("Failed to get played items", "error", err)
+		return []playlist.PlayedItem{}
+	}
+	playedItems := []playlist.PlayedItem{}
+	cutoff := time.Now().Add(-1 * time.Hour)
+	for _, item := range history {
+		if item.Timestamp.After(cutoff) {
+			playedItems = append(playedItems, item)
+		}
 	}
 
 	// Sort by timestamp descending
 	sort.Slice(playedItems, func(i, j int) bool {
 		return playedItems[i].Timestamp.After(playedItems[j].Timestamp)
 	})
+	return playedItems
+}
 
-	tmpl := template.Must(template.New("sounds.html").Funcs(template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-	}).ParseFS(h.TemplateFS, "templates/sounds.html"))
+func (h *FileHandler) Index(c *gin.Context) {
+	// Viewers only get to watch the camera
+	if user := middleware.CurrentUser(c); user == nil || !user.Can(users.RoleMember) {
+		tmpl := template.Must(template.ParseFS(h.TemplateFS, "templates/camera.html"))
+		tmpl.Execute(c.Writer, gin.H{"csrfToken": middleware.CSRFToken(c)})
+		return
+	}
+
+	soundFiles := editableBy(c, GetSoundFiles())
+
+	queueItems, err := playlist.GetQueueItems()
+	if err != nil {
+		slog.Error("Failed to get queue items", "error", err)
+		queueItems = []playlist.QueueItem{}
+	}
+
+	tmpl := soundsTemplate(h.TemplateFS)
 	err = tmpl.Execute(c.Writer, gin.H{
-		"soundFiles":  soundFiles,
-		"playedItems": playedItems,
-		"queueItems":  queueItems,
+		"soundFiles":     soundFiles,
+		"playedItems":    GetPlayedItems(),
+		"queueItems":     GetQueueETAs(queueItems, h.Location),
+		"scheduledItems": GetScheduledItems(h.Location),
+		"nowPlaying":     GetNowPlaying(h.Location),
+		"trash":          GetTrash(h.Location),
+		"user":           middleware.CurrentUser(c),
+		"csrfToken":      middleware.CSRFToken(c),
 	})
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Failed to render page")
@@ -102,18 +174,262 @@ func (h *FileHandler) Index(c *gin.Context) {
 }
 
 func (h *FileHandler) Upload(c *gin.Context) {
+	// Leave some headroom for the multipart envelope around the file itself
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, library.MaxUploadSize+1<<20)
+
 	file, err := c.FormFile("soundFile")
 	if err != nil {
-		c.String(http.StatusBadRequest, "Bad request")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.uploadError(c, &library.IngestError{Reason: library.ReasonTooLarge, Message: "File is too large"})
+			return
+		}
+		h.uploadError(c, &library.IngestError{Reason: "missing_file", Message: "Please choose a file to upload"})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to read upload")
 		return
 	}
-	dst := filepath.Join("./sound-data", file.Filename)
-	if err := c.SaveUploadedFile(file, dst); err != nil {
+	defer src.Close()
+
+	clip, err := library.Ingest(src, file.Filename, file.Size, currentUser(c))
+	if err != nil {
+		var ingestErr *library.IngestError
+		if errors.As(err, &ingestErr) {
+			slog.Warn("Rejected upload", "filename", file.Filename, "reason", ingestErr.Reason, "error", err)
+			h.uploadError(c, ingestErr)
+			return
+		}
+		slog.Error("Failed to save upload", "filename", file.Filename, "error", err)
 		c.String(http.StatusInternalServerError, "Failed to save file")
 		return
 	}
+	slog.Info("Uploaded sound", "name", clip.Name, "original", file.Filename, "duration", clip.Duration)
+	record(c, audit.ActionUpload, clip.Name, file.Filename)
 	uploadsCounter.Add(c.Request.Context(), 1)
-	soundFiles := GetSoundFiles()
-	tmpl := template.Must(template.ParseFS(h.TemplateFS, "templates/sounds.html"))
+
+	soundFiles := editableBy(c, GetSoundFiles())
+	tmpl := soundsTemplate(h.TemplateFS)
 	tmpl.ExecuteTemplate(c.Writer, "sound-list", gin.H{"soundFiles": soundFiles})
+	// Update the status line next to the form out of band
+	tmpl.ExecuteTemplate(c.Writer, "upload-status", gin.H{"uploadedClip": clip, "oob": true})
+}
+
+// uploadError renders a rejected upload into the status line of the upload form.
+func (h *FileHandler) uploadError(c *gin.Context, ingestErr *library.IngestError) {
+	c.Header("HX-Retarget", "#upload-status")
+	c.Header("HX-Reswap", "outerHTML")
+	c.Status(http.StatusUnprocessableEntity)
+	tmpl := soundsTemplate(h.TemplateFS)
+	tmpl.ExecuteTemplate(c.Writer, "upload-status", gin.H{"uploadError": ingestErr})
+}
+
+// GetTrash returns the deleted sounds that can still be restored, with
+// their deletion times in loc.
+func GetTrash(loc *time.Location) []library.TrashedClip {
+	trash, err := library.GetTrash()
+	if err != nil {
+		slog.Error("Failed to get trash", "error", err)
+		return []library.TrashedClip{}
+	}
+	for i := range trash {
+		trash[i].DeletedAt = trash[i].DeletedAt.In(loc)
+	}
+	return trash
+}
+
+// renderLibrary swaps in the sound list and the trash out of band, so that
+// management actions can be triggered from anywhere in the Library tab.
+func (h *FileHandler) renderLibrary(c *gin.Context) {
+	tmpl := soundsTemplate(h.TemplateFS)
+	tmpl.ExecuteTemplate(c.Writer, "sound-list-oob", gin.H{"soundFiles": editableBy(c, GetSoundFiles())})
+	tmpl.ExecuteTemplate(c.Writer, "trash-list", gin.H{"trash": GetTrash(h.Location), "oob": true})
+}
+
+// deleteSound moves a sound to the trash for the logged in user and drops
+// it from the queue. Schedules of it are kept for when it is restored.
+func deleteSound(c *gin.Context, name string) error {
+	by := currentUser(c)
+	if _, err := library.Delete(name, by); err != nil {
+		return err
+	}
+	if err := playlist.RemoveSong(name); err != nil {
+		slog.Error("Failed to remove references to deleted sound", "name", name, "error", err)
+	}
+	slog.Info("Deleted sound", "name", name, "by", by)
+	record(c, audit.ActionDelete, name, "")
+	return nil
+}
+
+func (h *FileHandler) Delete(c *gin.Context) {
+	if err := deleteSound(c, c.Param("name")); err != nil {
+		if errors.Is(err, library.ErrClipNotFound) {
+			c.String(http.StatusNotFound, "Sound not found")
+			return
+		}
+		slog.Error("Failed to delete sound", "name", c.Param("name"), "error", err)
+		c.String(http.StatusInternalServerError, "Failed to delete sound")
+		return
+	}
+	h.renderLibrary(c)
+}
+
+// BulkDelete moves all sounds selected in the library to the trash.
+func (h *FileHandler) BulkDelete(c *gin.Context) {
+	for _, name := range c.PostFormArray("names") {
+		if err := deleteSound(c, name); err != nil && !errors.Is(err, library.ErrClipNotFound) {
+			slog.Error("Failed to delete sound", "name", name, "error", err)
+			c.String(http.StatusInternalServerError, "Failed to delete sounds")
+			return
+		}
+	}
+	h.renderLibrary(c)
+}
+
+// Rename renames a sound and updates the queue, history and schedule
+// entries that refer to it. The card is rendered with the new name.
+func (h *FileHandler) Rename(c *gin.Context) {
+	name := c.Param("name")
+	clip, err := library.Rename(name, c.PostForm("name"))
+	if err != nil {
+		switch {
+		case errors.Is(err, library.ErrClipNotFound):
+			c.String(http.StatusNotFound, "Sound not found")
+		case errors.Is(err, library.ErrClipExists):
+			current, _ := library.GetClip(name)
+			soundFile := newSoundFile(library.DefaultClip(name))
+			if current != nil {
+				soundFile = newSoundFile(*current)
+			}
+			soundFile.Error = err.Error()
+			soundFile.Editable = canEdit(c)
+			soundsTemplate(h.TemplateFS).ExecuteTemplate(c.Writer, "sound-card", soundFile)
+		default:
+			slog.Error("Failed to rename sound", "name", name, "error", err)
+			c.String(http.StatusInternalServerError, "Failed to rename sound")
+		}
+		return
+	}
+	if clip.Name != name {
+		if err := playlist.RenameSong(name, clip.Name); err != nil {
+			slog.Error("Failed to rename references to sound", "name", name, "new_name", clip.Name, "error", err)
+		}
+		slog.Info("Renamed sound", "name", name, "new_name", clip.Name)
+		record(c, audit.ActionRename, name, clip.Name)
+	}
+	soundFile := newSoundFile(*clip)
+	soundFile.Editable = canEdit(c)
+	soundsTemplate(h.TemplateFS).ExecuteTemplate(c.Writer, "sound-card", soundFile)
+}
+
+// Restore moves a sound out of the trash.
+func (h *FileHandler) Restore(c *gin.Context) {
+	clip, err := library.Restore(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, library.ErrTrashNotFound) {
+			c.String(http.StatusNotFound, "Sound not found in trash")
+			return
+		}
+		slog.Error("Failed to restore sound", "id", c.Param("id"), "error", err)
+		c.String(http.StatusInternalServerError, "Failed to restore sound")
+		return
+	}
+	slog.Info("Restored sound", "name", clip.Name)
+	record(c, audit.ActionRestore, clip.Name, "")
+	h.renderLibrary(c)
+}
+
+// Purge removes a sound from the trash for good.
+func (h *FileHandler) Purge(c *gin.Context) {
+	name := c.Param("id")
+	for _, trashed := range GetTrash(h.Location) {
+		if trashed.ID == c.Param("id") {
+			name = trashed.Clip.Name
+		}
+	}
+	if err := library.Purge(c.Param("id")); err != nil {
+		if errors.Is(err, library.ErrTrashNotFound) {
+			c.String(http.StatusNotFound, "Sound not found in trash")
+			return
+		}
+		slog.Error("Failed to purge sound", "id", c.Param("id"), "error", err)
+		c.String(http.StatusInternalServerError, "Failed to purge sound")
+		return
+	}
+	record(c, audit.ActionPurge, name, "")
+	h.renderLibrary(c)
+}
+
+// Export downloads the selected sounds, or the whole library if none are
+// selected, as a ZIP archive including their metadata.
+func (h *FileHandler) Export(c *gin.Context) {
+	names := c.QueryArray("names")
+	// Check the names before streaming the archive, so a missing sound
+	// results in a proper error instead of a truncated download.
+	for _, name := range names {
+		exists, err := library.Exists(name)
+		if err != nil {
+			slog.Error("Failed to look up sound", "name", name, "error", err)
+			c.String(http.StatusInternalServerError, "Failed to export sounds")
+			return
+		}
+		if !exists {
+			c.String(http.StatusNotFound, fmt.Sprintf("%v: %s", library.ErrClipNotFound, name))
+			return
+		}
+	}
+	filename := fmt.Sprintf("sebaschtian-sounds-%s.zip", time.Now().In(h.Location).Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+	if err := library.Export(c.Writer, names); err != nil {
+		// The download is under way, so it can only be cut short
+		slog.Error("Failed to export library", "error", err)
+	}
+}
+
+// Import adds the sounds of a ZIP archive, keeping their metadata.
+func (h *FileHandler) Import(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, library.MaxImportSize+1<<20)
+
+	file, err := c.FormFile("archive")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.uploadError(c, &library.IngestError{Reason: library.ReasonTooLarge, Message: "Archive is too large"})
+			return
+		}
+		h.uploadError(c, &library.IngestError{Reason: "missing_file", Message: "Please choose a ZIP archive to import"})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to read upload")
+		return
+	}
+	defer src.Close()
+
+	result, err := library.Import(src, file.Size, currentUser(c))
+	if err != nil {
+		var ingestErr *library.IngestError
+		if errors.As(err, &ingestErr) {
+			slog.Warn("Rejected import", "filename", file.Filename, "reason", ingestErr.Reason, "error", err)
+			h.uploadError(c, ingestErr)
+			return
+		}
+		slog.Error("Failed to import archive", "filename", file.Filename, "error", err)
+		c.String(http.StatusInternalServerError, "Failed to import archive")
+		return
+	}
+	slog.Info("Imported sounds", "filename", file.Filename, "imported", len(result.Imported), "rejected", len(result.Rejected))
+	for _, clip := range result.Imported {
+		record(c, audit.ActionImport, clip.Name, file.Filename)
+	}
+	uploadsCounter.Add(c.Request.Context(), int64(len(result.Imported)))
+
+	h.renderLibrary(c)
+	tmpl := soundsTemplate(h.TemplateFS)
+	tmpl.ExecuteTemplate(c.Writer, "upload-status", gin.H{"importResult": result, "oob": true})
 }
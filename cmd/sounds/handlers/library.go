@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+)
+
+// LibraryHandler searches and edits the metadata of clips in the library.
+type LibraryHandler struct {
+	TemplateFS embed.FS
+}
+
+// Search renders the sound list filtered by the q and category parameters.
+func (h *LibraryHandler) Search(c *gin.Context) {
+	soundFiles := editableBy(c, SearchSoundFiles(c.Query("q"), c.Query("category")))
+	tmpl := soundsTemplate(h.TemplateFS)
+	tmpl.ExecuteTemplate(c.Writer, "sound-list", gin.H{"soundFiles": soundFiles})
+}
+
+// Update saves the title, tags, category and enabled flag of a clip and
+// renders its card again.
+func (h *LibraryHandler) Update(c *gin.Context) {
+	name := c.Param("name")
+	edit := library.ClipEdit{
+		Title:    c.PostForm("title"),
+		Tags:     library.ParseTags(c.PostForm("tags")),
+		Category: c.PostForm("category"),
+		Enabled:  c.PostForm("enabled") != "",
+	}
+
+	clip, err := library.UpdateClip(name, edit)
+	if err != nil {
+		switch {
+		case errors.Is(err, library.ErrClipNotFound):
+			c.String(http.StatusNotFound, "Sound not found")
+		case errors.Is(err, library.ErrInvalidMetadata):
+			// Show the error on the card, keeping the stored values
+			current, _ := library.GetClip(name)
+			soundFile := newSoundFile(library.DefaultClip(name))
+			if current != nil {
+				soundFile = newSoundFile(*current)
+			}
+			soundFile.Error = err.Error()
+			h.renderCard(c, soundFile)
+		default:
+			slog.Error("Failed to update clip", "name", name, "error", err)
+			c.String(http.StatusInternalServerError, "Failed to update sound")
+		}
+		return
+	}
+
+	slog.Info("Updated clip metadata", "name", name, "category", clip.Category, "tags", clip.Tags, "enabled", clip.Enabled)
+	record(c, audit.ActionEditClip, name, fmt.Sprintf("category %s, tags %s, enabled %t", clip.Category, strings.Join(clip.Tags, " "), clip.Enabled))
+	h.renderCard(c, newSoundFile(*clip))
+}
+
+// editRequest holds the trim and fade times of a clip in seconds and its
+// gain in dB. It is accepted as form data from the Library tab or as JSON.
+type editRequest struct {
+	Start   float64 `form:"start" json:"start"`
+	End     float64 `form:"end" json:"end"`
+	FadeIn  float64 `form:"fade_in" json:"fade_in"`
+	FadeOut float64 `form:"fade_out" json:"fade_out"`
+	Gain    float64 `form:"gain" json:"gain_db"`
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Get returns the metadata of a clip as JSON, including its edit settings.
+func (h *LibraryHandler) Get(c *gin.Context) {
+	clip, err := library.GetClip(c.Param("name"))
+	if err != nil {
+		slog.Error("Failed to read clip", "name", c.Param("name"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read sound"})
+		return
+	}
+	if clip == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sound not found"})
+		return
+	}
+	c.JSON(http.StatusOK, clip)
+}
+
+// Edit stores non-destructive trim, fade and gain settings for a clip.
+// JSON requests get the updated clip as JSON, the Library tab gets the
+// re-rendered card.
+func (h *LibraryHandler) Edit(c *gin.Context) {
+	name := c.Param("name")
+	wantsJSON := c.ContentType() == "application/json"
+
+	var req editRequest
+	if err := c.ShouldBind(&req); err != nil {
+		if wantsJSON {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.String(http.StatusBadRequest, "Invalid edit")
+		return
+	}
+	edit := audio.Edit{
+		Start:   seconds(req.Start),
+		End:     seconds(req.End),
+		FadeIn:  seconds(req.FadeIn),
+		FadeOut: seconds(req.FadeOut),
+		Gain:    req.Gain,
+	}
+
+	clip, err := library.SetEdit(name, edit)
+	if err != nil {
+		switch {
+		case errors.Is(err, library.ErrClipNotFound):
+			if wantsJSON {
+				c.JSON(http.StatusNotFound, gin.H{"error": "sound not found"})
+				return
+			}
+			c.String(http.StatusNotFound, "Sound not found")
+		case errors.Is(err, library.ErrInvalidMetadata):
+			if wantsJSON {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+				return
+			}
+			current, _ := library.GetClip(name)
+			soundFile := newSoundFile(library.DefaultClip(name))
+			if current != nil {
+				soundFile = newSoundFile(*current)
+			}
+			soundFile.Error = err.Error()
+			h.renderCard(c, soundFile)
+		default:
+			slog.Error("Failed to edit clip", "name", name, "error", err)
+			c.String(http.StatusInternalServerError, "Failed to edit sound")
+		}
+		return
+	}
+
+	slog.Info("Edited clip", "name", name, "start", edit.Start, "end", edit.End, "fade_in", edit.FadeIn, "fade_out", edit.FadeOut, "gain", edit.Gain)
+	record(c, audit.ActionEditClip, name, fmt.Sprintf("trim %v-%v, fade %v/%v, gain %.1f dB", edit.Start, edit.End, edit.FadeIn, edit.FadeOut, edit.Gain))
+	if wantsJSON {
+		c.JSON(http.StatusOK, clip)
+		return
+	}
+	h.renderCard(c, newSoundFile(*clip))
+}
+
+// Waveform renders the waveform thumbnail of a clip as SVG.
+func (h *LibraryHandler) Waveform(c *gin.Context) {
+	clip, err := library.GetClip(c.Param("name"))
+	if err != nil {
+		slog.Error("Failed to read clip", "name", c.Param("name"), "error", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if clip == nil || len(clip.Waveform) == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	// The thumbnail changes with every edit
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "image/svg+xml", []byte(waveformSVG(*clip)))
+}
+
+func (h *LibraryHandler) renderCard(c *gin.Context, soundFile SoundFile) {
+	soundFile.Editable = canEdit(c)
+	tmpl := soundsTemplate(h.TemplateFS)
+	tmpl.ExecuteTemplate(c.Writer, "sound-card", soundFile)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/middleware"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/oidc"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
+)
+
+// userSource marks users that signed in via the identity provider.
+const userSource = "oidc"
+
+// Session keys holding the secrets of a sign-in in progress
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+)
+
+// OIDCLogin redirects to the identity provider for single sign-on.
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	flow := oidc.NewFlow()
+	authURL, err := h.OIDC.AuthCodeURL(c.Request.Context(), flow)
+	if err != nil {
+		slog.Error("Failed to start single sign-on", "error", err)
+		h.renderLogin(c, "Single sign-on is unavailable right now")
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set(oidcStateKey, flow.State)
+	session.Set(oidcNonceKey, flow.Nonce)
+	session.Set(oidcVerifierKey, flow.CodeVerifier)
+	if err := session.Save(); err != nil {
+		slog.Error("Failed to save session", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to save session")
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback completes single sign-on. The user is created or updated
+// with the role its groups map to and logged in.
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	session := sessions.Default(c)
+	flow := oidc.Flow{}
+	flow.State, _ = session.Get(oidcStateKey).(string)
+	flow.Nonce, _ = session.Get(oidcNonceKey).(string)
+	flow.CodeVerifier, _ = session.Get(oidcVerifierKey).(string)
+	// The secrets are single use, whatever the outcome
+	session.Delete(oidcStateKey)
+	session.Delete(oidcNonceKey)
+	session.Delete(oidcVerifierKey)
+	session.Save()
+
+	if errParam := c.Query("error"); errParam != "" {
+		slog.Warn("Single sign-on failed at the identity provider", "error", errParam, "description", c.Query("error_description"))
+		h.renderLogin(c, "Single sign-on failed")
+		return
+	}
+	if flow.State == "" || c.Query("state") != flow.State {
+		h.renderLogin(c, "Single sign-on expired, please try again")
+		return
+	}
+
+	claims, err := h.OIDC.Exchange(c.Request.Context(), c.Query("code"), flow)
+	if err != nil {
+		if errors.Is(err, oidc.ErrNotAllowed) {
+			slog.Warn("Rejected single sign-on", "error", err)
+			h.renderLogin(c, "Your account may not use the fish")
+			return
+		}
+		slog.Error("Failed to complete single sign-on", "error", err)
+		h.renderLogin(c, "Single sign-on failed")
+		return
+	}
+	role, ok := h.OIDC.RoleFor(claims.Groups)
+	if !ok {
+		slog.Warn("Rejected single sign-on without a role", "user", claims.Username(), "groups", claims.Groups)
+		h.renderLogin(c, "Your account may not use the fish")
+		return
+	}
+	identity := users.Identity{Source: userSource, Subject: claims.ID(), VerifiedName: claims.VerifiedUsername()}
+	user, err := users.SyncExternal(claims.Username(), identity, role)
+	if err != nil {
+		if errors.Is(err, users.ErrUserExists) {
+			slog.Warn("Rejected single sign-on", "user", claims.Username(), "error", err)
+			h.renderLogin(c, "There is another account with your name. If it is yours, sign in with its password")
+			return
+		}
+		if errors.Is(err, users.ErrInvalidUser) {
+			slog.Warn("Rejected single sign-on", "user", claims.Username(), "error", err)
+			h.renderLogin(c, "Your account name cannot be used here, please ask an admin")
+			return
+		}
+		slog.Error("Failed to save single sign-on user", "user", claims.Username(), "error", err)
+		c.String(http.StatusInternalServerError, "Failed to save user")
+		return
+	}
+
+	if err := middleware.StartSession(c, user); err != nil {
+		slog.Error("Failed to save session", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to save session")
+		return
+	}
+	slog.Info("Signed in via single sign-on", "user", user.Name, "role", user.Role)
+	recordAs(c, user.Name, audit.ActionLogin, "", "single sign-on as "+string(user.Role))
+	c.Redirect(http.StatusFound, "/")
+}
@@ -2,12 +2,20 @@ package handlers
 
 import (
 	"embed"
+	"errors"
 	"log/slog"
+	"math"
 	"net/http"
-	"text/template"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/quota"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/metric"
 )
@@ -30,6 +38,54 @@ func init() {
 
 type QueueHandler struct {
 	TemplateFS embed.FS
+	Location   *time.Location
+	// Quota limits how much each user may queue.
+	Quota *quota.Quota
+}
+
+// takeQuota counts item against the limits of the user queueing it. When a
+// limit is exceeded it returns the *quota.ExceededError and sets the
+// Retry-After header if the user just has to wait.
+func takeQuota(c *gin.Context, q *quota.Quota, item playlist.QueueItem) error {
+	queueItems, err := playlist.GetQueueItems()
+	if err != nil {
+		return err
+	}
+	err = q.Take(item.RequestedBy, item, queueItems, time.Now())
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		slog.Info("Rejected queue request", "user", item.RequestedBy, "name", item.Name, "reason", exceeded.Reason)
+		if exceeded.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds()))))
+		}
+	}
+	return err
+}
+
+// GetQueueETAs returns the queued items with their expected playback start in loc.
+func GetQueueETAs(queueItems []playlist.QueueItem, loc *time.Location) []playlist.QueueETA {
+	etas := playlist.EstimateQueue(time.Now(), GetNowPlaying(loc), queueItems, queueItemDuration)
+	for i := range etas {
+		etas[i].StartsAt = etas[i].StartsAt.In(loc)
+	}
+	return etas
+}
+
+// queueItemDuration returns the expected playback length of a queued item.
+func queueItemDuration(item playlist.QueueItem) time.Duration {
+	if item.Type == "text" {
+		return playlist.EstimateSpeechDuration(item.Name)
+	}
+	// Trimmed clips are shorter than their files
+	if clip, err := library.GetClip(item.Name); err == nil && clip != nil && clip.Duration > 0 {
+		return clip.PlaybackDuration()
+	}
+	d, err := audio.Duration(filepath.Join("./sound-data", item.Name))
+	if err != nil {
+		slog.Warn("Failed to get duration of queued item", "name", item.Name, "error", err)
+		return 0
+	}
+	return d
 }
 
 func (h *QueueHandler) List(c *gin.Context) {
@@ -40,10 +96,8 @@ func (h *QueueHandler) List(c *gin.Context) {
 		return
 	}
 
-	tmpl := template.Must(template.New("sounds.html").Funcs(template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-	}).ParseFS(h.TemplateFS, "templates/sounds.html"))
-	tmpl.ExecuteTemplate(c.Writer, "queue-list", gin.H{"queueItems": queueItems})
+	tmpl := soundsTemplate(h.TemplateFS)
+	tmpl.ExecuteTemplate(c.Writer, "queue-list", gin.H{"queueItems": GetQueueETAs(queueItems, h.Location)})
 }
 
 func (h *QueueHandler) Play(c *gin.Context) {
@@ -52,19 +106,44 @@ func (h *QueueHandler) Play(c *gin.Context) {
 		c.String(http.StatusBadRequest, "Filename required")
 		return
 	}
+	exists, err := library.Exists(filename)
+	if err != nil {
+		slog.Error("Failed to look up sound", "filename", filename, "error", err)
+		c.String(http.StatusInternalServerError, "Failed to queue playback")
+		return
+	}
+	if !exists {
+		c.String(http.StatusNotFound, "Sound not found")
+		return
+	}
 
 	// Add to queue
 	item := playlist.QueueItem{
-		Name: filename,
-		Type: "song",
+		Name:        filename,
+		Type:        "song",
+		RequestedBy: currentUser(c),
+	}
+	if err := takeQuota(c, h.Quota, item); err != nil {
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			c.Header("HX-Retarget", "#queue-status")
+			c.Header("HX-Reswap", "outerHTML")
+			c.Status(http.StatusTooManyRequests)
+			soundsTemplate(h.TemplateFS).ExecuteTemplate(c.Writer, "queue-status", gin.H{"queueError": exceeded.Message})
+			return
+		}
+		slog.Error("Failed to get queue items", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to queue playback")
+		return
 	}
-	if err := playlist.AddToQueue(item); err != nil {
+	if _, err := playlist.AddToQueue(item); err != nil {
 		slog.Error("Failed to add to queue", "error", err)
 		c.String(http.StatusInternalServerError, "Failed to queue playback")
 		return
 	}
 
 	slog.Info("Queued playback", "filename", filename)
+	record(c, audit.ActionQueue, filename, "")
 
 	// Return updated queue list
 	queueItems, err := playlist.GetQueueItems()
@@ -75,8 +154,8 @@ func (h *QueueHandler) Play(c *gin.Context) {
 	}
 	queueDepthGauge.Record(c.Request.Context(), int64(len(queueItems)))
 
-	tmpl := template.Must(template.New("sounds.html").Funcs(template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-	}).ParseFS(h.TemplateFS, "templates/sounds.html"))
-	tmpl.ExecuteTemplate(c.Writer, "queue-list", gin.H{"queueItems": queueItems})
+	tmpl := soundsTemplate(h.TemplateFS)
+	tmpl.ExecuteTemplate(c.Writer, "queue-list", gin.H{"queueItems": GetQueueETAs(queueItems, h.Location)})
+	// Clear an earlier rejection out of band
+	tmpl.ExecuteTemplate(c.Writer, "queue-status", gin.H{"oob": true})
 }
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/api"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/dsp"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/quota"
+)
+
+type ScheduleHandler struct {
+	TemplateFS embed.FS
+	// Location is used to interpret one-off times and cron expressions
+	// entered in the UI. It should match the location the fish runs in.
+	Location *time.Location
+	// Quota limits how many items each user may schedule.
+	Quota *quota.Quota
+}
+
+// GetScheduledItems returns all scheduled items with their times converted to loc for display.
+func GetScheduledItems(loc *time.Location) []playlist.ScheduledItem {
+	items, err := playlist.GetScheduledItems()
+	if err != nil {
+		slog.Error("Failed to get scheduled items", "error", err)
+		return []playlist.ScheduledItem{}
+	}
+	for i := range items {
+		items[i].NextRun = items[i].NextRun.In(loc)
+		items[i].At = items[i].At.In(loc)
+		items[i].LastRun = items[i].LastRun.In(loc)
+	}
+	return items
+}
+
+func (h *ScheduleHandler) List(c *gin.Context) {
+	h.renderList(c, "")
+}
+
+func (h *ScheduleHandler) Create(c *gin.Context) {
+	now := time.Now().In(h.Location)
+	item := playlist.ScheduledItem{
+		Name: c.PostForm("name"),
+		Type: c.PostForm("type"),
+		Cron: c.PostForm("cron"),
+		// Promoted items are attributed to whoever scheduled them
+		CreatedBy: currentUser(c),
+		Voice:     c.PostForm("voice"),
+		Effects:   strings.TrimSpace(c.PostForm("effects")),
+	}
+	if item.Voice == piper.DefaultProfile {
+		item.Voice = ""
+	}
+	switch item.Type {
+	case "song":
+		exists, err := library.Exists(item.Name)
+		if err != nil {
+			slog.Error("Failed to look up sound", "name", item.Name, "error", err)
+			c.String(http.StatusInternalServerError, "Failed to schedule item")
+			return
+		}
+		if !exists {
+			h.renderList(c, "Unknown sound")
+			return
+		}
+	case "text":
+		if utf8.RuneCountInString(strings.TrimSpace(item.Name)) > api.MaxSayLength {
+			h.renderList(c, fmt.Sprintf("Text is too long, at most %d characters", api.MaxSayLength))
+			return
+		}
+	}
+	if !knownVoice(item.Voice) {
+		h.renderList(c, "Unknown voice")
+		return
+	}
+	if _, err := dsp.Parse(item.Effects); err != nil {
+		h.renderList(c, "Invalid effects: "+err.Error())
+		return
+	}
+	if at := c.PostForm("at"); at != "" {
+		t, err := time.ParseInLocation("2006-01-02T15:04", at, h.Location)
+		if err != nil {
+			h.renderList(c, "Invalid date and time")
+			return
+		}
+		item.At = t
+	}
+
+	scheduled, err := playlist.GetScheduledItems()
+	if err != nil {
+		slog.Error("Failed to get scheduled items", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to schedule item")
+		return
+	}
+	if err := h.Quota.Schedule(item.CreatedBy, item, scheduled, now); err != nil {
+		slog.Info("Rejected schedule request", "user", item.CreatedBy, "name", item.Name, "error", err)
+		h.renderList(c, err.Error())
+		return
+	}
+
+	created, err := playlist.AddScheduledItem(item, now)
+	if err != nil {
+		if errors.Is(err, playlist.ErrInvalidSchedule) {
+			h.renderList(c, err.Error())
+			return
+		}
+		slog.Error("Failed to add scheduled item", "error", err)
+		c.String(http.StatusInternalServerError, "Failed to schedule item")
+		return
+	}
+
+	slog.Info("Scheduled item", "id", created.ID, "name", created.Name, "type", created.Type, "next_run", created.NextRun)
+	when := created.Cron
+	if when == "" {
+		when = created.At.In(h.Location).Format("2006-01-02 15:04")
+	}
+	record(c, audit.ActionSchedule, created.Name, created.Type+" at "+when)
+	h.renderList(c, "")
+}
+
+// Delete removes a scheduled item. Members may only remove their own,
+// admins any.
+func (h *ScheduleHandler) Delete(c *gin.Context) {
+	id := c.Param("id")
+	var item *playlist.ScheduledItem
+	for _, scheduled := range GetScheduledItems(h.Location) {
+		if scheduled.ID == id {
+			item = &scheduled
+		}
+	}
+	if item == nil {
+		c.String(http.StatusNotFound, "Scheduled item not found")
+		return
+	}
+	if item.CreatedBy != currentUser(c) && !canEdit(c) {
+		h.renderList(c, "You can only remove items you scheduled")
+		return
+	}
+	name := item.Name
+	if err := playlist.RemoveScheduledItem(id); err != nil {
+		if errors.Is(err, playlist.ErrScheduleNotFound) {
+			c.String(http.StatusNotFound, "Scheduled item not found")
+			return
+		}
+		slog.Error("Failed to remove scheduled item", "id", id, "error", err)
+		c.String(http.StatusInternalServerError, "Failed to remove scheduled item")
+		return
+	}
+
+	slog.Info("Removed scheduled item", "id", id)
+	record(c, audit.ActionUnschedule, name, "")
+	h.renderList(c, "")
+}
+
+func (h *ScheduleHandler) renderList(c *gin.Context, scheduleError string) {
+	tmpl := soundsTemplate(h.TemplateFS)
+	tmpl.ExecuteTemplate(c.Writer, "schedule-list", gin.H{
+		"scheduledItems": GetScheduledItems(h.Location),
+		"scheduleError":  scheduleError,
+	})
+}
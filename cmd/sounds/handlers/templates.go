@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/dsp"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
+)
+
+// templateFuncs are the helpers available to every template.
+var templateFuncs = template.FuncMap{
+	"add":     func(a, b int) int { return a + b },
+	"now":     time.Now,
+	"clock":   clock,
+	"seconds": func(d time.Duration) float64 { return d.Seconds() },
+	"join":    strings.Join,
+	"categories": func() []string {
+		return library.Categories
+	},
+	"roles": func() []users.Role {
+		return users.Roles
+	},
+	"voices": func() []string {
+		return voiceProfiles().Names()
+	},
+	"effects": dsp.Names,
+}
+
+// clock formats d as m:ss for display next to playback progress.
+func clock(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// soundsTemplate parses sounds.html with all template helpers registered,
+// so that single fragments can be rendered via ExecuteTemplate.
+func soundsTemplate(fsys embed.FS) *template.Template {
+	return template.Must(template.New("sounds.html").Funcs(templateFuncs).ParseFS(fsys, "templates/sounds.html"))
+}
+
+// usersTemplate parses users.html, the user management page for admins.
+func usersTemplate(fsys embed.FS) *template.Template {
+	return template.Must(template.New("users.html").Funcs(templateFuncs).ParseFS(fsys, "templates/users.html"))
+}
+
+// tokensTemplate parses tokens.html, where users manage their API tokens.
+func tokensTemplate(fsys embed.FS) *template.Template {
+	return template.Must(template.New("tokens.html").Funcs(templateFuncs).ParseFS(fsys, "templates/tokens.html"))
+}
+
+// volumeTemplate parses volume.html, the volume settings page.
+func volumeTemplate(fsys embed.FS) *template.Template {
+	return template.Must(template.New("volume.html").Funcs(templateFuncs).ParseFS(fsys, "templates/volume.html"))
+}
+
+// auditTemplate parses audit.html, the audit trail page for admins.
+func auditTemplate(fsys embed.FS) *template.Template {
+	return template.Must(template.New("audit.html").Funcs(templateFuncs).ParseFS(fsys, "templates/audit.html"))
+}
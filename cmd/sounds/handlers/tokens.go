@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/middleware"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/api"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
+)
+
+// TokensHandler lets users manage their own API tokens.
+type TokensHandler struct {
+	TemplateFS embed.FS
+}
+
+// Page renders the API token page.
+func (h *TokensHandler) Page(c *gin.Context) {
+	data := h.listData(c)
+	data["basePath"] = api.BasePath
+	data["csrfToken"] = middleware.CSRFToken(c)
+	if err := tokensTemplate(h.TemplateFS).Execute(c.Writer, data); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to render page")
+	}
+}
+
+// Create adds a token with the posted name and shows its secret once.
+func (h *TokensHandler) Create(c *gin.Context) {
+	secret, token, err := users.CreateToken(currentUser(c), c.PostForm("name"))
+	if err != nil {
+		h.renderError(c, "Failed to create token", err)
+		return
+	}
+	slog.Info("Created API token", "user", currentUser(c), "token", token.Name)
+	record(c, audit.ActionCreateToken, token.Name, "")
+	data := h.listData(c)
+	data["secret"] = secret
+	tokensTemplate(h.TemplateFS).ExecuteTemplate(c.Writer, "token-list", data)
+}
+
+// Revoke removes a token of the logged in user.
+func (h *TokensHandler) Revoke(c *gin.Context) {
+	name := c.Param("id")
+	if user, err := users.Get(currentUser(c)); err == nil {
+		for _, token := range user.Tokens {
+			if token.ID == c.Param("id") {
+				name = token.Name
+			}
+		}
+	}
+	if err := users.RevokeToken(currentUser(c), c.Param("id")); err != nil {
+		h.renderError(c, "Failed to revoke token", err)
+		return
+	}
+	slog.Info("Revoked API token", "user", currentUser(c), "id", c.Param("id"))
+	record(c, audit.ActionRevokeToken, name, "")
+	tokensTemplate(h.TemplateFS).ExecuteTemplate(c.Writer, "token-list", h.listData(c))
+}
+
+// renderError shows validation errors above the list and logs the rest.
+func (h *TokensHandler) renderError(c *gin.Context, msg string, err error) {
+	data := h.listData(c)
+	if errors.Is(err, users.ErrInvalidUser) || errors.Is(err, users.ErrTokenNotFound) {
+		data["error"] = err.Error()
+	} else {
+		slog.Error(msg, "user", currentUser(c), "error", err)
+		data["error"] = msg
+	}
+	tokensTemplate(h.TemplateFS).ExecuteTemplate(c.Writer, "token-list", data)
+}
+
+func (h *TokensHandler) listData(c *gin.Context) gin.H {
+	// Reload the user, the one stored by the middleware predates changes
+	tokens := []users.Token{}
+	if user, err := users.Get(currentUser(c)); err == nil {
+		tokens = user.Tokens
+	}
+	return gin.H{"tokens": tokens, "user": middleware.CurrentUser(c)}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/middleware"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
+)
+
+// UsersHandler lets admins manage the accounts of the web UI.
+type UsersHandler struct {
+	TemplateFS embed.FS
+}
+
+// Page renders the user management page.
+func (h *UsersHandler) Page(c *gin.Context) {
+	data := h.listData(c, "")
+	data["csrfToken"] = middleware.CSRFToken(c)
+	if err := usersTemplate(h.TemplateFS).Execute(c.Writer, data); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to render page")
+	}
+}
+
+// Create adds a user with the posted name, password and role.
+func (h *UsersHandler) Create(c *gin.Context) {
+	name := c.PostForm("name")
+	role := users.Role(c.PostForm("role"))
+	if _, err := users.Create(name, c.PostForm("password"), role); err != nil {
+		h.renderError(c, "Failed to create user", err)
+		return
+	}
+	slog.Info("Created user", "user", name, "role", role, "by", currentUser(c))
+	record(c, audit.ActionCreateUser, name, string(role))
+	h.renderList(c, "")
+}
+
+// SetRole changes the role of a user. Admins cannot change their own role,
+// so they cannot lock themselves out.
+func (h *UsersHandler) SetRole(c *gin.Context) {
+	name := c.Param("name")
+	if name == currentUser(c) {
+		h.renderList(c, "You cannot change your own role")
+		return
+	}
+	role := users.Role(c.PostForm("role"))
+	if err := users.SetRole(name, role); err != nil {
+		h.renderError(c, "Failed to change role", err)
+		return
+	}
+	slog.Info("Changed user role", "user", name, "role", role, "by", currentUser(c))
+	record(c, audit.ActionSetRole, name, string(role))
+	h.renderList(c, "")
+}
+
+// SetPassword replaces the password of a user.
+func (h *UsersHandler) SetPassword(c *gin.Context) {
+	name := c.Param("name")
+	if err := users.SetPassword(name, c.PostForm("password")); err != nil {
+		h.renderError(c, "Failed to change password", err)
+		return
+	}
+	slog.Info("Changed user password", "user", name, "by", currentUser(c))
+	record(c, audit.ActionSetPassword, name, "")
+	h.renderList(c, "")
+}
+
+// Delete removes a user. Admins cannot delete themselves.
+func (h *UsersHandler) Delete(c *gin.Context) {
+	name := c.Param("name")
+	if name == currentUser(c) {
+		h.renderList(c, "You cannot delete your own account")
+		return
+	}
+	if err := users.Delete(name); err != nil {
+		h.renderError(c, "Failed to delete user", err)
+		return
+	}
+	slog.Info("Deleted user", "user", name, "by", currentUser(c))
+	record(c, audit.ActionDeleteUser, name, "")
+	h.renderList(c, "")
+}
+
+// renderError shows validation errors next to the list and logs anything else.
+func (h *UsersHandler) renderError(c *gin.Context, msg string, err error) {
+	switch {
+	case errors.Is(err, users.ErrUserNotFound):
+		c.String(http.StatusNotFound, "User not found")
+	case errors.Is(err, users.ErrInvalidUser), errors.Is(err, users.ErrUserExists), errors.Is(err, users.ErrLastAdmin):
+		h.renderList(c, err.Error())
+	default:
+		slog.Error(msg, "user", c.Param("name"), "error", err)
+		c.String(http.StatusInternalServerError, msg)
+	}
+}
+
+func (h *UsersHandler) renderList(c *gin.Context, errMsg string) {
+	usersTemplate(h.TemplateFS).ExecuteTemplate(c.Writer, "user-list", h.listData(c, errMsg))
+}
+
+func (h *UsersHandler) listData(c *gin.Context, errMsg string) gin.H {
+	list, err := users.List()
+	if err != nil {
+		slog.Error("Failed to list users", "error", err)
+		list = []users.User{}
+	}
+	return gin.H{
+		"users":       list,
+		"currentUser": middleware.CurrentUser(c),
+		"error":       errMsg,
+	}
+}
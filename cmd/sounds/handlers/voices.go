@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"log/slog"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+)
+
+// voiceProfilesPath is the file with custom voice profiles, shared with
+// the fish through the data directory.
+const voiceProfilesPath = "./sound-data/voices.json"
+
+// voiceProfiles returns the voice profiles texts can be said in.
+func voiceProfiles() piper.Profiles {
+	profiles, err := piper.LoadProfiles(voiceProfilesPath)
+	if err != nil {
+		slog.Error("Failed to load voice profiles", "error", err)
+	}
+	return profiles
+}
+
+// knownVoice reports whether name is a voice profile, or empty for the
+// default voice.
+func knownVoice(name string) bool {
+	_, ok := voiceProfiles().Get(name)
+	return ok
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/middleware"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
+)
+
+// VolumeHandler shows the volume settings of the fish to members and lets
+// admins change them.
+type VolumeHandler struct {
+	TemplateFS embed.FS
+}
+
+// Page renders the volume page.
+func (h *VolumeHandler) Page(c *gin.Context) {
+	settings, err := volume.Get()
+	data := h.formData(c, settings)
+	if err != nil {
+		slog.Error("Failed to read volume settings", "error", err)
+		data["error"] = "Failed to read volume settings"
+	}
+	data["csrfToken"] = middleware.CSRFToken(c)
+	if err := volumeTemplate(h.TemplateFS).Execute(c.Writer, data); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to render page")
+	}
+}
+
+// Update saves the posted settings.
+func (h *VolumeHandler) Update(c *gin.Context) {
+	settings, err := h.parseForm(c)
+	if err == nil {
+		err = saveVolume(c, settings)
+	}
+	data := h.formData(c, settings)
+	switch {
+	case errors.Is(err, volume.ErrInvalidSettings):
+		data["error"] = err.Error()
+	case err != nil:
+		data["error"] = "Failed to save volume settings"
+	default:
+		data["saved"] = true
+	}
+	volumeTemplate(h.TemplateFS).ExecuteTemplate(c.Writer, "volume-form", data)
+}
+
+func (h *VolumeHandler) parseForm(c *gin.Context) (volume.Settings, error) {
+	settings := volume.Settings{Gains: map[volume.Source]float64{}}
+	master, err := strconv.Atoi(c.PostForm("master"))
+	if err != nil {
+		return settings, fmt.Errorf("%w: invalid master volume", volume.ErrInvalidSettings)
+	}
+	settings.Master = master
+	for _, source := range volume.Sources {
+		gain, err := strconv.ParseFloat(c.DefaultPostForm("gain_"+string(source), "0"), 64)
+		if err != nil {
+			return settings, fmt.Errorf("%w: invalid gain of %s", volume.ErrInvalidSettings, source)
+		}
+		if gain != 0 {
+			settings.Gains[source] = gain
+		}
+	}
+	if settings.Duck, err = strconv.ParseFloat(c.DefaultPostForm("duck", "0"), 64); err != nil {
+		return settings, fmt.Errorf("%w: invalid ducking", volume.ErrInvalidSettings)
+	}
+	settings.Curve, err = volume.ParseCurve(c.PostForm("curve"))
+	return settings, err
+}
+
+// formData returns the data of the volume form. Only admins may change
+// the settings, everyone else sees them read-only.
+func (h *VolumeHandler) formData(c *gin.Context, settings volume.Settings) gin.H {
+	return gin.H{
+		"canEdit":  canEdit(c),
+		"settings": settings,
+		"sources":  volume.Sources,
+		"curve":    volume.FormatCurve(settings.Curve),
+		"level":    int(settings.Level(time.Now())),
+		"minGain":  volume.MinGain,
+		"maxGain":  volume.MaxGain,
+		"maxDuck":  volume.MaxDuck,
+	}
+}
+
+// saveVolume saves settings and records the change.
+func saveVolume(c *gin.Context, settings volume.Settings) error {
+	if err := volume.Set(settings); err != nil {
+		if !errors.Is(err, volume.ErrInvalidSettings) {
+			slog.Error("Failed to save volume settings", "error", err)
+		}
+		return err
+	}
+	slog.Info("Changed volume", "settings", settings.String(), "by", currentUser(c))
+	record(c, audit.ActionSetVolume, "volume", settings.String())
+	return nil
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+)
+
+const (
+	waveformBarWidth = 2
+	waveformBarGap   = 1
+	waveformHeight   = 40
+	waveformPlayed   = "#0891b2" // cyan-600
+	waveformTrimmed  = "#cbd5e1" // slate-300
+)
+
+// waveformSVG draws the stored waveform of clip as a bar chart scaled to
+// its loudest part. Trimmed parts are greyed out and fades scale the bars
+// like they scale playback.
+func waveformSVG(clip library.Clip) string {
+	peaks := clip.Waveform
+	width := len(peaks) * (waveformBarWidth + waveformBarGap)
+	loudest := max(1, slices.Max(peaks))
+
+	start := clip.Edit.Start
+	end := start + clip.PlaybackDuration()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" preserveAspectRatio="none">`, width, waveformHeight)
+	for i, peak := range peaks {
+		// Position of the middle of this bar within the clip
+		t := time.Duration((float64(i) + 0.5) / float64(len(peaks)) * float64(clip.Duration))
+
+		color := waveformPlayed
+		scale := 1.0
+		switch {
+		case t < start || t >= end:
+			color = waveformTrimmed
+		case clip.Edit.FadeIn > 0 && t-start < clip.Edit.FadeIn:
+			scale = float64(t-start) / float64(clip.Edit.FadeIn)
+		case clip.Edit.FadeOut > 0 && end-t < clip.Edit.FadeOut:
+			scale = float64(end-t) / float64(clip.Edit.FadeOut)
+		}
+
+		height := max(1, float64(peak)/float64(loudest)*waveformHeight*scale)
+		fmt.Fprintf(&b, `<rect x="%d" y="%.1f" width="%d" height="%.1f" fill="%s"/>`,
+			i*(waveformBarWidth+waveformBarGap), (waveformHeight-height)/2, waveformBarWidth, height, color)
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
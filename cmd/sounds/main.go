@@ -1,21 +1,35 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/handlers"
 	"github.com/wachiwi/sebaschtian-the-fish/cmd/sounds/middleware"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/api"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audit"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/camera"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/certs"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/logger"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/oidc"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/quota"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/throttle"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
 )
 
 //go:embed templates/*
@@ -30,6 +44,22 @@ func main() {
 	// Initialize Playlist with the correct path
 	// The container mounts the volume at /app/sound-data, which corresponds to ./sound-data relative to WORKDIR /app
 	playlist.Init("./sound-data")
+	library.Init("./sound-data")
+	users.Init("./sound-data")
+	audit.Init("./sound-data")
+
+	// Measure the loudness of clips stored before normalization existed
+	go func() {
+		if err := library.MeasureMissing(); err != nil {
+			slog.Error("Failed to measure clip loudness", "error", err)
+		}
+	}()
+
+	// Remove deleted clips for good once they have been in the trash long enough
+	go library.AutoPurge(context.Background(), 1*time.Hour)
+
+	// Pick up queue, history and now-playing changes written by the fish
+	go playlist.Watch(context.Background(), 1*time.Second)
 
 	gin.SetMode(gin.ReleaseMode)
 	// --- Credentials and Session Setup ---
@@ -38,13 +68,27 @@ func main() {
 		port = "8080"
 	}
 
-	user := os.Getenv("SOUNDS_USER")
-	password := os.Getenv("SOUNDS_PASSWORD")
 	sessionSecret := os.Getenv("SOUNDS_SESSION_SECRET")
-	if user == "" || password == "" || sessionSecret == "" {
-		logger.Fatal("SOUNDS_USER, SOUNDS_PASSWORD, and SOUNDS_SESSION_SECRET must be set")
+	if sessionSecret == "" {
+		logger.Fatal("SOUNDS_SESSION_SECRET must be set")
+	}
+	// SOUNDS_USER and SOUNDS_PASSWORD create the first admin; further users
+	// are managed on the Users page.
+	if err := users.Bootstrap(os.Getenv("SOUNDS_USER"), os.Getenv("SOUNDS_PASSWORD")); err != nil {
+		logger.Fatal("Failed to set up users, set SOUNDS_USER and SOUNDS_PASSWORD for the first admin", "error", err)
 	}
 
+	// Scheduled items are entered in local office time, matching the fish's cron location.
+	timezone := os.Getenv("SOUNDS_TIMEZONE")
+	if timezone == "" {
+		timezone = "Europe/Berlin"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		logger.Fatal("Error loading location", "timezone", timezone, "error", err)
+	}
+	volume.Init("./sound-data", loc)
+
 	// --- Camera Setup ---
 	// RPi Camera Module v3 specs: up to 2304x1296 @ 56fps or 1920x1080 @ 120fps
 	// Using 1920x1080 @ 60fps for high quality, smooth video
@@ -60,22 +104,62 @@ func main() {
 
 	// --- Handlers Setup ---
 	authHandler := &handlers.AuthHandler{
-		User:       user,
-		Password:   password,
 		TemplateFS: templateFS,
+		OIDC:       oidcFromEnv(),
+		// Generous per client, so a shared office IP does not lock everyone out
+		IPLimiter:   throttle.New(20, 15*time.Minute, 15*time.Minute),
+		UserLimiter: throttle.New(5, 15*time.Minute, 15*time.Minute),
+	}
+	// Per-user limits on the queue, zero disables a limit
+	queueQuota, err := quota.Load(quota.Limits{
+		MaxQueued:      intFromEnv("SOUNDS_QUOTA_MAX_QUEUED", 5),
+		PlaysPerHour:   intFromEnv("SOUNDS_QUOTA_PLAYS_PER_HOUR", 20),
+		TTSCharsPerDay: intFromEnv("SOUNDS_QUOTA_TTS_CHARS_PER_DAY", 2000),
+		MaxScheduled:   intFromEnv("SOUNDS_QUOTA_MAX_SCHEDULED", 10),
+	}, time.Now())
+	if err != nil {
+		logger.Fatal("Failed to rebuild the quota from the history", "error", err)
 	}
-	fileHandler := &handlers.FileHandler{TemplateFS: templateFS}
-	queueHandler := &handlers.QueueHandler{TemplateFS: templateFS}
+	// Scheduled runs count against the quota of whoever scheduled them
+	go queueQuota.ChargeScheduled(context.Background())
+	usersHandler := &handlers.UsersHandler{TemplateFS: templateFS}
+	fileHandler := &handlers.FileHandler{TemplateFS: templateFS, Location: loc}
+	queueHandler := &handlers.QueueHandler{TemplateFS: templateFS, Location: loc, Quota: queueQuota}
+	scheduleHandler := &handlers.ScheduleHandler{TemplateFS: templateFS, Location: loc, Quota: queueQuota}
+	eventsHandler := &handlers.EventsHandler{TemplateFS: templateFS, Location: loc}
+	libraryHandler := &handlers.LibraryHandler{TemplateFS: templateFS}
 	cameraHandler := &handlers.CameraHandler{Cam: cam}
+	tokensHandler := &handlers.TokensHandler{TemplateFS: templateFS}
+	apiHandler := &handlers.APIHandler{Location: loc, Cam: cam, Quota: queueQuota}
+	auditHandler := &handlers.AuditHandler{TemplateFS: templateFS, Location: loc}
+	volumeHandler := &handlers.VolumeHandler{TemplateFS: templateFS}
 
 	router := gin.Default()
-	router.SetTrustedProxies([]string{"127.0.0.1"})
+	// Client IPs from X-Forwarded-For are only believed from these proxies,
+	// login throttling relies on them
+	trustedProxies := []string{"127.0.0.1"}
+	if env, ok := os.LookupEnv("SOUNDS_TRUSTED_PROXIES"); ok {
+		trustedProxies = splitList(env)
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		logger.Fatal("Invalid SOUNDS_TRUSTED_PROXIES", "error", err)
+	}
 
+	middleware.SessionMaxAge = durationFromEnv("SOUNDS_SESSION_MAX_AGE", middleware.SessionMaxAge)
+	middleware.SessionIdleTimeout = durationFromEnv("SOUNDS_SESSION_IDLE_TIMEOUT", middleware.SessionIdleTimeout)
+	tlsCerts := tlsFromEnv()
+	// Cookies are HTTPS only when serving HTTPS. Behind a TLS terminating
+	// proxy, set SOUNDS_SECURE_COOKIES=true.
+	secureCookies := tlsCerts != nil
+	if env := os.Getenv("SOUNDS_SECURE_COOKIES"); env != "" {
+		secureCookies = env == "true"
+	}
 	store := cookie.NewStore([]byte(sessionSecret))
 	store.Options(sessions.Options{
 		Path:     "/",
+		MaxAge:   int(middleware.SessionMaxAge.Seconds()),
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
+		Secure:   secureCookies,
 		SameSite: http.SameSiteLaxMode,
 	})
 	router.Use(sessions.Sessions("sound_session", store))
@@ -86,23 +170,234 @@ func main() {
 		c.FileFromFS(c.Param("filepath"), http.FS(staticSubFS))
 	})
 
-	router.GET("/login", authHandler.LoginPage)
-	router.POST("/login", authHandler.Login)
+	if tlsCerts != nil && tlsCerts.CACertificate() != nil {
+		// Browsers trust the self-signed certificates once this is installed
+		router.GET("/ca.pem", func(c *gin.Context) {
+			c.Header("Content-Disposition", `attachment; filename="sebaschtian-ca.pem"`)
+			c.Data(http.StatusOK, "application/x-pem-file", tlsCerts.CACertificate())
+		})
+	}
+
+	router.GET("/login", middleware.CSRF, authHandler.LoginPage)
+	router.POST("/login", middleware.CSRF, authHandler.Login)
+	if authHandler.OIDC != nil {
+		router.GET("/login/oidc", authHandler.OIDCLogin)
+		router.GET("/login/oidc/callback", authHandler.OIDCCallback)
+	}
 
 	// --- Authenticated Routes ---
+	// Viewers may only watch the camera
 	authorized := router.Group("/")
-	authorized.Use(middleware.AuthRequired)
+	authorized.Use(middleware.AuthRequired, middleware.CSRF)
 	{
-		authorized.Static("/sounds", "./sound-data")
-
 		authorized.GET("/", fileHandler.Index)
-		authorized.POST("/upload", fileHandler.Upload)
-		authorized.GET("/queue", queueHandler.List)
-		authorized.POST("/play/:filename", queueHandler.Play)
-		authorized.GET("/logout", authHandler.Logout)
+		authorized.POST("/logout", authHandler.Logout)
 		authorized.GET("/camera/stream", cameraHandler.Stream)
+		authorized.GET("/tokens", tokensHandler.Page)
+		authorized.POST("/tokens", tokensHandler.Create)
+		authorized.DELETE("/tokens/:id", tokensHandler.Revoke)
+	}
+
+	// Members may listen to, queue and schedule sounds and see the volume
+	member := authorized.Group("/")
+	member.Use(middleware.RequireRole(users.RoleMember))
+	{
+		member.GET("/sounds/:name", fileHandler.Sound)
+		member.HEAD("/sounds/:name", fileHandler.Sound)
+
+		member.GET("/export.zip", fileHandler.Export)
+		member.GET("/library", libraryHandler.Search)
+		member.GET("/library/:name", libraryHandler.Get)
+		member.GET("/library/:name/waveform.svg", libraryHandler.Waveform)
+		member.GET("/queue", queueHandler.List)
+		member.POST("/play/:filename", queueHandler.Play)
+		member.GET("/schedule", scheduleHandler.List)
+		member.POST("/schedule", scheduleHandler.Create)
+		member.DELETE("/schedule/:id", scheduleHandler.Delete)
+		member.GET("/events", eventsHandler.Stream)
+		member.GET("/volume", volumeHandler.Page)
+	}
+
+	// Admins may change the library and the volume and manage users
+	admin := authorized.Group("/")
+	admin.Use(middleware.RequireRole(users.RoleAdmin))
+	{
+		admin.POST("/upload", fileHandler.Upload)
+		admin.DELETE("/sounds/:name", fileHandler.Delete)
+		admin.POST("/sounds/delete", fileHandler.BulkDelete)
+		admin.POST("/sounds/:name/rename", fileHandler.Rename)
+		admin.POST("/import", fileHandler.Import)
+		admin.POST("/trash/:id/restore", fileHandler.Restore)
+		admin.DELETE("/trash/:id", fileHandler.Purge)
+		admin.POST("/library/:name", libraryHandler.Update)
+		admin.POST("/library/:name/edit", libraryHandler.Edit)
+		admin.GET("/users", usersHandler.Page)
+		admin.POST("/users", usersHandler.Create)
+		admin.POST("/users/:name/role", usersHandler.SetRole)
+		admin.POST("/users/:name/password", usersHandler.SetPassword)
+		admin.DELETE("/users/:name", usersHandler.Delete)
+		admin.GET("/audit", auditHandler.Page)
+		admin.GET("/audit/entries", auditHandler.List)
+		admin.GET("/audit.jsonl", auditHandler.Export)
+		admin.POST("/volume", volumeHandler.Update)
+	}
+
+	// --- API Routes ---
+	// Scripts authenticate with a user's API token and get that user's role
+	router.GET(api.BasePath+"/openapi.yaml", func(c *gin.Context) {
+		c.FileFromFS("openapi.yaml", http.FS(staticSubFS))
+	})
+	apiViewer := router.Group(api.BasePath)
+	apiViewer.Use(middleware.TokenRequired)
+	{
+		apiViewer.GET("/me", apiHandler.Me)
+		apiViewer.GET("/camera/snapshot", apiHandler.Snapshot)
+	}
+
+	apiMember := apiViewer.Group("/")
+	apiMember.Use(middleware.RequireRole(users.RoleMember))
+	{
+		apiMember.GET("/sounds", apiHandler.Sounds)
+		apiMember.GET("/sounds/:name", apiHandler.Sound)
+		apiMember.GET("/queue", apiHandler.Queue)
+		apiMember.POST("/queue", apiHandler.Enqueue)
+		apiMember.GET("/history", apiHandler.History)
+		apiMember.POST("/say", apiHandler.Say)
+		apiMember.GET("/voices", apiHandler.Voices)
+		apiMember.GET("/volume", apiHandler.Volume)
+	}
+
+	apiAdmin := apiViewer.Group("/")
+	apiAdmin.Use(middleware.RequireRole(users.RoleAdmin))
+	{
+		apiAdmin.POST("/sounds", apiHandler.Upload)
+		apiAdmin.DELETE("/sounds/:name", apiHandler.DeleteSound)
+		apiAdmin.PUT("/volume", apiHandler.SetVolume)
+	}
+
+	if tlsCerts == nil {
+		slog.Info("Server is running", "url", fmt.Sprintf("http://localhost:%s", port))
+		router.Run(fmt.Sprintf(":%s", port))
+		return
+	}
+
+	// Plain HTTP only redirects to HTTPS and answers ACME challenges
+	tlsPort := os.Getenv("SOUNDS_TLS_PORT")
+	if tlsPort == "" {
+		tlsPort = "443"
+	}
+	go func() {
+		redirect := tlsCerts.HTTPHandler(certs.RedirectHandler(tlsPort))
+		if err := http.ListenAndServe(fmt.Sprintf(":%s", port), redirect); err != nil {
+			logger.Fatal("HTTP redirect listener failed", "error", err)
+		}
+	}()
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%s", tlsPort),
+		Handler:   router,
+		TLSConfig: tlsCerts.TLSConfig(),
+	}
+	slog.Info("Server is running", "url", fmt.Sprintf("https://localhost:%s", tlsPort), "redirect_port", port)
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		logger.Fatal("HTTPS server failed", "error", err)
+	}
+}
+
+// tlsFromEnv configures HTTPS from the SOUNDS_TLS* and SOUNDS_ACME_*
+// variables. It returns nil if SOUNDS_TLS is not set.
+func tlsFromEnv() *certs.Certs {
+	mode := os.Getenv("SOUNDS_TLS")
+	if mode == "" {
+		return nil
+	}
+	config := certs.Config{
+		Mode:          certs.Mode(mode),
+		Dir:           "./sound-data/tls",
+		Hosts:         splitList(os.Getenv("SOUNDS_TLS_HOSTS")),
+		CertFile:      os.Getenv("SOUNDS_TLS_CERT"),
+		KeyFile:       os.Getenv("SOUNDS_TLS_KEY"),
+		ACMEDirectory: os.Getenv("SOUNDS_ACME_DIRECTORY"),
+		ACMEEmail:     os.Getenv("SOUNDS_ACME_EMAIL"),
+	}
+	tlsCerts, err := certs.New(config)
+	if err != nil {
+		logger.Fatal("Failed to set up TLS, set SOUNDS_TLS to self-signed, files or acme", "error", err)
 	}
+	slog.Info("HTTPS enabled", "mode", config.Mode, "hosts", config.Hosts)
+	return tlsCerts
+}
 
-	slog.Info("Server is running", "url", fmt.Sprintf("http://localhost:%s", port))
-	router.Run(fmt.Sprintf(":%s", port))
+// oidcFromEnv configures single sign-on from the SOUNDS_OIDC_* variables.
+// It returns nil if SOUNDS_OIDC_ISSUER is not set.
+func oidcFromEnv() *oidc.Provider {
+	issuer := os.Getenv("SOUNDS_OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	config := oidc.Config{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("SOUNDS_OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("SOUNDS_OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("SOUNDS_OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields(os.Getenv("SOUNDS_OIDC_SCOPES")),
+		GroupsClaim:  os.Getenv("SOUNDS_OIDC_GROUPS_CLAIM"),
+		DefaultRole:  users.Role(os.Getenv("SOUNDS_OIDC_DEFAULT_ROLE")),
+	}
+	if config.ClientID == "" || config.RedirectURL == "" {
+		logger.Fatal("SOUNDS_OIDC_CLIENT_ID and SOUNDS_OIDC_REDIRECT_URL must be set for single sign-on")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"email", "profile", "groups"}
+	}
+	config.AllowedDomains = splitList(os.Getenv("SOUNDS_OIDC_ALLOWED_DOMAINS"))
+	groupRoles, err := oidc.ParseGroupRoles(os.Getenv("SOUNDS_OIDC_GROUP_ROLES"))
+	if err != nil {
+		logger.Fatal("Invalid SOUNDS_OIDC_GROUP_ROLES", "error", err)
+	}
+	config.GroupRoles = groupRoles
+	if config.DefaultRole != "" && !slices.Contains(users.Roles, config.DefaultRole) {
+		logger.Fatal("Invalid SOUNDS_OIDC_DEFAULT_ROLE", "role", config.DefaultRole)
+	}
+
+	slog.Info("Single sign-on enabled", "issuer", issuer, "allowed_domains", config.AllowedDomains)
+	return oidc.New(config)
+}
+
+// splitList splits a comma separated environment variable.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// durationFromEnv parses the duration in the environment variable key, e.g.
+// "12h", or returns def if it is not set.
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	env := os.Getenv(key)
+	if env == "" {
+		return def
+	}
+	d, err := time.ParseDuration(env)
+	if err != nil || d <= 0 {
+		logger.Fatal("Invalid duration", "variable", key, "value", env)
+	}
+	return d
+}
+
+// intFromEnv parses the number in the environment variable key, or returns
+// def if it is not set.
+func intFromEnv(key string, def int) int {
+	env := os.Getenv(key)
+	if env == "" {
+		return def
+	}
+	n, err := strconv.Atoi(env)
+	if err != nil || n < 0 {
+		logger.Fatal("Invalid number", "variable", key, "value", env)
+	}
+	return n
 }
@@ -1,27 +1,120 @@
 package middleware
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
 )
 
-// AuthRequired is a middleware to check for a valid session.
+// userKey is the context key AuthRequired stores the logged in user under.
+const userKey = "user"
+
+// apiKey marks requests authenticated by TokenRequired, which are answered
+// in JSON.
+const apiKey = "api"
+
+// AuthRequired is a middleware to check for a valid, unexpired session.
+// The user is looked up on every request, so deleted accounts take effect
+// immediately. Changing the password or role ends the user's sessions.
 func AuthRequired(c *gin.Context) {
 	session := sessions.Default(c)
-	user := session.Get("user")
-	if user == nil {
-		// If the request is from HTMX, trigger a client-side redirect.
-		if c.GetHeader("HX-Request") == "true" {
-			c.Header("HX-Redirect", "/login")
-			c.AbortWithStatus(http.StatusUnauthorized)
+	name, _ := session.Get("user").(string)
+	if name == "" {
+		redirectToLogin(c)
+		return
+	}
+	if sessionExpired(session, time.Now()) {
+		session.Clear()
+		session.Save()
+		redirectToLogin(c)
+		return
+	}
+	user, err := users.Get(name)
+	if err != nil {
+		if !errors.Is(err, users.ErrUserNotFound) {
+			slog.Error("Failed to look up user", "user", name, "error", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		session.Clear()
+		session.Save()
+		redirectToLogin(c)
+		return
+	}
+	if generation, _ := session.Get(generationKey).(int); generation != user.Generation {
+		session.Clear()
+		session.Save()
+		redirectToLogin(c)
+		return
+	}
+	c.Set(userKey, user)
+	c.Next()
+}
+
+// TokenRequired is a middleware to check for a valid API token in the
+// Authorization header. Like AuthRequired it stores the token's user.
+func TokenRequired(c *gin.Context) {
+	c.Set(apiKey, true)
+	secret, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "An API token is required"})
+		return
+	}
+	user, err := users.AuthenticateToken(strings.TrimSpace(secret))
+	if err != nil {
+		if !errors.Is(err, users.ErrInvalidCredentials) {
+			slog.Error("Failed to check API token", "error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check API token"})
 			return
 		}
-		// Otherwise, do a standard server-side redirect.
-		c.Redirect(http.StatusFound, "/login")
-		c.Abort()
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API token"})
 		return
 	}
+	c.Set(userKey, user)
 	c.Next()
 }
+
+func redirectToLogin(c *gin.Context) {
+	// If the request is from HTMX, trigger a client-side redirect.
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", "/login")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	// Otherwise, do a standard server-side redirect.
+	c.Redirect(http.StatusFound, "/login")
+	c.Abort()
+}
+
+// RequireRole returns a middleware that only lets users with at least the
+// given role through. It must run after AuthRequired.
+func RequireRole(role users.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil || !user.Can(role) {
+			if c.GetBool(apiKey) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You are not allowed to do that"})
+				return
+			}
+			c.String(http.StatusForbidden, "You are not allowed to do that")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentUser returns the user stored by AuthRequired, or nil.
+func CurrentUser(c *gin.Context) *users.User {
+	user, _ := c.Get(userKey)
+	u, _ := user.(*users.User)
+	return u
+}
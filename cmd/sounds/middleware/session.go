@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
+)
+
+// Session lifetimes, configured at startup.
+var (
+	// SessionMaxAge is how long a login lasts at most.
+	SessionMaxAge = 7 * 24 * time.Hour
+	// SessionIdleTimeout ends sessions that were not used for this long.
+	SessionIdleTimeout = 24 * time.Hour
+)
+
+// Session keys besides the user name
+const (
+	csrfKey       = "csrf"
+	loginAtKey    = "login_at"
+	lastSeenKey   = "last_seen"
+	generationKey = "generation"
+)
+
+// CSRFHeader carries the CSRF token on HTMX requests. The pages set it on
+// <body> via hx-headers, so every request they make inherits it.
+const CSRFHeader = "X-CSRF-Token"
+
+// seenInterval is how often the last use of a session is written back, so
+// that not every request sets a new cookie.
+const seenInterval = time.Minute
+
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// StartSession logs user in. The previous session is discarded with its
+// CSRF token, so values planted before the login do not carry over.
+func StartSession(c *gin.Context, user *users.User) error {
+	session := sessions.Default(c)
+	session.Clear()
+	now := time.Now().Unix()
+	session.Set("user", user.Name)
+	session.Set(generationKey, user.Generation)
+	session.Set(loginAtKey, now)
+	session.Set(lastSeenKey, now)
+	token := newSecret()
+	session.Set(csrfKey, token)
+	c.Set(csrfKey, token)
+	return session.Save()
+}
+
+// sessionExpired reports whether the login is older than SessionMaxAge or
+// unused for longer than SessionIdleTimeout. Sessions that are still valid
+// get their last use updated.
+func sessionExpired(session sessions.Session, now time.Time) bool {
+	loginAt, _ := session.Get(loginAtKey).(int64)
+	lastSeen, _ := session.Get(lastSeenKey).(int64)
+	if now.Sub(time.Unix(loginAt, 0)) > SessionMaxAge || now.Sub(time.Unix(lastSeen, 0)) > SessionIdleTimeout {
+		return true
+	}
+	if now.Sub(time.Unix(lastSeen, 0)) > seenInterval {
+		session.Set(lastSeenKey, now.Unix())
+		session.Save()
+	}
+	return false
+}
+
+// CSRF is a middleware that rejects state-changing requests without the
+// session's CSRF token in the CSRFHeader header or the csrf_token form
+// field. Safe requests get a token if the session has none yet.
+func CSRF(c *gin.Context) {
+	session := sessions.Default(c)
+	token, _ := session.Get(csrfKey).(string)
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		if token == "" {
+			token = newSecret()
+			session.Set(csrfKey, token)
+			session.Save()
+		}
+		c.Set(csrfKey, token)
+		c.Next()
+		return
+	}
+
+	sent := c.GetHeader(CSRFHeader)
+	if sent == "" {
+		sent = c.PostForm("csrf_token")
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		c.String(http.StatusForbidden, "Your session has expired, please reload the page")
+		c.Abort()
+		return
+	}
+	c.Set(csrfKey, token)
+	c.Next()
+}
+
+// CSRFToken returns the token pages must send back, see CSRF.
+func CSRFToken(c *gin.Context) string {
+	return c.GetString(csrfKey)
+}
@@ -0,0 +1,118 @@
+// Package api defines the JSON types of the sounds service's /api/v1 and a
+// client for it. The API is described in cmd/sounds/static/openapi.yaml.
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// BasePath is the path all API routes are served under.
+const BasePath = "/api/v1"
+
+// MaxSayLength is the longest text the fish can be asked to say.
+const MaxSayLength = 500
+
+// Sound is a clip in the library.
+type Sound struct {
+	Name       string    `json:"name"`
+	Title      string    `json:"title,omitempty"`
+	Category   string    `json:"category"`
+	Tags       []string  `json:"tags"`
+	Enabled    bool      `json:"enabled"`
+	Duration   float64   `json:"duration_seconds"`
+	PlayCount  int       `json:"play_count"`
+	Uploader   string    `json:"uploader,omitempty"`
+	UploadedAt time.Time `json:"uploaded_at,omitzero"`
+	// Loudness is the integrated loudness in LUFS, if it has been measured.
+	Loudness *float64 `json:"loudness_lufs,omitempty"`
+}
+
+// QueueEntry is a queued song or text with its expected start.
+type QueueEntry struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Source   string    `json:"source,omitempty"`
+	Duration float64   `json:"duration_seconds"`
+	StartsAt time.Time `json:"starts_at"`
+	// RequestedBy is the user who queued the entry.
+	RequestedBy string `json:"requested_by,omitempty"`
+	// Voice is the voice profile a text is said in, empty for the default.
+	Voice string `json:"voice,omitempty"`
+	// Effects is the audio effects chain, empty for none.
+	Effects string `json:"effects,omitempty"`
+}
+
+// HistoryEntry is a song or text the fish played.
+type HistoryEntry struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	PlayedAt time.Time `json:"played_at"`
+	// RequestedBy is the user who queued the entry, empty if the fish
+	// picked it on its own.
+	RequestedBy string `json:"requested_by,omitempty"`
+}
+
+// User is the user a token belongs to.
+type User struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// EnqueueRequest queues a sound from the library.
+type EnqueueRequest struct {
+	Name string `json:"name"`
+	// Effects is the audio effects chain to play it through, e.g.
+	// "underwater,pitch:4", empty for none.
+	Effects string `json:"effects,omitempty"`
+}
+
+// SayRequest queues a text for the fish to say.
+type SayRequest struct {
+	Text string `json:"text"`
+	// Voice is the voice profile to say it in, empty for the default.
+	Voice string `json:"voice,omitempty"`
+	// Effects is the audio effects chain to say it with, empty for none.
+	Effects string `json:"effects,omitempty"`
+}
+
+// Volume are the volume settings of the fish.
+type Volume struct {
+	// Master is the master volume in percent.
+	Master int `json:"master"`
+	// Gains are the gains of speech, songs and alerts in dB, on top of the
+	// master volume.
+	Gains map[string]float64 `json:"gains_db,omitempty"`
+	// Curve scales the master volume over the day.
+	Curve []VolumePoint `json:"curve,omitempty"`
+	// Duck lowers background audio while the fish speaks, in dB. Zero
+	// turns ducking off.
+	Duck float64 `json:"duck_db"`
+	// Level is the master volume right now in percent, following the curve.
+	// It is ignored by SetVolume.
+	Level float64 `json:"level"`
+}
+
+// VolumePoint sets the share of the master volume at a time of day.
+type VolumePoint struct {
+	// Time is the local time of day, e.g. 22:00.
+	Time string `json:"time"`
+	// Volume is in percent.
+	Volume int `json:"volume"`
+}
+
+// Error is the body of every failed request.
+type Error struct {
+	// StatusCode is the HTTP status of the response. It is not part of the
+	// body and only set by Client.
+	StatusCode int    `json:"-"`
+	Message    string `json:"error"`
+	// Reason identifies rejected uploads, see library.IngestError, and
+	// exceeded limits, see quota.ExceededError.
+	Reason string `json:"reason,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api: %d %s", e.StatusCode, e.Message)
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the API of a sounds service with a user's API token.
+type Client struct {
+	baseURL string
+	token   string
+	// HTTPClient sends the requests. It defaults to a client with a timeout.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the sounds service at baseURL, e.g.
+// "http://fish.local", authenticating with token.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + BasePath,
+		token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Me returns the user the token belongs to.
+func (c *Client) Me(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, "/me", nil, "", &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Sounds lists the sounds matching query in their name, title or tags,
+// restricted to category unless it is empty.
+func (c *Client) Sounds(ctx context.Context, query, category string) ([]Sound, error) {
+	params := url.Values{}
+	if query != "" {
+		params.Set("q", query)
+	}
+	if category != "" {
+		params.Set("category", category)
+	}
+	path := "/sounds"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	var sounds []Sound
+	if err := c.do(ctx, http.MethodGet, path, nil, "", &sounds); err != nil {
+		return nil, err
+	}
+	return sounds, nil
+}
+
+// Sound returns a single sound.
+func (c *Client) Sound(ctx context.Context, name string) (*Sound, error) {
+	var sound Sound
+	if err := c.do(ctx, http.MethodGet, "/sounds/"+url.PathEscape(name), nil, "", &sound); err != nil {
+		return nil, err
+	}
+	return &sound, nil
+}
+
+// Upload adds a sound to the library. The service validates it and may
+// store it under a different name, which the returned Sound holds.
+func (c *Client) Upload(ctx context.Context, filename string, r io.Reader) (*Sound, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+	var sound Sound
+	if err := c.do(ctx, http.MethodPost, "/sounds", &body, form.FormDataContentType(), &sound); err != nil {
+		return nil, err
+	}
+	return &sound, nil
+}
+
+// DeleteSound moves a sound to the trash.
+func (c *Client) DeleteSound(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/sounds/"+url.PathEscape(name), nil, "", nil)
+}
+
+// Queue returns the queued songs and texts in playback order.
+func (c *Client) Queue(ctx context.Context) ([]QueueEntry, error) {
+	var queue []QueueEntry
+	if err := c.do(ctx, http.MethodGet, "/queue", nil, "", &queue); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// Enqueue queues a sound from the library.
+func (c *Client) Enqueue(ctx context.Context, name string) (*QueueEntry, error) {
+	var entry QueueEntry
+	if err := c.sendJSON(ctx, http.MethodPost, "/queue", EnqueueRequest{Name: name}, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Say queues a text for the fish to say.
+func (c *Client) Say(ctx context.Context, text string) (*QueueEntry, error) {
+	return c.SayWithVoice(ctx, text, "")
+}
+
+// SayWithVoice queues text for the fish to say in the voice profile
+// called voice, as listed by Voices.
+func (c *Client) SayWithVoice(ctx context.Context, text, voice string) (*QueueEntry, error) {
+	var entry QueueEntry
+	if err := c.sendJSON(ctx, http.MethodPost, "/say", SayRequest{Text: text, Voice: voice}, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Voices lists the voice profiles texts can be said in, the default first.
+func (c *Client) Voices(ctx context.Context) ([]string, error) {
+	var voices []string
+	if err := c.do(ctx, http.MethodGet, "/voices", nil, "", &voices); err != nil {
+		return nil, err
+	}
+	return voices, nil
+}
+
+// Volume returns the volume settings of the fish.
+func (c *Client) Volume(ctx context.Context) (*Volume, error) {
+	var settings Volume
+	if err := c.do(ctx, http.MethodGet, "/volume", nil, "", &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetVolume replaces the volume settings of the fish and returns them as
+// saved.
+func (c *Client) SetVolume(ctx context.Context, settings Volume) (*Volume, error) {
+	var saved Volume
+	if err := c.sendJSON(ctx, http.MethodPut, "/volume", settings, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// History returns what the fish played recently, newest first.
+func (c *Client) History(ctx context.Context) ([]HistoryEntry, error) {
+	var history []HistoryEntry
+	if err := c.do(ctx, http.MethodGet, "/history", nil, "", &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// Snapshot returns the current camera frame as JPEG.
+func (c *Client) Snapshot(ctx context.Context) ([]byte, error) {
+	var frame bytes.Buffer
+	if err := c.do(ctx, http.MethodGet, "/camera/snapshot", nil, "", &frame); err != nil {
+		return nil, err
+	}
+	return frame.Bytes(), nil
+}
+
+func (c *Client) sendJSON(ctx context.Context, method, path string, req, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, bytes.NewReader(body), "application/json", resp)
+}
+
+// do sends a request and decodes the JSON response into resp, or copies it
+// if resp is a *bytes.Buffer. Failed requests return an *Error.
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, contentType string, resp any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: res.StatusCode}
+		if err := json.NewDecoder(res.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(res.StatusCode)
+		}
+		return apiErr
+	}
+	switch resp := resp.(type) {
+	case nil:
+		return nil
+	case *bytes.Buffer:
+		_, err := io.Copy(resp, res.Body)
+		return err
+	default:
+		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+			return fmt.Errorf("api: invalid response: %w", err)
+		}
+		return nil
+	}
+}
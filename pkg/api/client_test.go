@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/sounds", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") != "bell" {
+			t.Errorf("Expected query to be passed, got %q", r.URL.RawQuery)
+		}
+		json.NewEncoder(w).Encode([]Sound{{Name: "bell.wav", Category: "jingle", Duration: 1.5}})
+	})
+	mux.HandleFunc("POST /api/v1/sounds", func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Fatalf("Expected multipart upload, got %v", err)
+		}
+		data, _ := io.ReadAll(file)
+		if header.Filename != "Bell Sound.wav" || string(data) != "RIFF" {
+			t.Errorf("Unexpected upload %q with %q", header.Filename, data)
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(Sound{Name: "bell-sound.wav"})
+	})
+	mux.HandleFunc("POST /api/v1/say", func(w http.ResponseWriter, r *http.Request) {
+		var req SayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Text == "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(Error{Message: "text is required"})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(QueueEntry{Name: req.Text, Type: "text", Voice: req.Voice})
+	})
+	mux.HandleFunc("GET /api/v1/voices", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]string{"default", "english"})
+	})
+	mux.HandleFunc("PUT /api/v1/volume", func(w http.ResponseWriter, r *http.Request) {
+		var req Volume
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Curve) != 1 {
+			t.Errorf("Expected the settings to be sent, got %+v, %v", req, err)
+		}
+		req.Level = float64(req.Master)
+		json.NewEncoder(w).Encode(req)
+	})
+	mux.HandleFunc("GET /api/v1/camera/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte{0xff, 0xd8})
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fish_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(Error{Message: "invalid token"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	server := newTestServer(t)
+	client := NewClient(server.URL+"/", "fish_test")
+	ctx := context.Background()
+
+	sounds, err := client.Sounds(ctx, "bell", "")
+	if err != nil {
+		t.Fatalf("Sounds failed: %v", err)
+	}
+	if len(sounds) != 1 || sounds[0].Name != "bell.wav" || sounds[0].Duration != 1.5 {
+		t.Errorf("Unexpected sounds %+v", sounds)
+	}
+
+	sound, err := client.Upload(ctx, "Bell Sound.wav", strings.NewReader("RIFF"))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if sound.Name != "bell-sound.wav" {
+		t.Errorf("Expected the stored name, got %q", sound.Name)
+	}
+
+	entry, err := client.Say(ctx, "Lunch is ready")
+	if err != nil {
+		t.Fatalf("Say failed: %v", err)
+	}
+	if entry.Type != "text" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+
+	voices, err := client.Voices(ctx)
+	if err != nil {
+		t.Fatalf("Voices failed: %v", err)
+	}
+	if len(voices) != 2 || voices[0] != "default" {
+		t.Errorf("Unexpected voices %v", voices)
+	}
+	entry, err = client.SayWithVoice(ctx, "Lunch is ready", "english")
+	if err != nil {
+		t.Fatalf("SayWithVoice failed: %v", err)
+	}
+	if entry.Voice != "english" {
+		t.Errorf("Expected the voice to be passed, got %+v", entry)
+	}
+
+	settings, err := client.SetVolume(ctx, Volume{Master: 60, Curve: []VolumePoint{{Time: "22:00", Volume: 20}}})
+	if err != nil {
+		t.Fatalf("SetVolume failed: %v", err)
+	}
+	if settings.Master != 60 || settings.Level != 60 {
+		t.Errorf("Unexpected volume %+v", settings)
+	}
+
+	frame, err := client.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(frame) != 2 {
+		t.Errorf("Expected the JPEG, got %v", frame)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := NewClient(server.URL, "fish_test").Say(ctx, "")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Message != "text is required" {
+		t.Errorf("Expected the validation error, got %v", err)
+	}
+
+	_, err = NewClient(server.URL, "fish_wrong").Queue(ctx)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %v", err)
+	}
+
+	// Errors without a JSON body still carry the status
+	_, err = NewClient(server.URL, "fish_test").History(ctx)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "Not Found" {
+		t.Errorf("Expected 404, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Package audio inspects the sound files stored in the shared sound-data volume.
+package audio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/youpy/go-wav"
+)
+
+type durationKey struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+var (
+	durationMu    sync.Mutex
+	durationCache = map[durationKey]time.Duration{}
+)
+
+// Duration returns the playback length of a WAV or MP3 file.
+// Results are cached until the file is modified.
+func Duration(path string) (time.Duration, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	key := durationKey{path: path, modTime: info.ModTime(), size: info.Size()}
+
+	durationMu.Lock()
+	d, ok := durationCache[key]
+	durationMu.Unlock()
+	if ok {
+		return d, nil
+	}
+
+	d, err = readDuration(path)
+	if err != nil {
+		return 0, err
+	}
+
+	durationMu.Lock()
+	durationCache[key] = d
+	durationMu.Unlock()
+	return d, nil
+}
+
+func readDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav":
+		d, err := wav.NewReader(f).Duration()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read wav duration from '%s': %w", path, err)
+		}
+		return d, nil
+	case ".mp3":
+		decoder, err := mp3.NewDecoder(f)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create mp3 decoder for '%s': %w", path, err)
+		}
+		// go-mp3 always decodes to 16-bit stereo
+		samples := decoder.Length() / 4
+		return time.Duration(float64(samples) / float64(decoder.SampleRate()) * float64(time.Second)), nil
+	default:
+		return 0, fmt.Errorf("unsupported audio format '%s'", filepath.Ext(path))
+	}
+}
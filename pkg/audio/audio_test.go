@@ -0,0 +1,78 @@
+package audio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// silence returns silent 16-bit PCM of the given length.
+func silence(sampleRate, channels int, length time.Duration) *PCM {
+	frames := int(length.Seconds() * float64(sampleRate))
+	return &PCM{Data: make([]byte, frames*channels*2), SampleRate: sampleRate, Channels: channels}
+}
+
+// writeWav writes a silent 16-bit PCM WAV file of the given length.
+func writeWav(t *testing.T, path string, sampleRate, channels int, length time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, EncodeWAV(silence(sampleRate, channels, length)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "bell.wav")
+	writeWav(t, path, 22050, 1, 1500*time.Millisecond)
+
+	d, err := Duration(path)
+	if err != nil {
+		t.Fatalf("Duration failed: %v", err)
+	}
+	if d != 1500*time.Millisecond {
+		t.Errorf("Expected 1.5s, got %v", d)
+	}
+
+	// Cached value must be invalidated when the file changes
+	writeWav(t, path, 44100, 2, 3*time.Second)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	d, err = Duration(path)
+	if err != nil {
+		t.Fatalf("Duration failed: %v", err)
+	}
+	if d != 3*time.Second {
+		t.Errorf("Expected 3s after rewrite, got %v", d)
+	}
+
+	if _, err := Duration(filepath.Join(tmpDir, "notes.txt")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	wavData := EncodeWAV(silence(22050, 1, 2*time.Second))
+
+	pcm, format, err := Decode(wavData)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if format != FormatWAV || pcm.SampleRate != 22050 || pcm.Channels != 1 {
+		t.Errorf("Unexpected decode result: %s %d Hz %d ch", format, pcm.SampleRate, pcm.Channels)
+	}
+	if pcm.Duration() != 2*time.Second {
+		t.Errorf("Expected 2s, got %v", pcm.Duration())
+	}
+
+	if _, _, err := Decode([]byte("<html>not audio</html>")); err != ErrUnknownFormat {
+		t.Errorf("Expected ErrUnknownFormat, got %v", err)
+	}
+
+	// Correct magic bytes but garbage afterwards
+	if _, _, err := Decode([]byte("RIFF\x00\x00\x00\x00WAVEgarbage")); err == nil {
+		t.Error("Expected error for corrupt wav")
+	}
+	if _, _, err := Decode(append([]byte("ID3"), make([]byte, 64)...)); err == nil {
+		t.Error("Expected error for corrupt mp3")
+	}
+}
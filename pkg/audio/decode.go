@@ -0,0 +1,120 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/youpy/go-wav"
+)
+
+// Format is an audio container format the fish can play.
+type Format string
+
+const (
+	FormatWAV Format = "wav"
+	FormatMP3 Format = "mp3"
+)
+
+// Extension returns the file extension for f, including the leading dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// ErrUnknownFormat is returned when data is neither WAV nor MP3.
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+// PCM is decoded 16-bit little-endian interleaved audio.
+type PCM struct {
+	Data       []byte
+	SampleRate int
+	Channels   int
+}
+
+// Duration returns the playback length of the PCM data.
+func (p *PCM) Duration() time.Duration {
+	frames := len(p.Data) / (p.Channels * 2)
+	return time.Duration(float64(frames) / float64(p.SampleRate) * float64(time.Second))
+}
+
+// Sniff detects the audio format from the leading bytes of data,
+// independent of any file name.
+func Sniff(data []byte) (Format, error) {
+	switch {
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return FormatWAV, nil
+	case len(data) >= 3 && string(data[0:3]) == "ID3":
+		return FormatMP3, nil
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0:
+		// MPEG audio frame sync without an ID3 tag
+		return FormatMP3, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// Decode sniffs and fully decodes a WAV or MP3 file.
+// WAV files must contain 16-bit PCM with one or two channels.
+func Decode(data []byte) (*PCM, Format, error) {
+	format, err := Sniff(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var pcm *PCM
+	switch format {
+	case FormatWAV:
+		pcm, err = decodeWAV(data)
+	case FormatMP3:
+		pcm, err = decodeMP3(data)
+	}
+	if err != nil {
+		return nil, format, err
+	}
+	if len(pcm.Data) == 0 {
+		return nil, format, fmt.Errorf("%s file contains no audio", format)
+	}
+	return pcm, format, nil
+}
+
+func decodeWAV(data []byte) (*PCM, error) {
+	format, err := wav.NewReader(bytes.NewReader(data)).Format()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wav format: %w", err)
+	}
+	if format.AudioFormat != wav.AudioFormatPCM || format.BitsPerSample != 16 {
+		return nil, fmt.Errorf("unsupported wav encoding (format %d, %d bit), only 16-bit PCM is supported", format.AudioFormat, format.BitsPerSample)
+	}
+	if format.NumChannels < 1 || format.NumChannels > 2 {
+		return nil, fmt.Errorf("unsupported wav channel count %d", format.NumChannels)
+	}
+	if format.SampleRate == 0 {
+		return nil, errors.New("invalid wav sample rate 0")
+	}
+
+	pcmData, err := io.ReadAll(wav.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode wav data: %w", err)
+	}
+	// Drop a trailing partial frame from truncated files
+	frameSize := int(format.NumChannels) * 2
+	pcmData = pcmData[:len(pcmData)-len(pcmData)%frameSize]
+
+	return &PCM{Data: pcmData, SampleRate: int(format.SampleRate), Channels: int(format.NumChannels)}, nil
+}
+
+func decodeMP3(data []byte) (*PCM, error) {
+	decoder, err := mp3.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mp3 decoder: %w", err)
+	}
+	pcmData, err := io.ReadAll(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode mp3 data: %w", err)
+	}
+	// go-mp3 always decodes to 16-bit stereo
+	return &PCM{Data: pcmData, SampleRate: decoder.SampleRate(), Channels: 2}, nil
+}
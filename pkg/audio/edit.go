@@ -0,0 +1,93 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxEditGain bounds the gain of an Edit, in dB.
+const MaxEditGain = 20.0
+
+// Edit describes non-destructive changes to a clip. The stored file is
+// never modified; the player applies the edit after decoding.
+type Edit struct {
+	// Start and End trim the clip. A zero End keeps everything after Start.
+	Start time.Duration `json:"start,omitempty"`
+	End   time.Duration `json:"end,omitempty"`
+	// FadeIn and FadeOut ramp the volume linearly at the trimmed edges.
+	FadeIn  time.Duration `json:"fade_in,omitempty"`
+	FadeOut time.Duration `json:"fade_out,omitempty"`
+	// Gain in dB is applied on top of loudness normalization, so it is
+	// not part of Apply.
+	Gain float64 `json:"gain_db,omitempty"`
+}
+
+// IsZero reports whether the edit leaves the clip unchanged.
+func (e Edit) IsZero() bool {
+	return e == Edit{}
+}
+
+// Length returns the playback length of a clip of the given length after trimming.
+func (e Edit) Length(length time.Duration) time.Duration {
+	end := e.End
+	if end == 0 || end > length {
+		end = length
+	}
+	return max(0, end-e.Start)
+}
+
+// Validate checks the edit against a clip of the given length.
+func (e Edit) Validate(length time.Duration) error {
+	switch {
+	case e.Start < 0 || e.End < 0 || e.FadeIn < 0 || e.FadeOut < 0:
+		return errors.New("times must not be negative")
+	case e.Start >= length:
+		return fmt.Errorf("start is beyond the end of the %s clip", length.Round(time.Millisecond))
+	case e.End != 0 && e.End <= e.Start:
+		return errors.New("end must be after start")
+	case e.FadeIn+e.FadeOut > e.Length(length):
+		return errors.New("fades are longer than the trimmed clip")
+	case e.Gain < -MaxEditGain || e.Gain > MaxEditGain:
+		return fmt.Errorf("gain must be between -%.0f and +%.0f dB", MaxEditGain, MaxEditGain)
+	}
+	return nil
+}
+
+// Apply returns a trimmed and faded copy of p, or p itself if the edit
+// neither trims nor fades. Invalid trim points are clamped to the clip, so
+// a clip that was replaced by a shorter file still plays. Gain is not
+// applied, see Edit.Gain.
+func (e Edit) Apply(p *PCM) *PCM {
+	if e.Start == 0 && e.End == 0 && e.FadeIn == 0 && e.FadeOut == 0 {
+		return p
+	}
+	frameSize := p.Channels * 2
+	frames := len(p.Data) / frameSize
+	toFrame := func(d time.Duration) int {
+		return min(frames, max(0, int(d.Seconds()*float64(p.SampleRate))))
+	}
+
+	start := toFrame(e.Start)
+	end := frames
+	if e.End != 0 {
+		end = max(start, toFrame(e.End))
+	}
+	out := &PCM{
+		Data:       append([]byte(nil), p.Data[start*frameSize:end*frameSize]...),
+		SampleRate: p.SampleRate,
+		Channels:   p.Channels,
+	}
+
+	length := end - start
+	fadeIn := min(length, toFrame(e.FadeIn))
+	fadeOut := min(length, toFrame(e.FadeOut))
+	for i := 0; i < fadeIn; i++ {
+		scaleSamples(out.Data[i*frameSize:(i+1)*frameSize], float64(i)/float64(fadeIn))
+	}
+	for i := 0; i < fadeOut; i++ {
+		frame := length - 1 - i
+		scaleSamples(out.Data[frame*frameSize:(frame+1)*frameSize], float64(i)/float64(fadeOut))
+	}
+	return out
+}
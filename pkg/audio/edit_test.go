@@ -0,0 +1,99 @@
+package audio
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// ramp returns mono PCM at 100 Hz whose samples count up from 1.
+func ramp(frames int) *PCM {
+	data := make([]byte, frames*2)
+	for i := 0; i < frames; i++ {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(1000*(i+1)))
+	}
+	return &PCM{Data: data, SampleRate: 100, Channels: 1}
+}
+
+func sampleAt(p *PCM, frame int) int16 {
+	return int16(binary.LittleEndian.Uint16(p.Data[frame*2:]))
+}
+
+func TestEditApply(t *testing.T) {
+	pcm := ramp(20) // 200ms
+
+	trimmed := Edit{Start: 50 * time.Millisecond, End: 150 * time.Millisecond}.Apply(pcm)
+	if trimmed.Duration() != 100*time.Millisecond {
+		t.Fatalf("Expected 100ms after trimming, got %v", trimmed.Duration())
+	}
+	if got := sampleAt(trimmed, 0); got != 6000 {
+		t.Errorf("Expected trimmed clip to start at frame 5, got sample %d", got)
+	}
+	if sampleAt(pcm, 0) != 1000 {
+		t.Error("Apply modified the original data")
+	}
+
+	faded := Edit{FadeIn: 40 * time.Millisecond, FadeOut: 40 * time.Millisecond}.Apply(pcm)
+	if got := sampleAt(faded, 0); got != 0 {
+		t.Errorf("Expected fade in to start silent, got %d", got)
+	}
+	if got := sampleAt(faded, 2); got != 1500 {
+		t.Errorf("Expected half volume halfway through the fade in, got %d", got)
+	}
+	if got := sampleAt(faded, 19); got != 0 {
+		t.Errorf("Expected fade out to end silent, got %d", got)
+	}
+	if got := sampleAt(faded, 10); got != 11000 {
+		t.Errorf("Expected untouched middle, got %d", got)
+	}
+
+	// Trim points beyond a shorter replacement file are clamped
+	if got := (Edit{Start: time.Second}).Apply(pcm); len(got.Data) != 0 {
+		t.Errorf("Expected empty clip, got %d bytes", len(got.Data))
+	}
+	if got := (Edit{Gain: 6}).Apply(pcm); got != pcm {
+		t.Error("Expected a gain-only edit to leave the audio alone")
+	}
+}
+
+func TestEditValidate(t *testing.T) {
+	length := 10 * time.Second
+	tests := []struct {
+		name  string
+		edit  Edit
+		valid bool
+	}{
+		{"empty", Edit{}, true},
+		{"trim", Edit{Start: time.Second, End: 4 * time.Second, FadeIn: time.Second, FadeOut: 2 * time.Second}, true},
+		{"negative", Edit{Start: -time.Second}, false},
+		{"start beyond end", Edit{Start: 10 * time.Second}, false},
+		{"end before start", Edit{Start: 2 * time.Second, End: time.Second}, false},
+		{"fades too long", Edit{End: 2 * time.Second, FadeIn: time.Second, FadeOut: 1500 * time.Millisecond}, false},
+		{"gain", Edit{Gain: MaxEditGain + 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.edit.Validate(length); (err == nil) != tt.valid {
+				t.Errorf("Expected valid=%v, got %v", tt.valid, err)
+			}
+		})
+	}
+
+	if got := (Edit{Start: 2 * time.Second, End: 20 * time.Second}).Length(length); got != 8*time.Second {
+		t.Errorf("Expected end to be clamped to the clip, got %v", got)
+	}
+}
+
+func TestWaveform(t *testing.T) {
+	peaks := Waveform(ramp(20), 4)
+	if len(peaks) != 4 {
+		t.Fatalf("Expected 4 peaks, got %d", len(peaks))
+	}
+	// Buckets hold frames 0-4, 5-9, ... with peaks 5000, 10000, ...
+	if peaks[0] != 38 || peaks[3] != 155 {
+		t.Errorf("Unexpected peaks %v", peaks)
+	}
+	if got := Waveform(ramp(2), 120); len(got) != 2 {
+		t.Errorf("Expected one peak per frame for tiny clips, got %d", len(got))
+	}
+}
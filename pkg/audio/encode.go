@@ -0,0 +1,25 @@
+package audio
+
+import (
+	"encoding/binary"
+)
+
+// EncodeWAV wraps the PCM data in a canonical 44-byte RIFF/WAVE header.
+func EncodeWAV(p *PCM) []byte {
+	blockAlign := p.Channels * 2
+	out := make([]byte, 44+len(p.Data))
+	copy(out[0:], "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(36+len(p.Data)))
+	copy(out[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(out[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(out[20:], 1)  // PCM
+	binary.LittleEndian.PutUint16(out[22:], uint16(p.Channels))
+	binary.LittleEndian.PutUint32(out[24:], uint32(p.SampleRate))
+	binary.LittleEndian.PutUint32(out[28:], uint32(p.SampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(out[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(out[34:], 16) // bits per sample
+	copy(out[36:], "data")
+	binary.LittleEndian.PutUint32(out[40:], uint32(len(p.Data)))
+	copy(out[44:], p.Data)
+	return out
+}
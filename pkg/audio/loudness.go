@@ -0,0 +1,259 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const (
+	// TargetLoudness is the integrated loudness clips are normalized to, in LUFS.
+	TargetLoudness = -16.0
+	// TruePeakCeiling is the highest true peak normalization may produce, in dBTP.
+	TruePeakCeiling = -1.0
+	// MaxGain bounds the correction applied to very quiet or very loud clips, in dB.
+	MaxGain = 20.0
+
+	// absoluteGate is the EBU R128 absolute gating threshold in LUFS. It is
+	// also reported as the loudness of silent clips.
+	absoluteGate = -70.0
+	// relativeGate is the EBU R128 relative gating threshold in LU.
+	relativeGate = -10.0
+	// silentPeak is reported as the true peak of silent clips, in dBTP.
+	silentPeak = -100.0
+
+	blockDuration  = 0.4 // gating block length in seconds
+	blockOverlap   = 4   // blocks overlap by 75%
+	oversampling   = 4   // true peak oversampling factor
+	tapsPerPhase   = 12  // FIR taps per oversampling phase
+	int16FullScale = 32768.0
+)
+
+// Loudness is the result of an ITU-R BS.1770 / EBU R128 measurement.
+type Loudness struct {
+	// Integrated is the gated integrated loudness in LUFS.
+	Integrated float64 `json:"integrated"`
+	// TruePeak is the 4x oversampled true peak in dBTP.
+	TruePeak float64 `json:"true_peak"`
+}
+
+// NormalizationGain returns the gain in dB that brings a clip to
+// TargetLoudness without pushing its true peak above TruePeakCeiling.
+func (l Loudness) NormalizationGain() float64 {
+	if l.Integrated <= absoluteGate {
+		// Silence: there is nothing to normalize
+		return 0
+	}
+	gain := TargetLoudness - l.Integrated
+	if headroom := TruePeakCeiling - l.TruePeak; gain > headroom {
+		gain = headroom
+	}
+	return math.Max(-MaxGain, math.Min(MaxGain, gain))
+}
+
+// biquad is a direct form I second order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the two BS.1770 pre-filter stages (high shelf and
+// high pass) for the given sample rate, derived via the bilinear transform
+// so that rates other than 48 kHz are supported.
+func kWeighting(sampleRate int) (*biquad, *biquad) {
+	rate := float64(sampleRate)
+
+	// Stage 1: high shelf modelling the acoustic effect of the head
+	f0 := 1681.974450955533
+	gain := 3.999843853973347
+	q := 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := &biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// Stage 2: RLB high pass
+	f0 = 38.13547087602444
+	q = 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	highPass := &biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// samples converts 16-bit PCM to floats in [-1, 1), one slice per channel.
+func samples(p *PCM) [][]float64 {
+	frames := len(p.Data) / (p.Channels * 2)
+	channels := make([][]float64, p.Channels)
+	for ch := range channels {
+		channels[ch] = make([]float64, frames)
+	}
+	for i := 0; i < frames; i++ {
+		for ch := 0; ch < p.Channels; ch++ {
+			offset := (i*p.Channels + ch) * 2
+			channels[ch][i] = float64(int16(binary.LittleEndian.Uint16(p.Data[offset:]))) / int16FullScale
+		}
+	}
+	return channels
+}
+
+// MeasureLoudness computes the integrated loudness and true peak of p.
+// Mono clips are measured as dual mono, because the fish plays them on
+// both channels. Silence is reported at the absolute gate (-70 LUFS).
+func MeasureLoudness(p *PCM) Loudness {
+	channels := samples(p)
+	return Loudness{
+		Integrated: integratedLoudness(channels, p.SampleRate),
+		TruePeak:   truePeak(channels),
+	}
+}
+
+func integratedLoudness(channels [][]float64, sampleRate int) float64 {
+	if len(channels) == 0 || len(channels[0]) == 0 {
+		return absoluteGate
+	}
+	frames := len(channels[0])
+	blockSize := int(blockDuration * float64(sampleRate))
+	hop := blockSize / blockOverlap
+	if frames < blockSize {
+		// Too short for a single gating block; measure what is there.
+		blockSize = frames
+		hop = frames
+	}
+
+	// Sum of the mean squares over all channels, per gating block
+	numBlocks := (frames-blockSize)/hop + 1
+	blockPower := make([]float64, numBlocks)
+	channelWeight := 1.0
+	if len(channels) == 1 {
+		channelWeight = 2.0 // dual mono
+	}
+
+	for _, ch := range channels {
+		shelf, highPass := kWeighting(sampleRate)
+		// Squared K-weighted signal, summed per hop so that overlapping
+		// blocks can be assembled from their four quarters.
+		hopPower := make([]float64, frames/hop+1)
+		for i, x := range ch {
+			y := highPass.process(shelf.process(x))
+			hopPower[i/hop] += y * y
+		}
+		hopsPerBlock := blockSize / hop
+		for b := 0; b < numBlocks; b++ {
+			var sum float64
+			for h := 0; h < hopsPerBlock; h++ {
+				sum += hopPower[b+h]
+			}
+			blockPower[b] += channelWeight * sum / float64(hopsPerBlock*hop)
+		}
+	}
+
+	loudness := func(power float64) float64 {
+		return -0.691 + 10*math.Log10(power)
+	}
+	gatedMean := func(threshold float64) (float64, int) {
+		var sum float64
+		var n int
+		for _, power := range blockPower {
+			if power > 0 && loudness(power) > threshold {
+				sum += power
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, 0
+		}
+		return sum / float64(n), n
+	}
+
+	mean, n := gatedMean(absoluteGate)
+	if n == 0 {
+		return absoluteGate
+	}
+	mean, n = gatedMean(loudness(mean) + relativeGate)
+	if n == 0 {
+		return absoluteGate
+	}
+	return math.Max(absoluteGate, loudness(mean))
+}
+
+// interpolationFilter is a Hann-windowed sinc low pass for 4x oversampling,
+// laid out as oversampling phases of tapsPerPhase coefficients each.
+var interpolationFilter = func() [][]float64 {
+	length := oversampling * tapsPerPhase
+	center := float64(length-1) / 2
+	phases := make([][]float64, oversampling)
+	for p := range phases {
+		phases[p] = make([]float64, tapsPerPhase)
+	}
+	for i := 0; i < length; i++ {
+		t := (float64(i) - center) / oversampling
+		sinc := 1.0
+		if t != 0 {
+			sinc = math.Sin(math.Pi*t) / (math.Pi * t)
+		}
+		window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(length-1))
+		phases[i%oversampling][i/oversampling] = sinc * window
+	}
+	return phases
+}()
+
+func truePeak(channels [][]float64) float64 {
+	var peak float64
+	for _, ch := range channels {
+		for i := range ch {
+			// The sample itself is always a candidate
+			peak = math.Max(peak, math.Abs(ch[i]))
+			for _, taps := range interpolationFilter {
+				var y float64
+				for t, coeff := range taps {
+					idx := i - t + tapsPerPhase/2
+					if idx >= 0 && idx < len(ch) {
+						y += coeff * ch[idx]
+					}
+				}
+				peak = math.Max(peak, math.Abs(y))
+			}
+		}
+	}
+	if peak == 0 {
+		return silentPeak
+	}
+	return 20 * math.Log10(peak)
+}
+
+// ApplyGain scales 16-bit PCM data in place by gainDB, clipping at full scale.
+func ApplyGain(pcmData []byte, gainDB float64) {
+	if gainDB == 0 {
+		return
+	}
+	scaleSamples(pcmData, math.Pow(10, gainDB/20))
+}
+
+// scaleSamples multiplies 16-bit PCM data in place by factor, clipping at full scale.
+func scaleSamples(pcmData []byte, factor float64) {
+	for i := 0; i+1 < len(pcmData); i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcmData[i:]))) * factor
+		sample = math.Max(-32768, math.Min(32767, math.Round(sample)))
+		binary.LittleEndian.PutUint16(pcmData[i:], uint16(int16(sample)))
+	}
+}
@@ -0,0 +1,29 @@
+package audio
+
+import "encoding/binary"
+
+// Waveform returns the peak amplitude of n equally long sections of p,
+// scaled to 0-255, for drawing thumbnails. Clips shorter than n frames
+// yield one value per frame.
+func Waveform(p *PCM, n int) []byte {
+	frameSize := p.Channels * 2
+	frames := len(p.Data) / frameSize
+	if frames < n {
+		n = frames
+	}
+	peaks := make([]byte, n)
+	for bucket := range peaks {
+		from := bucket * frames / n
+		to := (bucket + 1) * frames / n
+		var peak int
+		for i := from * frameSize; i < to*frameSize; i += 2 {
+			sample := int(int16(binary.LittleEndian.Uint16(p.Data[i:])))
+			if sample < 0 {
+				sample = -sample
+			}
+			peak = max(peak, sample)
+		}
+		peaks[bucket] = byte(min(255, peak*255/32767))
+	}
+	return peaks
+}
@@ -0,0 +1,195 @@
+// Package audit keeps an append-only trail of who did what in the sounds
+// web UI and API, stored as one JSON object per line.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the trail.
+const (
+	ActionLogin       = "login"
+	ActionLoginFailed = "login_failed"
+	ActionLogout      = "logout"
+	ActionUpload      = "upload"
+	ActionImport      = "import"
+	ActionRename      = "rename"
+	ActionDelete      = "delete"
+	ActionRestore     = "restore"
+	ActionPurge       = "purge"
+	ActionEditClip    = "edit_clip"
+	ActionQueue       = "queue"
+	ActionSay         = "say"
+	ActionSchedule    = "schedule"
+	ActionUnschedule  = "unschedule"
+	ActionCreateUser  = "create_user"
+	ActionSetRole     = "set_role"
+	ActionSetPassword = "set_password"
+	ActionDeleteUser  = "delete_user"
+	ActionCreateToken = "create_token"
+	ActionRevokeToken = "revoke_token"
+	ActionSetVolume   = "set_volume"
+)
+
+// Actions lists all actions for filtering.
+var Actions = []string{
+	ActionLogin, ActionLoginFailed, ActionLogout,
+	ActionUpload, ActionImport, ActionRename, ActionDelete, ActionRestore, ActionPurge, ActionEditClip,
+	ActionQueue, ActionSay, ActionSchedule, ActionUnschedule,
+	ActionCreateUser, ActionSetRole, ActionSetPassword, ActionDeleteUser,
+	ActionCreateToken, ActionRevokeToken,
+	ActionSetVolume,
+}
+
+// Entry is one recorded action.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Action string    `json:"action"`
+	// Target is what the action was applied to: a sound, a text, a user.
+	Target string `json:"target,omitempty"`
+	// Detail holds further information, e.g. the new role or name.
+	Detail string `json:"detail,omitempty"`
+}
+
+var (
+	mu   sync.Mutex
+	path = "./sound-data/audit.jsonl"
+)
+
+// Init sets the directory the trail is stored in.
+func Init(dir string) {
+	mu.Lock()
+	defer mu.Unlock()
+	path = filepath.Join(dir, "audit.jsonl")
+}
+
+// Record appends entry to the trail, setting its time if it has none.
+func Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Only ever appended to, never rewritten
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Filter selects entries. Empty fields match everything.
+type Filter struct {
+	User   string
+	Action string
+	// Query matches entries with this text in their target or detail.
+	Query string
+	Since time.Time
+	Until time.Time
+}
+
+// Matches reports whether entry passes the filter.
+func (f Filter) Matches(entry Entry) bool {
+	if f.User != "" && !strings.EqualFold(entry.User, f.User) {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	if f.Query != "" {
+		query := strings.ToLower(f.Query)
+		if !strings.Contains(strings.ToLower(entry.Target), query) && !strings.Contains(strings.ToLower(entry.Detail), query) {
+			return false
+		}
+	}
+	return true
+}
+
+// each calls fn for every entry in the trail, oldest first. Lines that
+// cannot be parsed, e.g. one cut short by a crash, are skipped.
+func each(fn func(Entry)) error {
+	mu.Lock()
+	defer mu.Unlock()
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		fn(entry)
+	}
+	return scanner.Err()
+}
+
+// Search returns up to limit entries matching filter, newest first.
+func Search(filter Filter, limit int) ([]Entry, error) {
+	entries := []Entry{}
+	err := each(func(entry Entry) {
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Export writes the entries matching filter to w as JSON lines, oldest
+// first.
+func Export(w io.Writer, filter Filter) error {
+	enc := json.NewEncoder(w)
+	var writeErr error
+	err := each(func(entry Entry) {
+		if writeErr == nil && filter.Matches(entry) {
+			writeErr = enc.Encode(entry)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
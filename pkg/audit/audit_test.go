@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setup(t *testing.T) string {
+	dir := t.TempDir()
+	Init(dir)
+	return dir
+}
+
+func TestRecordAndSearch(t *testing.T) {
+	dir := setup(t)
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Time: start, User: "mia", IP: "10.0.0.2", Action: ActionLogin},
+		{Time: start.Add(time.Minute), User: "mia", IP: "10.0.0.2", Action: ActionSay, Target: "Lunch is ready"},
+		{Time: start.Add(2 * time.Minute), User: "tom", IP: "10.0.0.3", Action: ActionQueue, Target: "bell.wav"},
+		{Time: start.Add(3 * time.Minute), User: "tom", IP: "10.0.0.3", Action: ActionSay, Target: "Meeting in five"},
+	}
+	for _, entry := range entries {
+		if err := Record(entry); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "audit.jsonl")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a private trail, got %v", err)
+	}
+
+	all, err := Search(Filter{}, 0)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(all) != 4 || all[0].Target != "Meeting in five" {
+		t.Errorf("Expected all entries newest first, got %+v", all)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{"user", Filter{User: "MIA"}, 2},
+		{"action", Filter{Action: ActionSay}, 2},
+		{"query", Filter{Query: "lunch"}, 1},
+		{"since", Filter{Since: start.Add(2 * time.Minute)}, 2},
+		{"until", Filter{Until: start.Add(time.Minute)}, 1},
+		{"combined", Filter{User: "tom", Action: ActionSay}, 1},
+	}
+	for _, tt := range tests {
+		got, err := Search(tt.filter, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("%s: expected %d entries, got %d", tt.name, tt.want, len(got))
+		}
+	}
+
+	if got, _ := Search(Filter{}, 1); len(got) != 1 || got[0].User != "tom" {
+		t.Errorf("Expected the newest entry only, got %+v", got)
+	}
+}
+
+func TestRecordSetsTime(t *testing.T) {
+	setup(t)
+	before := time.Now()
+	Record(Entry{User: "mia", Action: ActionLogout})
+	got, _ := Search(Filter{}, 0)
+	if len(got) != 1 || got[0].Time.Before(before) {
+		t.Errorf("Expected the current time, got %+v", got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir := setup(t)
+	Record(Entry{User: "mia", Action: ActionUpload, Target: "bell.wav"})
+	// A line cut short by a crash is skipped
+	f, _ := os.OpenFile(filepath.Join(dir, "audit.jsonl"), os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"time":"2025-`)
+	f.WriteString("\n")
+	f.Close()
+	Record(Entry{User: "tom", Action: ActionDelete, Target: "bell.wav"})
+
+	var buf bytes.Buffer
+	if err := Export(&buf, Filter{Query: "bell"}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	var actions []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Expected JSON lines, got %q", scanner.Text())
+		}
+		actions = append(actions, entry.Action)
+	}
+	if len(actions) != 2 || actions[0] != ActionUpload || actions[1] != ActionDelete {
+		t.Errorf("Expected upload then delete, got %v", actions)
+	}
+}
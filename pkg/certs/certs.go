@@ -0,0 +1,192 @@
+// Package certs provides the TLS certificates of the sounds web UI: a
+// self-signed CA generated on first start, certificate files provided by
+// the operator, or certificates obtained via ACME.
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Mode selects where certificates come from.
+type Mode string
+
+const (
+	// ModeSelfSigned issues a certificate from a CA generated along with it
+	// and limited to the configured hosts. Browsers trust it once the CA
+	// certificate is installed, again whenever the certificate is renewed.
+	ModeSelfSigned Mode = "self-signed"
+	// ModeFiles serves the certificate and key files given in the Config.
+	ModeFiles Mode = "files"
+	// ModeACME obtains certificates from an ACME directory such as Let's
+	// Encrypt, answering HTTP-01 challenges on the plain HTTP listener.
+	ModeACME Mode = "acme"
+)
+
+// Config configures the certificates.
+type Config struct {
+	Mode Mode
+	// Dir stores the generated CA and certificates and the ACME account.
+	Dir string
+	// Hosts are the names and IP addresses the service is reached at.
+	// ACME only issues certificates for these names.
+	Hosts []string
+
+	// CertFile and KeyFile are PEM files for ModeFiles. They are reloaded
+	// when they change, e.g. after renewal by an external tool.
+	CertFile string
+	KeyFile  string
+
+	// ACMEDirectory is the directory URL, Let's Encrypt if empty.
+	ACMEDirectory string
+	// ACMEEmail is the contact address for the ACME account, optional.
+	ACMEEmail string
+}
+
+// Certs hands out the certificates for the HTTPS listener.
+type Certs struct {
+	config     *tls.Config
+	acme       *autocert.Manager
+	selfSigned *selfSigned
+}
+
+// New sets up the certificates for config. In ModeSelfSigned the CA and
+// a certificate for Hosts are created if they do not exist yet or no longer
+// cover Hosts.
+func New(config Config) (*Certs, error) {
+	c := &Certs{config: &tls.Config{MinVersion: tls.VersionTLS12}}
+	switch config.Mode {
+	case ModeSelfSigned:
+		issuer, err := newSelfSigned(filepath.Join(config.Dir, "self-signed"), config.Hosts)
+		if err != nil {
+			return nil, err
+		}
+		c.config.GetCertificate = issuer.getCertificate
+		c.selfSigned = issuer
+	case ModeFiles:
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, fmt.Errorf("certs: a certificate and key file are required")
+		}
+		files := &keyPair{certFile: config.CertFile, keyFile: config.KeyFile}
+		if _, err := files.getCertificate(nil); err != nil {
+			return nil, err
+		}
+		c.config.GetCertificate = files.getCertificate
+	case ModeACME:
+		if len(config.Hosts) == 0 {
+			return nil, fmt.Errorf("certs: ACME needs the host names to request certificates for")
+		}
+		c.acme = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(filepath.Join(config.Dir, "acme")),
+			HostPolicy: autocert.HostWhitelist(config.Hosts...),
+			Email:      config.ACMEEmail,
+		}
+		if config.ACMEDirectory != "" {
+			c.acme.Client = &acme.Client{DirectoryURL: config.ACMEDirectory}
+		}
+		c.config = c.acme.TLSConfig()
+		c.config.MinVersion = tls.VersionTLS12
+	default:
+		return nil, fmt.Errorf("certs: unknown mode %q", config.Mode)
+	}
+	return c, nil
+}
+
+// TLSConfig returns the configuration for the HTTPS server.
+func (c *Certs) TLSConfig() *tls.Config {
+	return c.config
+}
+
+// CACertificate returns the PEM encoded CA certificate users install to
+// trust self-signed certificates, or nil in other modes.
+func (c *Certs) CACertificate() []byte {
+	if c.selfSigned == nil {
+		return nil
+	}
+	return c.selfSigned.caCertificate()
+}
+
+// HTTPHandler returns the handler for the plain HTTP listener. It answers
+// ACME challenges and passes everything else to fallback.
+func (c *Certs) HTTPHandler(fallback http.Handler) http.Handler {
+	if c.acme == nil {
+		return fallback
+	}
+	return c.acme.HTTPHandler(fallback)
+}
+
+// RedirectHandler redirects requests to the same URL via HTTPS on port.
+func RedirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// keyPair serves a certificate from files, reloading them when they change.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// reloadInterval is how often the files are checked for changes.
+const reloadInterval = time.Minute
+
+func (k *keyPair) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	if k.cert != nil && now.Sub(k.checked) < reloadInterval {
+		return k.cert, nil
+	}
+	k.checked = now
+
+	modTime := latestModTime(k.certFile, k.keyFile)
+	if k.cert != nil && !modTime.After(k.modTime) {
+		return k.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		if k.cert != nil {
+			// Keep serving the old certificate while the files are replaced
+			return k.cert, nil
+		}
+		return nil, fmt.Errorf("certs: %w", err)
+	}
+	k.cert = &cert
+	k.modTime = modTime
+	return k.cert, nil
+}
+
+func latestModTime(files ...string) time.Time {
+	var latest time.Time
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
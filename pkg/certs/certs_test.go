@@ -0,0 +1,197 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// serverCert returns the certificate served to a client asking for host.
+func serverCert(t *testing.T, c *Certs, host string) *tls.Certificate {
+	cert, err := c.TLSConfig().GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	return cert
+}
+
+func verify(c *Certs, cert *tls.Certificate, host string) error {
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(c.CACertificate())
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+	return err
+}
+
+func TestSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{Mode: ModeSelfSigned, Dir: dir, Hosts: []string{"fish.office.lan", "192.168.1.20"}}
+	c, err := New(config)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	cert := serverCert(t, c, "fish.office.lan")
+	for _, host := range []string{"fish.office.lan", "192.168.1.20"} {
+		if err := verify(c, cert, host); err != nil {
+			t.Errorf("Expected certificate to be valid for %s: %v", host, err)
+		}
+	}
+	if verify(c, cert, "printer.office.lan") == nil {
+		t.Error("Expected certificate to be invalid for other hosts")
+	}
+	if info, err := os.Stat(filepath.Join(dir, "self-signed", "key.pem")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a private key, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "self-signed", "ca-key.pem")); !os.IsNotExist(err) {
+		t.Errorf("Expected the CA key not to be kept, got %v", err)
+	}
+
+	// The CA may only vouch for the configured hosts
+	block, _ := pem.Decode(c.CACertificate())
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.PermittedDNSDomainsCritical || !slices.Equal(ca.PermittedDNSDomains, []string{"fish.office.lan"}) ||
+		len(ca.PermittedIPRanges) != 1 || ca.PermittedIPRanges[0].String() != "192.168.1.20/32" {
+		t.Errorf("Expected name constraints for the hosts, got %v %v", ca.PermittedDNSDomains, ca.PermittedIPRanges)
+	}
+
+	// Restarts keep the CA and the certificate
+	again, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.CACertificate(), c.CACertificate()) {
+		t.Error("Expected the CA to be kept")
+	}
+	if !bytes.Equal(serverCert(t, again, "fish.office.lan").Certificate[0], cert.Certificate[0]) {
+		t.Error("Expected the certificate to be kept")
+	}
+
+	// New hosts need a new CA, as the old one cannot sign for them
+	config.Hosts = append(config.Hosts, "fish.example.com")
+	more, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(more.CACertificate(), c.CACertificate()) {
+		t.Error("Expected a new CA")
+	}
+	if err := verify(more, serverCert(t, more, "fish.example.com"), "fish.example.com"); err != nil {
+		t.Errorf("Expected certificate for the new host: %v", err)
+	}
+}
+
+func TestSelfSignedNoIPs(t *testing.T) {
+	c, err := New(Config{Mode: ModeSelfSigned, Dir: t.TempDir(), Hosts: []string{"fish.lan"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(c.CACertificate())
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ca.PermittedIPRanges) != 0 || len(ca.ExcludedIPRanges) != 2 {
+		t.Errorf("Expected all addresses to be excluded, got %v", ca.ExcludedIPRanges)
+	}
+}
+
+// issueFiles writes a certificate for host from a new self-signed CA to
+// dir and returns the CA.
+func issueFiles(t *testing.T, dir, host string) *Certs {
+	caDir := t.TempDir()
+	ca, err := New(Config{Mode: ModeSelfSigned, Dir: caDir, Hosts: []string{host}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cert.pem", "key.pem"} {
+		data, err := os.ReadFile(filepath.Join(caDir, "self-signed", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ca
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := issueFiles(t, dir, "one.lan")
+	c, err := New(Config{Mode: ModeFiles, CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := verify(first, serverCert(t, c, "one.lan"), "one.lan"); err != nil {
+		t.Errorf("Expected the certificate from the files: %v", err)
+	}
+
+	// Renewed files are picked up at the next check
+	files := &keyPair{certFile: filepath.Join(dir, "cert.pem"), keyFile: filepath.Join(dir, "key.pem")}
+	if _, err := files.getCertificate(nil); err != nil {
+		t.Fatal(err)
+	}
+	second := issueFiles(t, dir, "two.lan")
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "cert.pem"), future, future)
+	cert, _ := files.getCertificate(nil)
+	if verify(first, cert, "one.lan") != nil {
+		t.Error("Expected the old certificate until the next check")
+	}
+	files.checked = time.Time{}
+	cert, _ = files.getCertificate(nil)
+	if err := verify(second, cert, "two.lan"); err != nil {
+		t.Errorf("Expected the renewed certificate: %v", err)
+	}
+
+	// Broken files keep the last good certificate
+	os.WriteFile(filepath.Join(dir, "cert.pem"), []byte("garbage"), 0600)
+	os.Chtimes(filepath.Join(dir, "cert.pem"), future.Add(time.Hour), future.Add(time.Hour))
+	files.checked = time.Time{}
+	if cert, err := files.getCertificate(nil); err != nil || verify(second, cert, "two.lan") != nil {
+		t.Errorf("Expected the renewed certificate to be kept, got %v", err)
+	}
+}
+
+func TestFilesMissing(t *testing.T) {
+	if _, err := New(Config{Mode: ModeFiles, CertFile: "/nonexistent/cert.pem", KeyFile: "/nonexistent/key.pem"}); err == nil {
+		t.Error("Expected missing files to fail")
+	}
+	if _, err := New(Config{Mode: ModeACME}); err == nil {
+		t.Error("Expected ACME without hosts to fail")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port, host, path, want string
+	}{
+		{"443", "fish.lan", "/queue?x=1", "https://fish.lan/queue?x=1"},
+		{"443", "fish.lan:80", "/", "https://fish.lan/"},
+		{"8443", "fish.lan:8080", "/login", "https://fish.lan:8443/login"},
+		{"443", "[::1]:80", "/", "https://[::1]/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		RedirectHandler(tt.port).ServeHTTP(rec, req)
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s%s: expected redirect to %s, got %d %s", tt.host, tt.path, tt.want, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	certValidity = 365 * 24 * time.Hour
+	// renewBefore is how long before expiry a certificate is reissued.
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// selfSigned issues a certificate for hosts from a CA stored in dir. The
+// CA is limited to hosts by name constraints and its key is thrown away once
+// the certificate is signed, so the CA cannot vouch for anything else. A new
+// certificate therefore comes with a new CA, which has to be installed again.
+type selfSigned struct {
+	dir   string
+	hosts []string
+
+	mu     sync.Mutex
+	caPEM  []byte
+	cert   *tls.Certificate
+	expiry time.Time
+}
+
+func newSelfSigned(dir string, hosts []string) (*selfSigned, error) {
+	if len(hosts) == 0 {
+		hosts = defaultHosts()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("certs: %w", err)
+	}
+	s := &selfSigned{dir: dir, hosts: hosts}
+	if err := s.loadCert(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// defaultHosts are used if no hosts are configured.
+func defaultHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "localhost" {
+		hosts = append(hosts, name)
+	}
+	return hosts
+}
+
+// loadCert reads the CA and certificate from dir, issuing new ones if there
+// are none, the certificate expires soon or it does not cover the
+// configured hosts.
+func (s *selfSigned) loadCert() error {
+	caFile := filepath.Join(s.dir, "ca.pem")
+	certFile, keyFile := filepath.Join(s.dir, "cert.pem"), filepath.Join(s.dir, "key.pem")
+	ca, err := readCert(caFile)
+	var cert *x509.Certificate
+	var key *ecdsa.PrivateKey
+	if err == nil {
+		cert, key, err = readKeyPair(certFile, keyFile)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("certs: reading certificate: %w", err)
+	}
+	if err != nil || !s.valid(ca, cert, time.Now()) {
+		if ca, cert, key, err = s.issue(); err != nil {
+			return err
+		}
+		if err := writeKeyPair(certFile, keyFile, cert.Raw, key); err != nil {
+			return err
+		}
+		if err := writeCert(caFile, ca.Raw); err != nil {
+			return err
+		}
+	}
+	// Earlier versions kept the key of a reusable CA next to it
+	if err := os.Remove(filepath.Join(s.dir, "ca-key.pem")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("certs: %w", err)
+	}
+	s.caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	s.cert = &tls.Certificate{
+		Certificate: [][]byte{cert.Raw, ca.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+	s.expiry = cert.NotAfter
+	return nil
+}
+
+// valid reports whether cert was issued by ca for the configured hosts and
+// does not expire soon. CAs of earlier versions, which were not limited to
+// the hosts, are not valid.
+func (s *selfSigned) valid(ca, cert *x509.Certificate, now time.Time) bool {
+	if !ca.PermittedDNSDomainsCritical || cert.CheckSignatureFrom(ca) != nil || now.Add(renewBefore).After(cert.NotAfter) {
+		return false
+	}
+	names := slices.Clone(cert.DNSNames)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, host := range s.hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		if !slices.Contains(names, host) {
+			return false
+		}
+	}
+	return true
+}
+
+// issue creates a CA limited to the configured hosts and a certificate for
+// them signed by it. The CA key is not returned, so it is gone afterwards.
+func (s *selfSigned) issue() (ca, cert *x509.Certificate, key *ecdsa.PrivateKey, err error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("certs: %w", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{CommonName: "Sebaschtian the Fish CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	s.constrain(caTemplate)
+	der, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("certs: %w", err)
+	}
+	if ca, err = x509.ParseCertificate(der); err != nil {
+		return nil, nil, nil, fmt.Errorf("certs: %w", err)
+	}
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("certs: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: s.hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range s.hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err = x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("certs: %w", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	return ca, cert, key, err
+}
+
+// constrain limits the CA in template to the configured hosts. Names or
+// addresses of a kind without any configured host are excluded entirely.
+func (s *selfSigned) constrain(template *x509.Certificate) {
+	template.PermittedDNSDomainsCritical = true
+	for _, host := range s.hosts {
+		ip := net.ParseIP(host)
+		switch {
+		case ip == nil:
+			template.PermittedDNSDomains = append(template.PermittedDNSDomains, host)
+		case ip.To4() != nil:
+			template.PermittedIPRanges = append(template.PermittedIPRanges, &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)})
+		default:
+			template.PermittedIPRanges = append(template.PermittedIPRanges, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+		}
+	}
+	if len(template.PermittedDNSDomains) == 0 {
+		// The reserved top-level domain matches no real name
+		template.PermittedDNSDomains = []string{"invalid"}
+	}
+	if len(template.PermittedIPRanges) == 0 {
+		template.ExcludedIPRanges = []*net.IPNet{
+			{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+		}
+	}
+}
+
+// getCertificate returns the certificate, reissuing it when it is about
+// to expire on long running services.
+func (s *selfSigned) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().Add(renewBefore).After(s.expiry) {
+		if err := s.loadCert(); err != nil {
+			return nil, err
+		}
+	}
+	return s.cert, nil
+}
+
+func newSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+// caCertificate returns the PEM encoded CA certificate.
+func (s *selfSigned) caCertificate() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.caPEM
+}
+
+func readCert(certFile string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("invalid PEM in %s", certFile)
+	}
+	return x509.ParseCertificate(certBlock.Bytes)
+}
+
+func readKeyPair(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, err := readCert(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("invalid PEM in %s", keyFile)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func writeKeyPair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("certs: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("certs: %w", err)
+	}
+	return writeCert(certFile, der)
+}
+
+func writeCert(certFile string, der []byte) error {
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("certs: %w", err)
+	}
+	return nil
+}
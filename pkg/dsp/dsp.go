@@ -0,0 +1,159 @@
+// Package dsp applies audio effects to decoded PCM, so the fish can sound
+// more like a fish.
+package dsp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+)
+
+// maxLevelGain bounds the gain that restores the level after the effects,
+// in dB, so an effect that silences the audio does not amplify its noise.
+const maxLevelGain = 12.0
+
+// Effect changes audio. Effects return new PCM and leave p unchanged.
+type Effect interface {
+	Apply(p *audio.PCM) *audio.PCM
+}
+
+// effect describes an effect that can be selected by name.
+type effect struct {
+	// param is the default parameter and its valid range.
+	param, min, max float64
+	// description explains the parameter for error messages.
+	description string
+	build       func(param float64) Effect
+}
+
+var effects = map[string]effect{
+	"pitch":      {4, -12, 12, "semitones", func(p float64) Effect { return Pitch{Semitones: p} }},
+	"speed":      {1.25, 0.5, 2, "factor", func(p float64) Effect { return Speed{Factor: p} }},
+	"reverb":     {0.3, 0, 1, "wet mix", func(p float64) Effect { return Reverb{Mix: p} }},
+	"bitcrush":   {6, 2, 12, "bits", func(p float64) Effect { return Bitcrush{Bits: int(p)} }},
+	"underwater": {500, 100, 4000, "cutoff in Hz", func(p float64) Effect { return Underwater{Cutoff: p} }},
+	"radio":      {0, 0, 0, "", func(float64) Effect { return Radio{} }},
+}
+
+// Names returns the names of the effects, sorted.
+func Names() []string {
+	names := make([]string, 0, len(effects))
+	for name := range effects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain is a sequence of effects applied one after another.
+type Chain []Effect
+
+// Parse reads a comma separated list of effects such as
+// "underwater,pitch:4". Each effect takes an optional parameter after a
+// colon; without it the effect's default is used. An empty spec is an
+// empty chain.
+func Parse(spec string) (Chain, error) {
+	var chain Chain
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(strings.ToLower(part))
+		if part == "" {
+			continue
+		}
+		name, value, hasValue := strings.Cut(part, ":")
+		e, ok := effects[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown effect %q, want one of %s", name, strings.Join(Names(), ", "))
+		}
+		param := e.param
+		if hasValue {
+			if e.description == "" {
+				return nil, fmt.Errorf("effect %q takes no parameter", name)
+			}
+			var err error
+			param, err = strconv.ParseFloat(value, 64)
+			if err != nil || param < e.min || param > e.max {
+				return nil, fmt.Errorf("invalid %s %q for %s, want %g to %g", e.description, value, name, e.min, e.max)
+			}
+		}
+		chain = append(chain, e.build(param))
+	}
+	return chain, nil
+}
+
+// Apply runs the effects on p and restores its average level, within
+// maxLevelGain, so filtered audio still opens the fish's mouth like the
+// original does.
+func (c Chain) Apply(p *audio.PCM) *audio.PCM {
+	if len(c) == 0 || len(p.Data) == 0 {
+		return p
+	}
+	before := level(p.Data)
+	for _, e := range c {
+		p = e.Apply(p)
+	}
+	if after := level(p.Data); before > 0 && after > 0 {
+		gain := 20 * math.Log10(before/after)
+		audio.ApplyGain(p.Data, math.Min(gain, maxLevelGain))
+	}
+	return p
+}
+
+// level returns the average absolute sample value of 16-bit PCM data, the
+// measure the mouth animation uses.
+func level(pcmData []byte) float64 {
+	var sum float64
+	count := len(pcmData) / 2
+	if count == 0 {
+		return 0
+	}
+	for i := 0; i+1 < len(pcmData); i += 2 {
+		sum += math.Abs(float64(int16(binary.LittleEndian.Uint16(pcmData[i:]))))
+	}
+	return sum / float64(count)
+}
+
+// channels converts 16-bit PCM to floats in [-1, 1), one slice per channel.
+func channels(p *audio.PCM) [][]float64 {
+	frames := len(p.Data) / (p.Channels * 2)
+	out := make([][]float64, p.Channels)
+	for ch := range out {
+		out[ch] = make([]float64, frames)
+	}
+	for i := 0; i < frames; i++ {
+		for ch := 0; ch < p.Channels; ch++ {
+			offset := (i*p.Channels + ch) * 2
+			out[ch][i] = float64(int16(binary.LittleEndian.Uint16(p.Data[offset:]))) / 32768
+		}
+	}
+	return out
+}
+
+// fromChannels converts floats back to 16-bit PCM, clipping at full scale.
+func fromChannels(chans [][]float64, sampleRate int) *audio.PCM {
+	frames := 0
+	if len(chans) > 0 {
+		frames = len(chans[0])
+	}
+	data := make([]byte, frames*len(chans)*2)
+	for i := 0; i < frames; i++ {
+		for ch := range chans {
+			sample := math.Max(-32768, math.Min(32767, math.Round(chans[ch][i]*32768)))
+			binary.LittleEndian.PutUint16(data[(i*len(chans)+ch)*2:], uint16(int16(sample)))
+		}
+	}
+	return &audio.PCM{Data: data, SampleRate: sampleRate, Channels: len(chans)}
+}
+
+// mapChannels applies f to each channel of p.
+func mapChannels(p *audio.PCM, f func([]float64) []float64) *audio.PCM {
+	chans := channels(p)
+	for ch := range chans {
+		chans[ch] = f(chans[ch])
+	}
+	return fromChannels(chans, p.SampleRate)
+}
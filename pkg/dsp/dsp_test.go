@@ -0,0 +1,104 @@
+package dsp
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+)
+
+// sine returns a stereo sine wave of the given frequency at half scale.
+func sine(freq float64, length time.Duration) *audio.PCM {
+	const rate = 44100
+	frames := int(length.Seconds() * rate)
+	data := make([]byte, frames*4)
+	for i := 0; i < frames; i++ {
+		sample := int16(16384 * math.Sin(2*math.Pi*freq*float64(i)/rate))
+		binary.LittleEndian.PutUint16(data[i*4:], uint16(sample))
+		binary.LittleEndian.PutUint16(data[i*4+2:], uint16(sample))
+	}
+	return &audio.PCM{Data: data, SampleRate: rate, Channels: 2}
+}
+
+// zeroCrossings counts the sign changes of the left channel, twice the
+// frequency per second.
+func zeroCrossings(p *audio.PCM) int {
+	left := channels(p)[0]
+	count := 0
+	for i := 1; i < len(left); i++ {
+		if (left[i-1] < 0) != (left[i] < 0) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestParse(t *testing.T) {
+	chain, err := Parse(" Underwater, pitch:-3 ,radio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[0] != (Underwater{Cutoff: 500}) || chain[1] != (Pitch{Semitones: -3}) || chain[2] != (Radio{}) {
+		t.Errorf("Unexpected chain %#v", chain)
+	}
+	if chain, err := Parse(""); err != nil || len(chain) != 0 {
+		t.Errorf("Expected an empty chain, got %v, %v", chain, err)
+	}
+	for _, spec := range []string{"autotune", "pitch:24", "speed:fast", "radio:2"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected %q to be invalid", spec)
+		}
+	}
+}
+
+func TestEffectsKeepLength(t *testing.T) {
+	in := sine(440, time.Second)
+	for _, e := range []Effect{Pitch{Semitones: 4}, Bitcrush{Bits: 6}, Underwater{Cutoff: 500}, Radio{}} {
+		out := e.Apply(in)
+		if out.Duration() != in.Duration() || out.SampleRate != in.SampleRate || out.Channels != 2 {
+			t.Errorf("%T changed the format or length: %v", e, out.Duration())
+		}
+	}
+	if out := (Speed{Factor: 2}).Apply(in); out.Duration() != 500*time.Millisecond {
+		t.Errorf("Expected double speed to halve the length, got %v", out.Duration())
+	}
+	if out := (Reverb{Mix: 0.3}).Apply(in); out.Duration() != 1500*time.Millisecond {
+		t.Errorf("Expected the reverb to add its tail, got %v", out.Duration())
+	}
+}
+
+func TestPitch(t *testing.T) {
+	in := sine(440, time.Second)
+	out := Pitch{Semitones: 12}.Apply(in)
+	// An octave up doubles the frequency
+	if got, want := zeroCrossings(out), 2*zeroCrossings(in); math.Abs(float64(got-want)) > float64(want)/20 {
+		t.Errorf("Expected about %d zero crossings, got %d", want, got)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	low, high := sine(200, time.Second), sine(5000, time.Second)
+	if got := level(Underwater{Cutoff: 500}.Apply(high).Data); got > level(high.Data)/10 {
+		t.Errorf("Expected the low-pass to remove high frequencies, level %v", got)
+	}
+	if got := level(Underwater{Cutoff: 500}.Apply(low).Data); got < level(low.Data)/2 {
+		t.Errorf("Expected the low-pass to keep low frequencies, level %v", got)
+	}
+	if got := level(Radio{}.Apply(sine(50, time.Second)).Data); got > level(low.Data)/10 {
+		t.Errorf("Expected the radio to remove the bass, level %v", got)
+	}
+}
+
+func TestChainKeepsLevel(t *testing.T) {
+	in := sine(1000, time.Second)
+	chain, _ := Parse("radio,underwater:1500")
+	out := chain.Apply(in)
+	if before, after := level(in.Data), level(out.Data); math.Abs(after-before) > before/10 {
+		t.Errorf("Expected the level to be restored, %v before and %v after", before, after)
+	}
+	if level(in.Data) == 0 || &out.Data[0] == &in.Data[0] {
+		t.Error("Expected the input to be left unchanged")
+	}
+}
@@ -0,0 +1,227 @@
+package dsp
+
+import (
+	"math"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+)
+
+// Speed plays the audio faster or slower like a tape, which changes its
+// pitch as well.
+type Speed struct {
+	// Factor above 1 is faster and higher.
+	Factor float64
+}
+
+func (e Speed) Apply(p *audio.PCM) *audio.PCM {
+	if e.Factor <= 0 || e.Factor == 1 {
+		return p
+	}
+	return mapChannels(p, func(x []float64) []float64 { return resample(x, e.Factor) })
+}
+
+// Pitch shifts the pitch and keeps the length, by playing the audio at a
+// different speed and stretching it back in time.
+type Pitch struct {
+	Semitones float64
+}
+
+func (e Pitch) Apply(p *audio.PCM) *audio.PCM {
+	if e.Semitones == 0 {
+		return p
+	}
+	ratio := math.Pow(2, e.Semitones/12)
+	grain := p.SampleRate * 40 / 1000
+	return mapChannels(p, func(x []float64) []float64 {
+		return stretch(resample(x, ratio), ratio, grain, len(x))
+	})
+}
+
+// Reverb adds the echo of a small room.
+type Reverb struct {
+	// Mix is the share of the echo, from 0 to 1.
+	Mix float64
+}
+
+// reverbTail is the time added to the end for the echo to fade out.
+const reverbTail = 500 // milliseconds
+
+func (e Reverb) Apply(p *audio.PCM) *audio.PCM {
+	if e.Mix <= 0 {
+		return p
+	}
+	rate := float64(p.SampleRate)
+	return mapChannels(p, func(x []float64) []float64 {
+		x = append(x, make([]float64, p.SampleRate*reverbTail/1000)...)
+		// Schroeder reverb: parallel feedback combs into serial allpasses
+		wet := make([]float64, len(x))
+		for _, delay := range []float64{0.0297, 0.0371, 0.0411, 0.0437} {
+			d := int(delay * rate)
+			comb := make([]float64, len(x))
+			for i := range x {
+				comb[i] = x[i]
+				if i >= d {
+					comb[i] += 0.77 * comb[i-d]
+				}
+				wet[i] += comb[i] / 4
+			}
+		}
+		for _, delay := range []float64{0.005, 0.0017} {
+			d := int(delay * rate)
+			in := wet
+			wet = make([]float64, len(in))
+			for i := range in {
+				wet[i] = -0.7 * in[i]
+				if i >= d {
+					wet[i] += in[i-d] + 0.7*wet[i-d]
+				}
+			}
+		}
+		for i := range x {
+			x[i] = (1-e.Mix)*x[i] + e.Mix*wet[i]
+		}
+		return x
+	})
+}
+
+// Bitcrush reduces the resolution and sample rate for a lo-fi sound.
+type Bitcrush struct {
+	Bits int
+}
+
+// bitcrushHold is how many samples are held, dividing the sample rate.
+const bitcrushHold = 4
+
+func (e Bitcrush) Apply(p *audio.PCM) *audio.PCM {
+	if e.Bits <= 0 || e.Bits >= 16 {
+		return p
+	}
+	levels := math.Pow(2, float64(e.Bits-1))
+	return mapChannels(p, func(x []float64) []float64 {
+		var held float64
+		for i := range x {
+			if i%bitcrushHold == 0 {
+				held = math.Round(x[i]*levels) / levels
+			}
+			x[i] = held
+		}
+		return x
+	})
+}
+
+// Underwater muffles the audio with a low-pass filter.
+type Underwater struct {
+	// Cutoff is the frequency above which the audio fades, in Hz.
+	Cutoff float64
+}
+
+func (e Underwater) Apply(p *audio.PCM) *audio.PCM {
+	return mapChannels(p, func(x []float64) []float64 {
+		// Two stages for a steeper slope
+		return filter(filter(x, lowPass(e.Cutoff, p.SampleRate)), lowPass(e.Cutoff, p.SampleRate))
+	})
+}
+
+// Radio sounds like a small, overdriven speaker: only the telephone band
+// from 300 to 3400 Hz, slightly distorted.
+type Radio struct{}
+
+func (Radio) Apply(p *audio.PCM) *audio.PCM {
+	return mapChannels(p, func(x []float64) []float64 {
+		x = filter(filter(x, highPass(300, p.SampleRate)), lowPass(3400, p.SampleRate))
+		for i := range x {
+			x[i] = math.Tanh(2*x[i]) / 2
+		}
+		return x
+	})
+}
+
+// resample changes the length of x by 1/ratio with linear interpolation.
+func resample(x []float64, ratio float64) []float64 {
+	n := int(float64(len(x)) / ratio)
+	out := make([]float64, n)
+	for i := range out {
+		pos := float64(i) * ratio
+		j := int(pos)
+		if j+1 >= len(x) {
+			out[i] = x[len(x)-1]
+			continue
+		}
+		frac := pos - float64(j)
+		out[i] = x[j]*(1-frac) + x[j+1]*frac
+	}
+	return out
+}
+
+// stretch changes the length of x by factor, to length samples, without
+// changing its pitch, by overlapping Hann windowed grains.
+func stretch(x []float64, factor float64, grain, length int) []float64 {
+	out := make([]float64, length)
+	if len(x) == 0 || grain < 2 {
+		return out
+	}
+	window := make([]float64, grain)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(grain))
+	}
+	// Hann windows at half overlap add up to one
+	hop := grain / 2
+	for start := -hop; start < length; start += hop {
+		from := int(float64(start) / factor)
+		for i := 0; i < grain; i++ {
+			o, s := start+i, from+i
+			if o < 0 || o >= length || s < 0 || s >= len(x) {
+				continue
+			}
+			out[o] += x[s] * window[i]
+		}
+	}
+	return out
+}
+
+// biquad is a direct form I second order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+// lowPass returns a Butterworth low-pass filter, see the Audio EQ Cookbook.
+func lowPass(cutoff float64, sampleRate int) biquad {
+	w := 2 * math.Pi * math.Min(cutoff, float64(sampleRate)*0.45) / float64(sampleRate)
+	alpha := math.Sin(w) / (2 * math.Sqrt2 / 2)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	return biquad{
+		b0: (1 - cos) / 2 / a0,
+		b1: (1 - cos) / a0,
+		b2: (1 - cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+// highPass returns a Butterworth high-pass filter.
+func highPass(cutoff float64, sampleRate int) biquad {
+	w := 2 * math.Pi * cutoff / float64(sampleRate)
+	alpha := math.Sin(w) / (2 * math.Sqrt2 / 2)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	return biquad{
+		b0: (1 + cos) / 2 / a0,
+		b1: -(1 + cos) / a0,
+		b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+// filter runs x through f in place.
+func filter(x []float64, f biquad) []float64 {
+	var x1, x2, y1, y2 float64
+	for i, in := range x {
+		y := f.b0*in + f.b1*x1 + f.b2*x2 - f.a1*y1 - f.a2*y2
+		x2, x1 = x1, in
+		y2, y1 = y1, y
+		x[i] = y
+	}
+	return x
+}
@@ -0,0 +1,33 @@
+// Package filelock serializes changes to the state files in sound-data,
+// which the fish and the sounds service write from separate processes.
+package filelock
+
+import (
+	"os"
+	"syscall"
+)
+
+// Lock takes an exclusive advisory lock for the file at path, waiting until
+// whoever holds it releases it. The lock is kept on path+".lock", since the
+// file itself is replaced on every write. The returned function releases
+// the lock.
+func Lock(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		// Closing the file releases the lock
+		f.Close()
+	}, nil
+}
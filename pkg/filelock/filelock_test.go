@@ -0,0 +1,38 @@
+package filelock
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.json")
+
+	unlock, err := Lock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan struct{})
+	go func() {
+		unlockSecond, err := Lock(path)
+		if err != nil {
+			t.Error(err)
+		} else {
+			unlockSecond()
+		}
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("Expected the second lock to wait for the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the second lock once the first was released")
+	}
+}
@@ -0,0 +1,106 @@
+package fish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/go-mp3"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
+	"github.com/youpy/go-wav"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// applyClipSettings trims, fades and normalizes decoded clip audio with
+// the settings stored in the library. Clips without settings play as is.
+func applyClipSettings(ctx context.Context, filename string, pcmData []byte, sampleRate, channelCount int) []byte {
+	clip, err := library.GetClip(filename)
+	if err != nil {
+		slog.Error("Error reading clip settings", "filename", filename, "error", err)
+		return pcmData
+	}
+	if clip == nil {
+		return pcmData
+	}
+
+	pcm := clip.Edit.Apply(&audio.PCM{Data: pcmData, SampleRate: sampleRate, Channels: channelCount})
+	gain := clip.PlaybackGain()
+	audio.ApplyGain(pcm.Data, gain)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Float64("gain_db", gain),
+		attribute.Bool("edited", !clip.Edit.IsZero()),
+	)
+	return pcm.Data
+}
+
+// sourceOf returns the volume source the clip filename plays as.
+func sourceOf(filename string) volume.Source {
+	if clip, err := library.GetClip(filename); err == nil && clip != nil && clip.Category == library.CategoryAlert {
+		return volume.SourceAlerts
+	}
+	return volume.SourceSongs
+}
+
+// decodeSound reads the clip filename from the sound directory and returns
+// it with its clip settings applied as 16-bit stereo PCM at 44100Hz. Files
+// that are neither WAV nor MP3 decode to no audio.
+func decodeSound(ctx context.Context, filename string) ([]byte, error) {
+	filePath := filepath.Join(soundDir, filename)
+	fileData, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sound file '%s': %w", filePath, err)
+	}
+
+	var pcmData []byte
+	var sampleRate int
+	var channelCount int
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".wav":
+		wavReader := wav.NewReader(bytes.NewReader(fileData))
+		format, err := wavReader.Format()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get wav format from '%s': %w", filename, err)
+		}
+		wavReader = wav.NewReader(bytes.NewReader(fileData))
+		pcmData, err = io.ReadAll(wavReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode wav data from '%s': %w", filename, err)
+		}
+		sampleRate = int(format.SampleRate)
+		channelCount = int(format.NumChannels)
+
+	case ".mp3":
+		decoder, err := mp3.NewDecoder(bytes.NewReader(fileData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mp3 decoder for '%s': %w", filename, err)
+		}
+		pcmData, err = io.ReadAll(decoder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode mp3 data from '%s': %w", filename, err)
+		}
+		sampleRate = decoder.SampleRate()
+		channelCount = 2
+	}
+	if len(pcmData) == 0 {
+		return nil, nil
+	}
+
+	// Trim, fade and normalize as configured in the library
+	pcmData = applyClipSettings(ctx, filename, pcmData, sampleRate, channelCount)
+
+	// Convert audio to match oto context (44100Hz stereo)
+	if sampleRate != 44100 || channelCount != 2 {
+		pcmData = convertAudio(pcmData, sampleRate, channelCount, 44100, 2)
+	}
+	return pcmData, nil
+}
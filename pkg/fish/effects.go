@@ -0,0 +1,29 @@
+package fish
+
+import (
+	"context"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/dsp"
+)
+
+type effectsKey struct{}
+
+// WithEffects returns a context that plays sound files started with it
+// through chain.
+func WithEffects(ctx context.Context, chain dsp.Chain) context.Context {
+	return context.WithValue(ctx, effectsKey{}, chain)
+}
+
+func effectsFrom(ctx context.Context) dsp.Chain {
+	chain, _ := ctx.Value(effectsKey{}).(dsp.Chain)
+	return chain
+}
+
+// applyEffects runs 16-bit stereo PCM at 44100Hz through chain.
+func applyEffects(chain dsp.Chain, pcmData []byte) []byte {
+	if len(chain) == 0 {
+		return pcmData
+	}
+	return chain.Apply(&audio.PCM{Data: pcmData, SampleRate: 44100, Channels: 2}).Data
+}
@@ -3,25 +3,18 @@
 package fish
 
 import (
-	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
-	"io"
 	"log/slog"
-	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/ebitengine/oto/v3"
-	"github.com/hajimehoshi/go-mp3"
-	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
 	"github.com/warthog618/go-gpiocdev"
 	"github.com/warthog618/go-gpiocdev/device/rpi"
-	"github.com/youpy/go-wav"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
@@ -85,6 +78,9 @@ func (m *Motor) Stop() error {
 	return m.enable.SetValue(0)
 }
 
+// soundDir is where the sound clips are stored.
+const soundDir = "/sound-data"
+
 // Fish represents the fish with its controllable parts.
 type Fish struct {
 	mu        sync.Mutex
@@ -92,6 +88,7 @@ type Fish struct {
 	HeadMotor *Motor
 	BodyMotor *Motor
 	otoCtx    *oto.Context
+	mixer     *mixer
 }
 
 // NewFish initializes the GPIO pins and returns a new Fish object.
@@ -155,6 +152,7 @@ func NewFish(chipName string) (*Fish, error) {
 			in2:    in4Pin,
 		},
 		otoCtx: otoCtx,
+		mixer:  newMixer(otoCtx),
 	}
 
 	return fish, nil
@@ -182,78 +180,37 @@ func (fish *Fish) PlaySoundFile(ctx context.Context, filename string) error {
 	defer span.End()
 	span.SetAttributes(attribute.String("filename", filename))
 
-	soundDir := "/sound-data"
-	filePath := filepath.Join(soundDir, filename)
-
 	slog.Info("playing", "filename", filename)
 
 	// Add to played list
 	item := playlist.PlayedItem{
-		Name:      filename,
-		Type:      "song",
-		Timestamp: time.Now(),
+		Name:        filename,
+		Type:        "song",
+		Timestamp:   time.Now(),
+		RequestedBy: requesterFrom(ctx),
+		Source:      sourceFrom(ctx),
 	}
-	if err := playlist.AddPlayedItem(item, 1*time.Hour); err != nil {
+	if err := playlist.AddPlayedItem(item, playlist.HistoryRetention); err != nil {
 		slog.Error("Error adding played item", "error", err)
 		span.RecordError(err)
 		// Non-fatal error, continue
 	}
+	if err := library.IncrementPlayCount(filename); err != nil {
+		slog.Error("Error updating play count", "error", err)
+		// Non-fatal error, continue
+	}
 
-	fileData, err := os.ReadFile(filePath)
+	pcmData, err := decodeSound(ctx, filename)
 	if err != nil {
-		err = fmt.Errorf("failed to read sound file '%s': %w", filePath, err)
 		span.RecordError(err)
 		return err
 	}
-
-	var pcmData []byte
-	var sampleRate int
-	var channelCount int
-
-	switch strings.ToLower(filepath.Ext(filename)) {
-	case ".wav":
-		wavReader := wav.NewReader(bytes.NewReader(fileData))
-		format, err := wavReader.Format()
-		if err != nil {
-			err = fmt.Errorf("failed to get wav format from '%s': %w", filename, err)
-			span.RecordError(err)
-			return err
-		}
-		wavReader = wav.NewReader(bytes.NewReader(fileData))
-		pcmData, err = io.ReadAll(wavReader)
-		if err != nil {
-			err = fmt.Errorf("failed to decode wav data from '%s': %w", filename, err)
-			span.RecordError(err)
-			return err
-		}
-		sampleRate = int(format.SampleRate)
-		channelCount = int(format.NumChannels)
-
-	case ".mp3":
-		decoder, err := mp3.NewDecoder(bytes.NewReader(fileData))
-		if err != nil {
-			err = fmt.Errorf("failed to create mp3 decoder for '%s': %w", filename, err)
-			span.RecordError(err)
-			return err
-		}
-		pcmData, err = io.ReadAll(decoder)
-		if err != nil {
-			err = fmt.Errorf("failed to decode mp3 data from '%s': %w", filename, err)
-			span.RecordError(err)
-			return err
-		}
-		sampleRate = decoder.SampleRate()
-		channelCount = 2
-	}
+	pcmData = applyEffects(effectsFrom(ctx), pcmData)
 
 	if len(pcmData) > 0 {
-		// Convert audio to match oto context (44100Hz stereo)
-		if sampleRate != 44100 || channelCount != 2 {
-			pcmData = convertAudio(pcmData, sampleRate, channelCount, 44100, 2)
-			sampleRate = 44100
-			channelCount = 2
-		}
-		if err := fish.PlayAudioWithAnimation(ctx, pcmData, sampleRate, channelCount); err != nil {
+		stopNowPlaying := startNowPlaying(ctx, filename, "song", pcmDuration(len(pcmData), 44100, 2))
+		defer stopNowPlaying()
+		if err := fish.PlayAudioWithAnimation(ctx, pcmData, 44100, 2, sourceOf(filename)); err != nil {
 			err = fmt.Errorf("failed to play audio: %w", err)
 			span.RecordError(err)
 			return err
@@ -263,159 +220,6 @@ func (fish *Fish) PlaySoundFile(ctx context.Context, filename string) error {
 	return nil
 }
 
-// Say synthesizes text and plays it while animating the fish.
-// It returns an error if synthesis or playback fails.
-func (myFish *Fish) Say(ctx context.Context, piperClient *piper.PiperClient, text string) error {
-	ctx, span := otel.Tracer("fish").Start(ctx, "Say")
-	defer span.End()
-	span.SetAttributes(attribute.String("text_length", fmt.Sprintf("%d", len(text))))
-
-	if text == "" {
-		slog.Info("nothing to say.")
-		return nil
-	}
-	slog.Info("saying", "text", text)
-	wavData, err := piperClient.Synthesize(text)
-	if err != nil {
-		err = fmt.Errorf("failed to synthesize text: %w", err)
-		span.RecordError(err)
-		return err
-	}
-
-	wavReader := wav.NewReader(bytes.NewReader(wavData))
-	pcmData, err := io.ReadAll(wavReader)
-	if err != nil {
-		err = fmt.Errorf("failed to read pcm data: %w", err)
-		span.RecordError(err)
-		return err
-	}
-
-	// Convert mono to stereo and resample from 22050Hz to 44100Hz
-	pcmData = convertAudio(pcmData, 22050, 1, 44100, 2)
-	if err := myFish.PlayAudioWithAnimation(ctx, pcmData, 44100, 2); err != nil {
-		err = fmt.Errorf("failed to play audio: %w", err)
-		span.RecordError(err)
-		return err
-	}
-	slog.Info("finished saying", "text", text)
-	return nil
-}
-
-// PlayAudioWithAnimation plays audio and animates the mouth.
-// It implements a timeout to prevent hanging if the audio player gets stuck.
-func (fish *Fish) PlayAudioWithAnimation(ctx context.Context, pcmData []byte, sampleRate, channelCount int) error {
-	_, span := otel.Tracer("fish").Start(ctx, "PlayAudioWithAnimation")
-	defer span.End()
-
-	bitDepthInBytes := 2 // 16-bit audio
-
-	// Calculate approximate duration for timeout
-	numSamples := len(pcmData) / (channelCount * bitDepthInBytes)
-	duration := time.Duration(float64(numSamples) / float64(sampleRate) * float64(time.Second))
-
-	player := fish.otoCtx.NewPlayer(bytes.NewReader(pcmData))
-	defer player.Close()
-	player.Play()
-
-	done := make(chan struct{})
-
-	go func() {
-		defer close(done)
-		const chunkDuration = 100 * time.Millisecond
-		const amplitudeThreshold = 1500
-		isMouthOpen := false
-		chunkSize := int(float64(sampleRate) * chunkDuration.Seconds() * float64(bitDepthInBytes*channelCount))
-		buffer := make([]byte, chunkSize)
-		analysisReader := bytes.NewReader(pcmData)
-
-		for {
-			// Check if we should stop (if player closed or timeout occurred outside)
-			// But since we can't easily check 'done' from outside here, we rely on read finishing.
-			// However, if we need to abort, we rely on the loop finishing when analysisReader is done.
-
-			n, err := io.ReadFull(analysisReader, buffer)
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				break
-			}
-			if err != nil {
-				slog.Error("Error reading audio for animation", "error", err)
-				break
-			}
-
-			var sum int64
-			var count int
-			for i := 0; i < n; i += bitDepthInBytes * channelCount {
-				if i+(bitDepthInBytes*channelCount) > n {
-					break
-				}
-				sample := int16(binary.LittleEndian.Uint16(buffer[i : i+2]))
-				if sample < 0 {
-					sum += int64(-sample)
-				} else {
-					sum += int64(sample)
-				}
-				count++
-			}
-
-			if count == 0 {
-				time.Sleep(chunkDuration)
-				continue
-			}
-			avgAmplitude := sum / int64(count)
-
-			fish.Lock()
-			if avgAmplitude > amplitudeThreshold && !isMouthOpen {
-				fish.OpenMouth()
-				isMouthOpen = true
-			} else if avgAmplitude <= amplitudeThreshold && isMouthOpen {
-				fish.CloseMouth()
-				isMouthOpen = false
-			}
-			fish.Unlock()
-			time.Sleep(chunkDuration)
-		}
-
-		fish.Lock()
-		if isMouthOpen {
-			fish.CloseMouth()
-			time.Sleep(1 * time.Second)
-			fish.StopMouth()
-		}
-
-		fish.StopBody()
-		fish.StopMouth()
-
-		fish.Unlock()
-	}()
-
-	// Safety timeout: Expected duration + 5 seconds margin
-	timeout := duration + 5*time.Second
-	timer := time.NewTimer(timeout)
-	defer timer.Stop()
-
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-
-	for player.IsPlaying() {
-		select {
-		case <-timer.C:
-			return fmt.Errorf("playback timed out after %v", timeout)
-		case <-ticker.C:
-			// continue polling
-		}
-	}
-
-	// Wait for animation to finish (it shouldn't take long after playback finishes)
-	// We use a small timeout here too just in case
-	select {
-	case <-done:
-		return nil
-	case <-time.After(2 * time.Second):
-		slog.Warn("Animation goroutine took too long to finish")
-		return nil
-	}
-}
-
 // convertAudio converts audio from one format to another (sample rate and channel count)
 func convertAudio(pcmData []byte, fromRate, fromChannels, toRate, toChannels int) []byte {
 	// Convert bytes to int16 samples
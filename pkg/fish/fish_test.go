@@ -2,12 +2,12 @@ package fish
 
 import (
 	"context"
-	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper/pipertest"
 )
 
 func TestFishLifecycle(t *testing.T) {
@@ -64,17 +64,18 @@ func TestFishLifecycle(t *testing.T) {
 
 	// 4. Test Say (with mocked Piper)
 	t.Run("Say", func(t *testing.T) {
-		// Mock Piper server returning our valid WAV
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Write(wavHeader)
-		}))
+		fake := pipertest.NewServer()
+		ts := httptest.NewServer(fake)
 		defer ts.Close()
 
 		pClient := piper.NewPiperClient(ts.URL)
-		err := f.Say(ctx, pClient, "Hello Fish")
+		err := f.Say(ctx, pClient, "Hello Fish", piper.Voice{})
 		if err != nil {
 			t.Errorf("Say failed: %v", err)
 		}
+		if requests := fake.Requests(); len(requests) != 1 || requests[0].Text != "Hello Fish" {
+			t.Errorf("Expected the text to be synthesized, got %+v", requests)
+		}
 	})
 
 	// 5. Test Motors (Mock implementation on Darwin)
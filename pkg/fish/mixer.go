@@ -0,0 +1,207 @@
+package fish
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ebitengine/oto/v3"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
+)
+
+// trackKind decides how a track plays along with the others.
+type trackKind int
+
+const (
+	// trackVoice is what the fish says or sings. It moves the mouth and
+	// ducks the beds while it is loud.
+	trackVoice trackKind = iota
+	// trackBed is background audio, such as an ambient loop under speech.
+	trackBed
+	// trackAlert interrupts all other tracks, which resume once it has
+	// played.
+	trackAlert
+)
+
+// duckHold is how long beds stay ducked after the voice was last loud, so
+// they do not swell up between words.
+const duckHold = 800 * time.Millisecond
+
+// bedFade is how long a bed takes to fade out when it stops.
+const bedFade = 300 * time.Millisecond
+
+// player is the part of an oto player the mixer uses.
+type player interface {
+	Play()
+	Pause()
+	IsPlaying() bool
+	SetVolume(volume float64)
+	BufferedSize() int
+	Close() error
+}
+
+// mixer plays several tracks at once. Each track has its own player, and
+// the audio context sums them. The mixer pauses tracks during alerts, ducks
+// beds under the voice and applies the volume settings.
+type mixer struct {
+	newPlayer func(io.Reader) player
+
+	mu     sync.Mutex
+	tracks []*track
+	// spoke is when a voice track was last loud.
+	spoke time.Time
+	// settings are reread at most once a second.
+	settings   volume.Settings
+	settingsAt time.Time
+}
+
+// track is a stream played by the mixer.
+type track struct {
+	kind   trackKind
+	stream *pcmStream
+	player player
+	// started is set once the player has data to play.
+	started bool
+	paused  bool
+	// fading tracks are left at the volume they are faded to.
+	fading bool
+}
+
+func newMixer(otoCtx *oto.Context) *mixer {
+	return &mixer{newPlayer: func(r io.Reader) player { return otoCtx.NewPlayer(r) }}
+}
+
+// add adds a track of kind. It starts playing once data is written to its
+// stream and update is called.
+func (m *mixer) add(kind trackKind) *track {
+	stream := newPCMStream()
+	t := &track{kind: kind, stream: stream, player: m.newPlayer(stream)}
+	m.mu.Lock()
+	m.tracks = append(m.tracks, t)
+	m.mu.Unlock()
+	return t
+}
+
+// remove stops t and resumes the tracks it interrupted.
+func (m *mixer) remove(t *track) {
+	m.mu.Lock()
+	for i, other := range m.tracks {
+		if other == t {
+			m.tracks = append(m.tracks[:i], m.tracks[i+1:]...)
+			break
+		}
+	}
+	m.mu.Unlock()
+	t.player.Close()
+	m.update()
+}
+
+// fadeOut lowers the volume of t to silence over bedFade and removes it.
+func (m *mixer) fadeOut(t *track) {
+	m.mu.Lock()
+	t.fading = true
+	amplitude := m.amplitude(t, time.Now())
+	m.mu.Unlock()
+	const steps = 10
+	for i := steps - 1; i >= 0; i-- {
+		t.player.SetVolume(amplitude * float64(i) / steps)
+		time.Sleep(bedFade / steps)
+	}
+	m.remove(t)
+}
+
+// speak notes that a voice track is loud, which ducks the beds.
+func (m *mixer) speak() {
+	m.mu.Lock()
+	m.spoke = time.Now()
+	m.mu.Unlock()
+	m.update()
+}
+
+// gain returns the factor samples of source are scaled by when written.
+func (m *mixer) gain(source volume.Source) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refreshSettings(time.Now())
+	return m.settings.GainFactor(source)
+}
+
+// update starts tracks that have data, pauses or resumes them around
+// alerts and sets their volumes. It is called whenever something changes
+// and regularly while playing, as volume curves and ducking change with
+// time.
+func (m *mixer) update() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.refreshSettings(now)
+
+	alert := false
+	for _, t := range m.tracks {
+		alert = alert || t.kind == trackAlert
+	}
+	for _, t := range m.tracks {
+		if !t.fading {
+			t.player.SetVolume(m.amplitude(t, now))
+		}
+		pause := alert && t.kind != trackAlert
+		switch {
+		case !t.started:
+			// Playing without data would stall the player
+			if pause || t.stream.pending() == 0 {
+				continue
+			}
+			t.started = true
+			t.player.Play()
+		case pause && !t.paused:
+			t.player.Pause()
+		case !pause && t.paused:
+			t.player.Play()
+		}
+		t.paused = pause
+	}
+}
+
+// playing reports whether t has anything left to play, including while it
+// is paused.
+func (m *mixer) playing(t *track) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !t.started {
+		return t.stream.pending() > 0
+	}
+	return t.paused || t.player.IsPlaying()
+}
+
+// interrupted reports whether t is paused for an alert.
+func (m *mixer) interrupted(t *track) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return t.paused || !t.started && t.stream.pending() > 0
+}
+
+// position returns how many bytes of t have been played, as far as the
+// player is concerned.
+func (m *mixer) position(t *track) int {
+	return max(t.stream.consumed()-t.player.BufferedSize(), 0)
+}
+
+// amplitude returns the volume of t at now. The caller holds m.mu.
+func (m *mixer) amplitude(t *track, now time.Time) float64 {
+	amplitude := m.settings.Amplitude(now)
+	if t.kind == trackBed && now.Sub(m.spoke) < duckHold {
+		amplitude *= math.Pow(10, -m.settings.Duck/20)
+	}
+	return amplitude
+}
+
+// refreshSettings rereads the volume settings if they are more than a
+// second old. The caller holds m.mu.
+func (m *mixer) refreshSettings(now time.Time) {
+	if now.Sub(m.settingsAt) < time.Second {
+		return
+	}
+	m.settings = currentVolume()
+	m.settingsAt = now
+}
@@ -0,0 +1,91 @@
+package fish
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
+)
+
+// fakePlayer records what the mixer does with a player.
+type fakePlayer struct {
+	playing bool
+	volume  float64
+	closed  bool
+}
+
+func (p *fakePlayer) Play()               { p.playing = true }
+func (p *fakePlayer) Pause()              { p.playing = false }
+func (p *fakePlayer) IsPlaying() bool     { return p.playing }
+func (p *fakePlayer) SetVolume(v float64) { p.volume = v }
+func (p *fakePlayer) BufferedSize() int   { return 0 }
+func (p *fakePlayer) Close() error        { p.closed = true; return nil }
+
+func newFakeMixer() *mixer {
+	return &mixer{newPlayer: func(io.Reader) player { return &fakePlayer{} }}
+}
+
+func fake(t *track) *fakePlayer {
+	return t.player.(*fakePlayer)
+}
+
+func TestMixerAlertInterrupts(t *testing.T) {
+	volume.Init(t.TempDir(), time.UTC)
+	m := newFakeMixer()
+
+	song := m.add(trackVoice)
+	m.update()
+	if fake(song).playing || m.playing(song) {
+		t.Fatal("Expected a track without data to wait")
+	}
+	song.stream.Write(make([]byte, 4))
+	m.update()
+	if !fake(song).playing {
+		t.Fatal("Expected the track to start with data")
+	}
+
+	alert := m.add(trackAlert)
+	alert.stream.Write(make([]byte, 4))
+	m.update()
+	if fake(song).playing || !m.interrupted(song) || !m.playing(song) {
+		t.Error("Expected the alert to pause the song")
+	}
+	if !fake(alert).playing {
+		t.Error("Expected the alert to play")
+	}
+
+	m.remove(alert)
+	if !fake(alert).closed || !fake(song).playing || m.interrupted(song) {
+		t.Error("Expected the song to resume after the alert")
+	}
+}
+
+func TestMixerDucksBeds(t *testing.T) {
+	volume.Init(t.TempDir(), time.UTC)
+	m := newFakeMixer()
+
+	bed := m.add(trackBed)
+	voice := m.add(trackVoice)
+	m.update()
+	if fake(bed).volume != 1 || fake(voice).volume != 1 {
+		t.Fatalf("Expected full volume, got %v and %v", fake(bed).volume, fake(voice).volume)
+	}
+
+	m.speak()
+	// The default settings duck by 12 dB, about a quarter
+	if got := fake(bed).volume; got < 0.24 || got > 0.26 {
+		t.Errorf("Expected the bed to be ducked, got %v", got)
+	}
+	if fake(voice).volume != 1 {
+		t.Errorf("Expected the voice to stay at full volume, got %v", fake(voice).volume)
+	}
+
+	m.mu.Lock()
+	m.spoke = time.Now().Add(-duckHold)
+	m.mu.Unlock()
+	m.update()
+	if fake(bed).volume != 1 {
+		t.Errorf("Expected the bed to come back up, got %v", fake(bed).volume)
+	}
+}
@@ -0,0 +1,101 @@
+package fish
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+)
+
+// nowPlayingUpdateInterval is how often the playback position is published.
+const nowPlayingUpdateInterval = 5 * time.Second
+
+type sourceKey struct{}
+
+// WithSource returns a context that attributes playback started with it to
+// source (one of the playlist.Source* constants) in the now-playing record.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+func sourceFrom(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
+
+type requesterKey struct{}
+
+// WithRequester returns a context that attributes playback started with it
+// to the user who queued it, in the now-playing record and the history.
+func WithRequester(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, requesterKey{}, user)
+}
+
+func requesterFrom(ctx context.Context) string {
+	user, _ := ctx.Value(requesterKey{}).(string)
+	return user
+}
+
+// pcmDuration returns the playback length of 16-bit PCM data.
+func pcmDuration(size, sampleRate, channelCount int) time.Duration {
+	numSamples := size / (channelCount * 2)
+	return time.Duration(float64(numSamples) / float64(sampleRate) * float64(time.Second))
+}
+
+// startNowPlaying publishes that the fish started playing name and keeps the
+// playback position up to date. It returns a function that clears the record
+// again once playback has finished, or restores the record of what name
+// interrupted, such as a song paused for an alert.
+func startNowPlaying(ctx context.Context, name, itemType string, duration time.Duration) func() {
+	previous, err := playlist.GetNowPlaying()
+	if err != nil {
+		slog.Error("Error reading now playing", "error", err)
+	}
+	item := playlist.NowPlaying{
+		Name:        name,
+		Type:        itemType,
+		Source:      sourceFrom(ctx),
+		RequestedBy: requesterFrom(ctx),
+		StartedAt:   time.Now(),
+		Duration:    duration,
+	}
+	if err := playlist.SetNowPlaying(item); err != nil {
+		slog.Error("Error updating now playing", "error", err)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(nowPlayingUpdateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := playlist.UpdateNowPlayingPosition(name, time.Since(item.StartedAt)); err != nil {
+					slog.Error("Error updating playback position", "error", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		// A record left over from before a restart has run out. The
+		// interrupted item was paused meanwhile
+		if previous != nil && previous.Remaining(item.StartedAt) > 0 {
+			previous.StartedAt = previous.StartedAt.Add(time.Since(item.StartedAt))
+			if err := playlist.SetNowPlaying(*previous); err != nil {
+				slog.Error("Error restoring now playing", "error", err)
+			}
+			return
+		}
+		if err := playlist.ClearNowPlaying(); err != nil {
+			slog.Error("Error clearing now playing", "error", err)
+		}
+	}
+}
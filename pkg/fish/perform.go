@@ -0,0 +1,256 @@
+package fish
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/dsp"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/library"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/tts"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Move is a motor move within a performance.
+type Move string
+
+const (
+	// MoveBody raises the body until MoveStop or the end.
+	MoveBody Move = "body"
+	// MoveTail flaps the tail once.
+	MoveTail Move = "tail"
+	// MoveStop lowers the body and tail.
+	MoveStop Move = "stop"
+)
+
+// Moves lists the valid moves.
+var Moves = []Move{MoveBody, MoveTail, MoveStop}
+
+// tailFlap is how long MoveTail raises the tail.
+const tailFlap = time.Second
+
+// speechRate is the characters per second used to estimate how long a
+// text takes to say, about the pace of the default voice.
+const speechRate = 14
+
+// Step is one part of a performance. Exactly one of Text, Sound, Pause,
+// Move, Bed and StopBed is set.
+type Step struct {
+	// Text is said in Voice, sentence by sentence.
+	Text  string
+	Voice piper.Voice
+	// Sound is a clip from the library, played without moving the mouth.
+	Sound string
+	// Pause is a silence.
+	Pause time.Duration
+	// Move is made when the performance reaches it.
+	Move Move
+	// Bed is a clip from the library looped under the following steps,
+	// ducked while the fish speaks, until the next Bed or StopBed step or
+	// the end of the performance.
+	Bed string
+	// StopBed fades out the bed.
+	StopBed bool
+	// Effects are applied to the text, sound or bed.
+	Effects dsp.Chain
+}
+
+// Perform plays steps as one stream: speech is synthesized ahead of
+// playback, pauses and sounds are mixed in, moves are made on time and
+// beds play along on a track of their own.
+// title is shown as now playing. Sounds that cannot be played are left
+// out; a text that cannot be synthesized cuts the performance short.
+func (myFish *Fish) Perform(ctx context.Context, synth tts.Synthesizer, title string, steps []Step) error {
+	ctx, span := otel.Tracer("fish").Start(ctx, "Perform")
+	defer span.End()
+	span.SetAttributes(attribute.Int("steps", len(steps)), attribute.String("tts.engine", synth.Name()))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// At most one bed plays at a time. Cues switch it as playback reaches
+	// the bed steps
+	var bedMu sync.Mutex
+	stopBed := func() {}
+	switchBed := func(pcmData []byte, source volume.Source) {
+		bedMu.Lock()
+		defer bedMu.Unlock()
+		stopBed()
+		bedCtx, cancelBed := context.WithCancel(ctx)
+		stopBed = cancelBed
+		if len(pcmData) > 0 {
+			go myFish.playBed(bedCtx, pcmData, source)
+		}
+	}
+	defer switchBed(nil, "")
+
+	segments := make(chan segment, sentencesAhead-1)
+	synthErr := make(chan error, 1)
+	go func() {
+		defer close(segments)
+		send := func(seg segment) bool {
+			select {
+			case segments <- seg:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, step := range steps {
+			var seg segment
+			switch {
+			case step.Text != "":
+				for _, sentence := range tts.Sentences(step.Text) {
+					pcmData, err := synthesizePCM(ctx, synth, sentence, step.Voice)
+					if err != nil {
+						synthErr <- err
+						return
+					}
+					if !send(segment{pcm: applyEffects(step.Effects, pcmData), mouth: true, source: volume.SourceSpeech}) {
+						return
+					}
+				}
+				continue
+			case step.Sound != "":
+				pcmData, err := decodeSound(ctx, step.Sound)
+				if err != nil {
+					slog.Error("Leaving out sound from performance", "sound", step.Sound, "error", err)
+					continue
+				}
+				seg = segment{pcm: applyEffects(step.Effects, pcmData), source: sourceOf(step.Sound)}
+			case step.Pause > 0:
+				seg = segment{pcm: silence(step.Pause, 44100, 2)}
+			case step.Move != "":
+				seg = segment{move: step.Move}
+			case step.Bed != "":
+				pcmData, err := decodeSound(ctx, step.Bed)
+				if err != nil {
+					slog.Error("Leaving out bed from performance", "bed", step.Bed, "error", err)
+					continue
+				}
+				pcmData, source := applyEffects(step.Effects, pcmData), sourceOf(step.Bed)
+				seg = segment{cue: func() { switchBed(pcmData, source) }}
+			case step.StopBed:
+				seg = segment{cue: func() { switchBed(nil, "") }}
+			default:
+				continue
+			}
+			if !send(seg) {
+				return
+			}
+		}
+	}()
+
+	first, ok := <-segments
+	if !ok {
+		err := ctx.Err()
+		select {
+		case err = <-synthErr:
+		default:
+			if err == nil {
+				slog.Info("nothing to say.")
+			}
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+		return err
+	}
+	stopNowPlaying := startNowPlaying(ctx, title, "text", estimateDuration(steps))
+	defer stopNowPlaying()
+
+	stream := make(chan segment)
+	go func() {
+		defer close(stream)
+		stream <- first
+		for seg := range segments {
+			stream <- seg
+		}
+	}()
+	if err := myFish.playSegments(ctx, stream, trackVoice, 44100, 2); err != nil {
+		err = fmt.Errorf("failed to play audio: %w", err)
+		span.RecordError(err)
+		return err
+	}
+
+	// A sentence that failed to synthesize cuts the performance short
+	select {
+	case err := <-synthErr:
+		span.RecordError(err)
+		return err
+	default:
+	}
+	return nil
+}
+
+// makeMove moves the motors, holding the fish's lock only while switching
+// them.
+func (fish *Fish) makeMove(move Move) {
+	fish.Lock()
+	defer fish.Unlock()
+	switch move {
+	case MoveBody:
+		fish.RaiseBody()
+	case MoveTail:
+		fish.RaiseTail()
+		fish.Unlock()
+		time.Sleep(tailFlap)
+		fish.Lock()
+		fish.StopBody()
+	case MoveStop:
+		fish.StopBody()
+	}
+}
+
+// playBed loops pcmData on a bed track until ctx is done, then fades it
+// out.
+func (fish *Fish) playBed(ctx context.Context, pcmData []byte, source volume.Source) {
+	t := fish.mixer.add(trackBed)
+	pcmData = applyGain(pcmData, fish.mixer.gain(source))
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		// Stay a loop ahead of the player
+		if t.stream.pending() < len(pcmData) {
+			t.stream.Write(pcmData)
+		}
+		fish.mixer.update()
+		select {
+		case <-ctx.Done():
+			fish.mixer.fadeOut(t)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// silence returns d of silent 16-bit PCM.
+func silence(d time.Duration, sampleRate, channelCount int) []byte {
+	frames := int(d.Seconds() * float64(sampleRate))
+	return make([]byte, frames*channelCount*2)
+}
+
+// estimateDuration guesses how long steps take to play, for the
+// now-playing record, before the speech has been synthesized.
+func estimateDuration(steps []Step) time.Duration {
+	var duration time.Duration
+	for _, step := range steps {
+		switch {
+		case step.Text != "":
+			duration += time.Duration(utf8.RuneCountInString(step.Text)) * time.Second / speechRate
+		case step.Sound != "":
+			if clip, err := library.GetClip(step.Sound); err == nil && clip != nil {
+				duration += clip.PlaybackDuration()
+			}
+		case step.Pause > 0:
+			duration += step.Pause
+		}
+	}
+	return duration
+}
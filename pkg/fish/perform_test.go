@@ -0,0 +1,29 @@
+package fish
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSilence(t *testing.T) {
+	pcm := silence(500*time.Millisecond, 44100, 2)
+	if got := pcmDuration(len(pcm), 44100, 2); got != 500*time.Millisecond {
+		t.Errorf("Expected 500ms of silence, got %v", got)
+	}
+	for _, b := range pcm {
+		if b != 0 {
+			t.Fatal("Expected silence to be zero")
+		}
+	}
+}
+
+func TestEstimateDuration(t *testing.T) {
+	steps := []Step{
+		{Text: "Hallo, Fische!"},
+		{Pause: 800 * time.Millisecond},
+		{Move: MoveTail},
+	}
+	if got := estimateDuration(steps); got != 1800*time.Millisecond {
+		t.Errorf("Expected a second of speech and the pause, got %v", got)
+	}
+}
@@ -0,0 +1,358 @@
+package fish
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/volume"
+	"go.opentelemetry.io/otel"
+)
+
+// streamWait is how long a read of a pcmStream waits for more data.
+const streamWait = 10 * time.Millisecond
+
+// pcmStream is the source of a player that is fed while it plays. While
+// the next chunk is not ready, reads return no data after a short wait
+// rather than block, so the player outputs silence instead of stalling
+// the audio context, and does not spin either.
+type pcmStream struct {
+	mu     sync.Mutex
+	data   []byte
+	closed bool
+	// read counts the bytes read so far.
+	read int
+	// ready wakes up a waiting read.
+	ready chan struct{}
+}
+
+func newPCMStream() *pcmStream {
+	return &pcmStream{ready: make(chan struct{}, 1)}
+}
+
+func (s *pcmStream) Write(p []byte) {
+	s.mu.Lock()
+	s.data = append(s.data, p...)
+	s.mu.Unlock()
+	s.wake()
+}
+
+// Close marks the end of the stream, after which the player stops once
+// it has played the remaining data.
+func (s *pcmStream) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.wake()
+}
+
+// pending returns how many bytes are waiting to be read.
+func (s *pcmStream) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data)
+}
+
+// consumed returns how many bytes have been read.
+func (s *pcmStream) consumed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read
+}
+
+func (s *pcmStream) wake() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *pcmStream) Read(p []byte) (int, error) {
+	for waited := false; ; waited = true {
+		s.mu.Lock()
+		if len(s.data) > 0 || s.closed || waited {
+			defer s.mu.Unlock()
+			if len(s.data) == 0 && s.closed {
+				return 0, io.EOF
+			}
+			n := copy(p, s.data)
+			s.data = s.data[n:]
+			s.read += n
+			return n, nil
+		}
+		s.mu.Unlock()
+
+		timer := time.NewTimer(streamWait)
+		select {
+		case <-s.ready:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// PlayAudioWithAnimation plays audio at the volume of source and animates
+// the mouth. Alerts interrupt everything else playing and leave the mouth
+// shut. It implements a timeout to prevent hanging if the audio player
+// gets stuck.
+func (fish *Fish) PlayAudioWithAnimation(ctx context.Context, pcmData []byte, sampleRate, channelCount int, source volume.Source) error {
+	kind := trackVoice
+	if source == volume.SourceAlerts {
+		kind = trackAlert
+	}
+	segments := make(chan segment, 1)
+	segments <- segment{pcm: pcmData, mouth: true, source: source}
+	close(segments)
+	return fish.playSegments(ctx, segments, kind, sampleRate, channelCount)
+}
+
+// segment is a part of a stream.
+type segment struct {
+	pcm []byte
+	// mouth animates the mouth to the audio; it stays shut otherwise.
+	mouth bool
+	// move is a motor move made when playback reaches the segment.
+	move Move
+	// cue is called when playback reaches the segment. It must not block.
+	cue func()
+	// source decides the gain the segment is played with.
+	source volume.Source
+}
+
+// playSegments plays the segments as one track of kind while they arrive.
+// Voice tracks animate the fish across all segments. The safety timeout
+// starts once segments is closed, as producing the segments may take a
+// while, and is extended while the track is interrupted.
+func (fish *Fish) playSegments(ctx context.Context, segments <-chan segment, kind trackKind, sampleRate, channelCount int) error {
+	_, span := otel.Tracer("fish").Start(ctx, "PlayAudioWithAnimation")
+	defer span.End()
+	defer func() {
+		// Let the producer finish if playback ended early
+		if segments != nil {
+			go func(rest <-chan segment) {
+				for range rest {
+				}
+			}(segments)
+		}
+	}()
+
+	t := fish.mixer.add(kind)
+	defer fish.mixer.remove(t)
+
+	// The animation follows the position of the player, so it stays in
+	// step with it and pauses along with it
+	var animation chan segment
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	stopAnimation := sync.OnceFunc(func() { close(stopped) })
+	defer stopAnimation()
+	if kind == trackVoice {
+		animation = make(chan segment, 64)
+		go func() {
+			defer close(done)
+			fish.animate(animation, t, stopped, channelCount)
+		}()
+	} else {
+		close(done)
+	}
+	closeAnimation := func() {
+		if animation != nil {
+			close(animation)
+			animation = nil
+		}
+	}
+	defer closeAnimation()
+
+	// Safety timeout: Expected duration + 5 seconds margin
+	var deadline time.Time
+	var timeout time.Duration
+
+	const tick = 100 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for segments != nil || fish.mixer.playing(t) {
+		select {
+		case seg, ok := <-segments:
+			if !ok {
+				segments = nil
+				t.stream.Close()
+				closeAnimation()
+				fish.mixer.update()
+				continue
+			}
+			// The animation gets the segment as is, so the mouth moves
+			// at any volume
+			t.stream.Write(applyGain(seg.pcm, fish.mixer.gain(seg.source)))
+			if animation != nil {
+				animation <- seg
+			}
+			fish.mixer.update()
+			duration := pcmDuration(len(seg.pcm), sampleRate, channelCount)
+			timeout += duration
+			deadline = later(deadline, time.Now()).Add(duration)
+		case <-ticker.C:
+			// Changed settings, the volume curve and ducking apply while
+			// playing
+			fish.mixer.update()
+			if fish.mixer.interrupted(t) {
+				deadline = deadline.Add(tick)
+			}
+			if segments == nil && time.Now().After(deadline.Add(5*time.Second)) {
+				return fmt.Errorf("playback timed out after %v", timeout+5*time.Second)
+			}
+		}
+	}
+
+	// Wait for animation to finish (it shouldn't take long after playback finishes)
+	// We use a small timeout here too just in case
+	stopAnimation()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(2 * time.Second):
+		slog.Warn("Animation goroutine took too long to finish")
+		return nil
+	}
+}
+
+// currentVolume returns the volume settings, or the defaults if they
+// cannot be read.
+func currentVolume() volume.Settings {
+	settings, err := volume.Get()
+	if err != nil {
+		slog.Error("Failed to read volume settings", "error", err)
+	}
+	return settings
+}
+
+// applyGain returns a copy of pcmData scaled by factor, or pcmData itself
+// if the factor is 1.
+func applyGain(pcmData []byte, factor float64) []byte {
+	if factor == 1 {
+		return pcmData
+	}
+	scaled := slices.Clone(pcmData)
+	audio.ApplyGain(scaled, 20*math.Log10(factor))
+	return scaled
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// animate opens the mouth while the audio of the segments of t is loud and
+// makes their moves and cues, as the player reaches them, until stopped is
+// closed. Windows continue across segments, so the mouth does not snap
+// shut between them.
+func (fish *Fish) animate(segments <-chan segment, t *track, stopped <-chan struct{}, channelCount int) {
+	const chunkDuration = 100 * time.Millisecond
+	const amplitudeThreshold = 1500
+	bitDepthInBytes := 2 // 16-bit audio
+	frameSize := bitDepthInBytes * channelCount
+	isMouthOpen := false
+
+	type entry struct {
+		segment
+		reached bool
+	}
+	// timeline holds the segments not fully played yet, the first one
+	// starting at position start of the track
+	var timeline []entry
+	start, played := 0, 0
+
+	ticker := time.NewTicker(chunkDuration)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case seg, ok := <-segments:
+			if !ok {
+				segments = nil
+			} else {
+				timeline = append(timeline, entry{segment: seg})
+			}
+			continue
+		case <-stopped:
+			break loop
+		case <-ticker.C:
+		}
+
+		position := fish.mixer.position(t)
+		position = max(position-position%frameSize, played)
+		var sum int64
+		var count int
+		offset := start
+		for i := range timeline {
+			if offset > position {
+				break
+			}
+			e := &timeline[i]
+			if !e.reached {
+				e.reached = true
+				if e.move != "" {
+					go fish.makeMove(e.move)
+				}
+				if e.cue != nil {
+					e.cue()
+				}
+			}
+			end := offset + len(e.pcm)
+			from, to := max(offset, played), min(end, position)
+			for j := from - offset; j+frameSize <= to-offset; j += frameSize {
+				if e.mouth {
+					sample := int16(binary.LittleEndian.Uint16(e.pcm[j : j+2]))
+					if sample < 0 {
+						sum += int64(-sample)
+					} else {
+						sum += int64(sample)
+					}
+				}
+				count++
+			}
+			offset = end
+		}
+		for len(timeline) > 0 && timeline[0].reached && start+len(timeline[0].pcm) <= position {
+			start += len(timeline[0].pcm)
+			timeline = timeline[1:]
+		}
+		played = position
+
+		// Nothing played, e.g. during an alert, shuts the mouth too
+		loud := count > 0 && sum/int64(count) > amplitudeThreshold
+		if loud {
+			fish.mixer.speak()
+		}
+		fish.Lock()
+		if loud && !isMouthOpen {
+			fish.OpenMouth()
+			isMouthOpen = true
+		} else if !loud && isMouthOpen {
+			fish.CloseMouth()
+			isMouthOpen = false
+		}
+		fish.Unlock()
+	}
+
+	fish.Lock()
+	if isMouthOpen {
+		fish.CloseMouth()
+		time.Sleep(1 * time.Second)
+		fish.StopMouth()
+	}
+
+	fish.StopBody()
+	fish.StopMouth()
+
+	fish.Unlock()
+}
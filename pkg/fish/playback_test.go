@@ -0,0 +1,49 @@
+package fish
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPCMStream(t *testing.T) {
+	stream := newPCMStream()
+	buf := make([]byte, 4)
+
+	// An empty stream returns no data after a short wait
+	start := time.Now()
+	if n, err := stream.Read(buf); n != 0 || err != nil {
+		t.Fatalf("Expected no data yet, got %d, %v", n, err)
+	}
+	if time.Since(start) < streamWait/2 {
+		t.Error("Expected the read to wait for data")
+	}
+
+	// A write wakes up a waiting read
+	go func() {
+		time.Sleep(streamWait / 4)
+		stream.Write([]byte{1, 2, 3, 4, 5, 6})
+	}()
+	if n, err := stream.Read(buf); n != 4 || err != nil {
+		t.Fatalf("Expected the written data, got %d, %v", n, err)
+	}
+
+	stream.Close()
+	if n, err := stream.Read(buf); n != 2 || err != nil {
+		t.Fatalf("Expected the rest before the end, got %d, %v", n, err)
+	}
+	if _, err := stream.Read(buf); err != io.EOF {
+		t.Fatalf("Expected EOF after the end, got %v", err)
+	}
+}
+
+func TestApplyGain(t *testing.T) {
+	pcmData := []byte{0x00, 0x10, 0x00, 0xf0} // 4096, -4096
+	scaled := applyGain(pcmData, 0.5)
+	if pcmData[1] != 0x10 {
+		t.Fatal("Expected the gain to leave the segment unchanged")
+	}
+	if scaled[1] != 0x08 || scaled[3] != 0xf8 {
+		t.Errorf("Expected half the samples, got % x", scaled)
+	}
+}
@@ -0,0 +1,67 @@
+package fish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/tts"
+	"github.com/youpy/go-wav"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// sentencesAhead is how many sentences are synthesized ahead of the one
+// being spoken.
+const sentencesAhead = 2
+
+// Say synthesizes text in voice with synth and plays it while animating
+// the fish. Long texts are synthesized sentence by sentence, ahead of
+// playback, so the fish starts talking after the first sentence. It
+// returns an error if synthesis or playback fails.
+func (myFish *Fish) Say(ctx context.Context, synth tts.Synthesizer, text string, voice piper.Voice) error {
+	ctx, span := otel.Tracer("fish").Start(ctx, "Say")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("text_length", fmt.Sprintf("%d", len(text))),
+		attribute.String("voice", voice.Voice),
+	)
+
+	slog.Info("saying", "text", text, "voice", voice.Voice)
+	if err := myFish.Perform(ctx, synth, text, []Step{{Text: text, Voice: voice}}); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	slog.Info("finished saying", "text", text)
+	return nil
+}
+
+// synthesizePCM returns text spoken in voice as 16-bit stereo PCM at
+// 44100Hz, the format of the audio context.
+func synthesizePCM(ctx context.Context, synth tts.Synthesizer, text string, voice piper.Voice) ([]byte, error) {
+	wavData, err := synth.Synthesize(ctx, text, voice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to synthesize text: %w", err)
+	}
+
+	wavReader := wav.NewReader(bytes.NewReader(wavData))
+	// Voices differ in sample rate, e.g. 16kHz for low and 22.05kHz for
+	// medium quality models
+	format, err := wavReader.Format()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wav format: %w", err)
+	}
+	if format.SampleRate == 0 || format.NumChannels == 0 {
+		return nil, fmt.Errorf("invalid wav format: %d Hz, %d channels", format.SampleRate, format.NumChannels)
+	}
+	pcmData, err := io.ReadAll(wavReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pcm data: %w", err)
+	}
+
+	// Convert to stereo at 44100Hz
+	return convertAudio(pcmData, int(format.SampleRate), int(format.NumChannels), 44100, 2), nil
+}
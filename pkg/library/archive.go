@@ -0,0 +1,205 @@
+package library
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxImportSize bounds the size of an imported library archive.
+	MaxImportSize = 200 << 20 // 200 MiB
+	// manifestName is the metadata file inside an exported archive.
+	manifestName = "library.json"
+)
+
+// Export writes a ZIP archive of the named clips, or of all clips if names
+// is empty, to w. Next to the clip files the archive contains a
+// library.json manifest with their metadata, so Import can restore it.
+func Export(w io.Writer, names []string) error {
+	clips, err := ListClips()
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		byName := map[string]Clip{}
+		for _, clip := range clips {
+			byName[clip.Name] = clip
+		}
+		clips = clips[:0]
+		for _, name := range names {
+			clip, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrClipNotFound, name)
+			}
+			clips = append(clips, clip)
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	manifest := map[string]Clip{}
+	for _, clip := range clips {
+		if err := addToArchive(archive, clip.Name); err != nil {
+			return fmt.Errorf("failed to export '%s': %w", clip.Name, err)
+		}
+		manifest[clip.Name] = clip
+	}
+
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     manifestName,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func addToArchive(archive *zip.Writer, name string) error {
+	file, err := os.Open(clipPath(name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	// WAV and MP3 hardly compress, so don't waste the Pi's CPU on it
+	header.Method = zip.Store
+	entry, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// ImportResult lists the outcome of Import per clip in the archive.
+type ImportResult struct {
+	Imported []Clip
+	Rejected []ImportRejection
+}
+
+// ImportRejection is a clip in an archive that could not be imported.
+type ImportRejection struct {
+	Name   string
+	Reason string
+}
+
+// Import adds the clips of a ZIP archive, e.g. one written by Export. Every
+// clip is validated like an upload and stored under a safe, unique name.
+// Metadata from the archive's manifest is kept, only the measurements are
+// taken afresh; clips without metadata are attributed to uploader.
+func Import(r io.ReaderAt, size int64, uploader string) (*ImportResult, error) {
+	if size > MaxImportSize {
+		return nil, &IngestError{
+			Reason:  ReasonTooLarge,
+			Message: fmt.Sprintf("Archive is too large, the maximum is %d MiB", MaxImportSize>>20),
+		}
+	}
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, &IngestError{Reason: ReasonInvalidArchive, Message: "The file is not a ZIP archive"}
+	}
+
+	manifest := map[string]Clip{}
+	for _, file := range archive.File {
+		if file.Name == manifestName {
+			if err := readManifest(file, &manifest); err != nil {
+				return nil, &IngestError{Reason: ReasonInvalidArchive, Message: fmt.Sprintf("Invalid %s in archive: %v", manifestName, err)}
+			}
+		}
+	}
+
+	result := &ImportResult{}
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || file.Name == manifestName {
+			continue
+		}
+		clip, err := importFile(file, manifest, uploader)
+		if err != nil {
+			var ingestErr *IngestError
+			if !errors.As(err, &ingestErr) {
+				return result, err
+			}
+			result.Rejected = append(result.Rejected, ImportRejection{Name: file.Name, Reason: ingestErr.Message})
+			continue
+		}
+		result.Imported = append(result.Imported, *clip)
+	}
+	return result, nil
+}
+
+func readManifest(file *zip.File, manifest *map[string]Clip) error {
+	entry, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+	return json.NewDecoder(io.LimitReader(entry, 10<<20)).Decode(manifest)
+}
+
+func importFile(file *zip.File, manifest map[string]Clip, uploader string) (*Clip, error) {
+	name := path.Base(strings.ReplaceAll(file.Name, `\`, "/"))
+	entry, err := file.Open()
+	if err != nil {
+		return nil, &IngestError{Reason: ReasonInvalidAudio, Message: fmt.Sprintf("Failed to read from archive: %v", err)}
+	}
+	defer entry.Close()
+
+	clip, err := Ingest(entry, name, int64(file.UncompressedSize64), uploader)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := manifest[name]
+	if !ok {
+		return clip, nil
+	}
+
+	clip, err = modifyClip(clip.Name, func(c *Clip) {
+		c.Title = meta.Title
+		c.Tags = meta.Tags
+		if slices.Contains(Categories, meta.Category) {
+			c.Category = meta.Category
+		}
+		c.Enabled = meta.Enabled
+		c.PlayCount = meta.PlayCount
+		if meta.Uploader != "" {
+			c.Uploader = meta.Uploader
+		}
+		if !meta.UploadedAt.IsZero() {
+			c.UploadedAt = meta.UploadedAt
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !meta.Edit.IsZero() {
+		edited, err := SetEdit(clip.Name, meta.Edit)
+		if err != nil {
+			slog.Warn("Dropped invalid edit of imported clip", "name", clip.Name, "error", err)
+			return clip, nil
+		}
+		clip = edited
+	}
+	return clip, nil
+}
@@ -0,0 +1,62 @@
+package library
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+)
+
+func TestExportImport(t *testing.T) {
+	source := t.TempDir()
+	Init(source)
+	os.WriteFile(filepath.Join(source, "bell.wav"), testWav(2*time.Second), 0644)
+	os.WriteFile(filepath.Join(source, "gong.wav"), testWav(time.Second), 0644)
+	UpdateClip("bell.wav", ClipEdit{Title: "Bell", Tags: []string{"lunch"}, Category: CategoryAlert})
+	SetEdit("bell.wav", audio.Edit{Start: 500 * time.Millisecond})
+
+	var buf bytes.Buffer
+	if err := Export(&buf, []string{"bell.wav"}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if err := Export(&bytes.Buffer{}, []string{"missing.wav"}); !errors.Is(err, ErrClipNotFound) {
+		t.Errorf("Expected ErrClipNotFound, got %v", err)
+	}
+
+	// Add a file that is not audio next to the exported clip
+	archive, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	var withJunk bytes.Buffer
+	w := zip.NewWriter(&withJunk)
+	for _, file := range archive.File {
+		w.Copy(file)
+	}
+	junk, _ := w.Create("notes.mp3")
+	junk.Write([]byte("not audio"))
+	w.Close()
+
+	target := t.TempDir()
+	Init(target)
+	result, err := Import(bytes.NewReader(withJunk.Bytes()), int64(withJunk.Len()), "importer")
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if len(result.Imported) != 1 || len(result.Rejected) != 1 || result.Rejected[0].Name != "notes.mp3" {
+		t.Fatalf("Unexpected import result: %+v", result)
+	}
+	clip, _ := GetClip("bell.wav")
+	if clip == nil || clip.Title != "Bell" || !clip.HasTag("lunch") || clip.Category != CategoryAlert || clip.Enabled {
+		t.Errorf("Metadata not imported: %+v", clip)
+	}
+	if clip.PlaybackDuration() != 1500*time.Millisecond || clip.Uploader != "importer" {
+		t.Errorf("Edit or uploader not imported: %+v", clip)
+	}
+
+	if _, err := Import(bytes.NewReader([]byte("junk")), 4, ""); err == nil {
+		t.Error("Expected an error for a file that is not a ZIP archive")
+	}
+}
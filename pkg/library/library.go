@@ -0,0 +1,275 @@
+// Package library manages the sound clips stored in the shared sound-data volume.
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+)
+
+const (
+	// MaxUploadSize is the largest clip accepted by Ingest.
+	MaxUploadSize = 20 << 20 // 20 MiB
+	// MaxClipDuration is the longest clip accepted by Ingest.
+	MaxClipDuration = 5 * time.Minute
+	// maxBaseNameLength bounds the generated file name, excluding extension and suffix.
+	maxBaseNameLength = 64
+	// waveformBuckets is the resolution of the stored waveform thumbnail.
+	waveformBuckets = 120
+)
+
+// Reasons reported in IngestError.
+const (
+	ReasonTooLarge          = "too_large"
+	ReasonUnsupportedFormat = "unsupported_format"
+	ReasonInvalidAudio      = "invalid_audio"
+	ReasonTooLong           = "too_long"
+	ReasonReservedName      = "reserved_name"
+	ReasonInvalidArchive    = "invalid_archive"
+)
+
+// IngestError describes why an uploaded clip was rejected.
+type IngestError struct {
+	// Reason is a machine-readable code, one of the Reason* constants.
+	Reason string
+	// Message is a human-readable explanation suitable for the upload form.
+	Message string
+}
+
+func (e *IngestError) Error() string {
+	return e.Message
+}
+
+// reservedNames are state files that live next to the clips and must never
+// be overwritten by an upload.
+var reservedNames = map[string]bool{
+	"played.json":     true,
+	"queue.json":      true,
+	"schedule.json":   true,
+	"nowplaying.json": true,
+	metadataFile:      true,
+	trashFile:         true,
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+var (
+	mu      sync.Mutex
+	dataDir = "./sound-data"
+)
+
+// Init configures the directory clips are stored in.
+func Init(dir string) {
+	mu.Lock()
+	defer mu.Unlock()
+	dataDir = dir
+}
+
+// Clip describes a stored sound file.
+type Clip struct {
+	Name       string        `json:"name"`
+	Format     audio.Format  `json:"format"`
+	Size       int64         `json:"size"`
+	Duration   time.Duration `json:"duration"`
+	SampleRate int           `json:"sample_rate"`
+	Channels   int           `json:"channels"`
+	// Loudness is the measured EBU R128 loudness, nil if not measured yet.
+	Loudness *audio.Loudness `json:"loudness,omitempty"`
+	// Gain is the normalization gain in dB the fish applies on playback.
+	Gain float64 `json:"gain_db"`
+	// Edit holds trim, fade and gain settings applied on playback.
+	Edit audio.Edit `json:"edit,omitzero"`
+	// Waveform holds waveformBuckets peak amplitudes of the whole file.
+	Waveform []byte `json:"waveform,omitempty"`
+
+	// Title is the display title, empty to show the file name.
+	Title string `json:"title,omitempty"`
+	// Tags are free-form lowercase labels used for search and filtering.
+	Tags []string `json:"tags,omitempty"`
+	// Category is one of the Category* constants.
+	Category   string    `json:"category"`
+	Uploader   string    `json:"uploader,omitempty"`
+	UploadedAt time.Time `json:"uploaded_at,omitzero"`
+	PlayCount  int       `json:"play_count"`
+	// Enabled clips are picked by the fish when it sings on its own.
+	// Disabled clips can still be queued explicitly.
+	Enabled bool `json:"enabled"`
+}
+
+// newClip describes a decoded clip, including its loudness measurement.
+func newClip(name string, format audio.Format, size int64, pcm *audio.PCM) *Clip {
+	clip := DefaultClip(name)
+	clip.setAudio(format, size, pcm)
+	return &clip
+}
+
+// setAudio fills in the format and measurements of c from its decoded
+// audio. Loudness is measured on the edited clip, so that trimmed silence
+// or fades do not skew normalization; the waveform covers the whole file.
+func (c *Clip) setAudio(format audio.Format, size int64, pcm *audio.PCM) {
+	loudness := audio.MeasureLoudness(c.Edit.Apply(pcm))
+	c.Format = format
+	c.Size = size
+	c.Duration = pcm.Duration()
+	c.SampleRate = pcm.SampleRate
+	c.Channels = pcm.Channels
+	c.Loudness = &loudness
+	c.Gain = loudness.NormalizationGain()
+	c.Waveform = audio.Waveform(pcm, waveformBuckets)
+}
+
+// PlaybackDuration returns how long the clip plays after trimming.
+func (c Clip) PlaybackDuration() time.Duration {
+	return c.Edit.Length(c.Duration)
+}
+
+// PlaybackGain returns the total gain in dB applied on playback.
+func (c Clip) PlaybackGain() float64 {
+	return c.Gain + c.Edit.Gain
+}
+
+// IsReservedName reports whether name refers to a state file rather than a clip.
+func IsReservedName(name string) bool {
+	name = strings.ToLower(name)
+	return reservedNames[name] || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp")
+}
+
+// SafeName turns an arbitrary client-supplied file name into a base name
+// that contains only letters, digits, dots, dashes and underscores and
+// cannot escape the data directory. The extension is replaced by ext.
+func SafeName(filename string, ext string) string {
+	// Clients may send full Windows or Unix paths
+	base := filepath.Base(strings.ReplaceAll(filename, `\`, "/"))
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	base = unsafeChars.ReplaceAllString(base, "-")
+	base = strings.Trim(base, "-._")
+	if len(base) > maxBaseNameLength {
+		base = strings.Trim(base[:maxBaseNameLength], "-._")
+	}
+	if base == "" {
+		base = "sound"
+	}
+	return base + ext
+}
+
+// Ingest validates an uploaded clip and stores it under a safe, unique name.
+// The content is sniffed and fully decoded, so files that merely carry an
+// audio extension are rejected. The loudness of the clip is measured and
+// stored together with the gain that normalizes it, and uploader is recorded
+// in its metadata. size is the size announced by the client; the actual
+// content is limited to MaxUploadSize regardless.
+func Ingest(r io.Reader, filename string, size int64, uploader string) (*Clip, error) {
+	if size > MaxUploadSize {
+		return nil, tooLarge()
+	}
+	base := filepath.Base(strings.ReplaceAll(filename, `\`, "/"))
+	if IsReservedName(base) {
+		return nil, &IngestError{Reason: ReasonReservedName, Message: fmt.Sprintf("'%s' is a reserved file name", base)}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if len(data) > MaxUploadSize {
+		return nil, tooLarge()
+	}
+
+	pcm, format, err := audio.Decode(data)
+	if err != nil {
+		if errors.Is(err, audio.ErrUnknownFormat) {
+			return nil, &IngestError{Reason: ReasonUnsupportedFormat, Message: "Only WAV and MP3 files are supported"}
+		}
+		return nil, &IngestError{Reason: ReasonInvalidAudio, Message: fmt.Sprintf("The file could not be decoded: %v", err)}
+	}
+	duration := pcm.Duration()
+	if duration > MaxClipDuration {
+		return nil, &IngestError{
+			Reason:  ReasonTooLong,
+			Message: fmt.Sprintf("Clip is %s long, the maximum is %s", duration.Round(time.Second), MaxClipDuration),
+		}
+	}
+
+	name, err := writeUnique(SafeName(filename, format.Extension()), data)
+	if err != nil {
+		return nil, err
+	}
+
+	clip := newClip(name, format, int64(len(data)), pcm)
+	clip.Uploader = uploader
+	clip.UploadedAt = time.Now()
+	if err := SaveClip(*clip); err != nil {
+		return nil, fmt.Errorf("failed to save clip metadata: %w", err)
+	}
+	return clip, nil
+}
+
+func tooLarge() *IngestError {
+	return &IngestError{
+		Reason:  ReasonTooLarge,
+		Message: fmt.Sprintf("File is too large, the maximum is %d MiB", MaxUploadSize>>20),
+	}
+}
+
+// writeUnique stores data under name, appending -2, -3, ... to the base
+// name if a file with that name already exists. The data is written to a
+// temporary file first and hard-linked into place, so the fish never sees a
+// partially written clip and existing files are never overwritten.
+// It returns the name used.
+func writeUnique(name string, data []byte) (string, error) {
+	mu.Lock()
+	dir := dataDir
+	mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	return linkUnique(tmp.Name(), dir, name)
+}
+
+// linkUnique hard-links src into dir as name, or as name with -2, -3, ...
+// appended to its base name if that is taken. It returns the name used.
+func linkUnique(src, dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		err := os.Link(src, filepath.Join(dir, candidate))
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return candidate, nil
+	}
+}
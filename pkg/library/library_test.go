@@ -0,0 +1,159 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+)
+
+func testWav(length time.Duration) []byte {
+	frames := int(length.Seconds() * 22050)
+	return audio.EncodeWAV(&audio.PCM{Data: make([]byte, frames*2), SampleRate: 22050, Channels: 1})
+}
+
+func TestSafeName(t *testing.T) {
+	cases := map[string]string{
+		"Lunch Bell.MP3":               "Lunch-Bell.mp3",
+		"../../etc/passwd":             "passwd.mp3",
+		`C:\Users\jana\Geburtstag.wav`: "Geburtstag.mp3",
+		"...":                          "sound.mp3",
+		"häppy bïrthday!!.wav":         "h-ppy-b-rthday.mp3",
+	}
+	for in, want := range cases {
+		if got := SafeName(in, ".mp3"); got != want {
+			t.Errorf("SafeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestIngest(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+
+	data := testWav(time.Second)
+
+	// 1. Valid upload gets a safe name and the sniffed extension
+	clip, err := Ingest(bytes.NewReader(data), "../Lunch Bell.mp3", int64(len(data)), "admin")
+	if err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	if clip.Name != "Lunch-Bell.wav" || clip.Duration != time.Second || clip.Format != audio.FormatWAV {
+		t.Errorf("Unexpected clip: %+v", clip)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "Lunch-Bell.wav")); err != nil {
+		t.Errorf("Clip not stored: %v", err)
+	}
+
+	// 2. Same name again is stored under a unique name
+	clip, err = Ingest(bytes.NewReader(data), "Lunch Bell.wav", int64(len(data)), "admin")
+	if err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	if clip.Name != "Lunch-Bell-2.wav" {
+		t.Errorf("Expected unique name, got %s", clip.Name)
+	}
+
+	// 3. Rejections
+	rejections := []struct {
+		name   string
+		data   []byte
+		size   int64
+		reason string
+	}{
+		{"queue.json", data, int64(len(data)), ReasonReservedName},
+		{"fake.mp3", []byte("<html>not audio</html>"), 22, ReasonUnsupportedFormat},
+		{"broken.wav", []byte("RIFF\x00\x00\x00\x00WAVEjunk"), 16, ReasonInvalidAudio},
+		{"huge.wav", data, MaxUploadSize + 1, ReasonTooLarge},
+		{"long.wav", testWav(MaxClipDuration + time.Second), 0, ReasonTooLong},
+	}
+	for _, r := range rejections {
+		_, err := Ingest(bytes.NewReader(r.data), r.name, r.size, "admin")
+		var ingestErr *IngestError
+		if !errors.As(err, &ingestErr) || ingestErr.Reason != r.reason {
+			t.Errorf("%s: expected %s, got %v", r.name, r.reason, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 4 {
+		t.Errorf("Expected only the two valid clips, their metadata and its lock on disk, got %d entries", len(entries))
+	}
+
+	// 4. Loudness is measured and stored for the fish
+	stored, err := GetClip("Lunch-Bell-2.wav")
+	if err != nil || stored == nil || stored.Loudness == nil {
+		t.Fatalf("Expected stored loudness, got %+v (%v)", stored, err)
+	}
+	if stored.Gain != 0 {
+		t.Errorf("Expected no gain for a silent clip, got %v", stored.Gain)
+	}
+}
+
+func TestMeasureMissing(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+
+	// A quiet 1 kHz tone copied into the directory by hand
+	pcm := &audio.PCM{Data: make([]byte, 44100*2), SampleRate: 44100, Channels: 1}
+	for i := 0; i < 44100; i++ {
+		sample := int16(math.Round(1000 * math.Sin(2*math.Pi*1000*float64(i)/44100)))
+		binary.LittleEndian.PutUint16(pcm.Data[i*2:], uint16(sample))
+	}
+	os.WriteFile(filepath.Join(tmpDir, "tone.wav"), audio.EncodeWAV(pcm), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "queue.json"), []byte("[]"), 0644)
+
+	if clip, _ := GetClip("tone.wav"); clip != nil {
+		t.Errorf("Expected no metadata before measuring, got %+v", clip)
+	}
+	if err := MeasureMissing(); err != nil {
+		t.Fatalf("MeasureMissing failed: %v", err)
+	}
+	clips, _ := GetClips()
+	if len(clips) != 1 {
+		t.Fatalf("Expected only the clip to be measured, got %v", clips)
+	}
+	// A -30 dBFS tone is raised towards the target loudness
+	if gain := clips["tone.wav"].Gain; gain < 13 || gain > 15 {
+		t.Errorf("Expected about +14 dB gain, got %.2f", gain)
+	}
+}
+
+func TestSetEdit(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, "bell.wav"), testWav(2*time.Second), 0644)
+	if _, err := UpdateClip("bell.wav", ClipEdit{Title: "Bell", Category: CategoryAlert, Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	edit := audio.Edit{Start: 500 * time.Millisecond, FadeOut: 200 * time.Millisecond, Gain: -3}
+	clip, err := SetEdit("bell.wav", edit)
+	if err != nil {
+		t.Fatalf("SetEdit failed: %v", err)
+	}
+	if clip.Edit != edit || clip.PlaybackDuration() != 1500*time.Millisecond || clip.PlaybackGain() != -3 {
+		t.Errorf("Unexpected clip after edit: %+v", clip)
+	}
+	if clip.Title != "Bell" || len(clip.Waveform) == 0 || clip.Loudness == nil {
+		t.Errorf("Expected metadata to be kept and measurements filled in: %+v", clip)
+	}
+
+	// Measuring again keeps the edit
+	if clip, _ = Measure("bell.wav"); clip.Edit != edit {
+		t.Errorf("Edit lost after measuring: %+v", clip.Edit)
+	}
+
+	if _, err := SetEdit("bell.wav", audio.Edit{Start: 3 * time.Second}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("Expected ErrInvalidMetadata, got %v", err)
+	}
+	if _, err := SetEdit("missing.wav", edit); !errors.Is(err, ErrClipNotFound) {
+		t.Errorf("Expected ErrClipNotFound, got %v", err)
+	}
+}
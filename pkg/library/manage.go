@@ -0,0 +1,321 @@
+package library
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// TrashRetention is how long deleted clips can be restored before they
+	// are purged for good.
+	TrashRetention = 7 * 24 * time.Hour
+
+	// trashDir holds the files of deleted clips. It starts with a dot, so it
+	// is never listed or served as a clip.
+	trashDir = ".trash"
+	// trashFile holds the TrashedClip records of all deleted clips.
+	trashFile = "trash.json"
+)
+
+var (
+	// ErrClipExists is returned when renaming a clip to a name that is taken.
+	ErrClipExists = errors.New("a sound with that name already exists")
+	// ErrTrashNotFound is returned when no trashed clip has the given ID.
+	ErrTrashNotFound = errors.New("trashed sound not found")
+)
+
+// trashMu guards trashFile and the files in trashDir.
+var trashMu sync.Mutex
+
+// TrashedClip is a deleted clip that can still be restored.
+type TrashedClip struct {
+	ID        string    `json:"id"`
+	Clip      Clip      `json:"clip"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+}
+
+// PurgeAt returns when the clip will be removed for good.
+func (t TrashedClip) PurgeAt() time.Time {
+	return t.DeletedAt.Add(TrashRetention)
+}
+
+// path returns where the file of the trashed clip is kept.
+func (t TrashedClip) path() string {
+	return clipPath(filepath.Join(trashDir, t.ID+filepath.Ext(t.Clip.Name)))
+}
+
+func newTrashID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checkClip returns ErrClipNotFound unless name is a clip in the data directory.
+func checkClip(name string) error {
+	if !isAudioFile(name) || filepath.Base(name) != name {
+		return ErrClipNotFound
+	}
+	if _, err := os.Stat(clipPath(name)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrClipNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Exists reports whether name is a clip in the data directory.
+func Exists(name string) (bool, error) {
+	switch err := checkClip(name); {
+	case errors.Is(err, ErrClipNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// Rename gives a clip a new name, made safe with SafeName while keeping the
+// clip's extension. Its metadata moves along; references in the queue and
+// history are not touched, see playlist.RenameSong.
+func Rename(name, newName string) (*Clip, error) {
+	if err := checkClip(name); err != nil {
+		return nil, err
+	}
+	newName = SafeName(newName, filepath.Ext(name))
+
+	unlock, err := lockStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	clips, err := readClips()
+	if err != nil {
+		return nil, err
+	}
+	clip, ok := clips[name]
+	if !ok {
+		clip = DefaultClip(name)
+	}
+	if newName == name {
+		return &clip, nil
+	}
+
+	// Link and remove instead of os.Rename, which would replace an existing clip
+	if err := os.Link(clipPath(name), clipPath(newName)); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("%w: %s", ErrClipExists, newName)
+		}
+		return nil, err
+	}
+	if err := os.Remove(clipPath(name)); err != nil {
+		os.Remove(clipPath(newName))
+		return nil, err
+	}
+
+	delete(clips, name)
+	clip.Name = newName
+	clips[newName] = clip
+	if err := writeClips(clips); err != nil {
+		return nil, err
+	}
+	return &clip, nil
+}
+
+func readTrash() ([]TrashedClip, error) {
+	trash := []TrashedClip{}
+	if err := readJSONFile(trashFile, &trash); err != nil {
+		return nil, err
+	}
+	return trash, nil
+}
+
+// GetTrash returns the deleted clips, most recently deleted first.
+func GetTrash() ([]TrashedClip, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	trash, err := readTrash()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		return trash[i].DeletedAt.After(trash[j].DeletedAt)
+	})
+	return trash, nil
+}
+
+// Delete moves a clip and its metadata to the trash, from where it can be
+// restored until it is purged after TrashRetention. by is recorded as the
+// user who deleted it.
+func Delete(name, by string) (*TrashedClip, error) {
+	if err := checkClip(name); err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	clips, err := readClips()
+	if err != nil {
+		return nil, err
+	}
+	clip, ok := clips[name]
+	if !ok {
+		clip = DefaultClip(name)
+	}
+	trashed := TrashedClip{ID: newTrashID(), Clip: clip, DeletedAt: time.Now(), DeletedBy: by}
+
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	trash, err := readTrash()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(clipPath(trashDir), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(clipPath(name), trashed.path()); err != nil {
+		return nil, err
+	}
+	if err := writeJSONFile(trashFile, append(trash, trashed)); err != nil {
+		return nil, err
+	}
+
+	delete(clips, name)
+	if err := writeClips(clips); err != nil {
+		return nil, err
+	}
+	return &trashed, nil
+}
+
+// Restore moves a clip out of the trash. If its name was taken in the
+// meantime, a unique name is chosen as for uploads.
+func Restore(id string) (*Clip, error) {
+	unlock, err := lockStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	trash, err := readTrash()
+	if err != nil {
+		return nil, err
+	}
+	i := trashIndex(trash, id)
+	if i < 0 {
+		return nil, ErrTrashNotFound
+	}
+	trashed := trash[i]
+
+	mu.Lock()
+	dir := dataDir
+	mu.Unlock()
+	name, err := linkUnique(trashed.path(), dir, trashed.Clip.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(trashed.path()); err != nil {
+		return nil, err
+	}
+	if err := writeJSONFile(trashFile, append(trash[:i], trash[i+1:]...)); err != nil {
+		return nil, err
+	}
+
+	clips, err := readClips()
+	if err != nil {
+		return nil, err
+	}
+	clip := trashed.Clip
+	clip.Name = name
+	clips[name] = clip
+	if err := writeClips(clips); err != nil {
+		return nil, err
+	}
+	return &clip, nil
+}
+
+// Purge removes a clip from the trash for good.
+func Purge(id string) error {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	trash, err := readTrash()
+	if err != nil {
+		return err
+	}
+	i := trashIndex(trash, id)
+	if i < 0 {
+		return ErrTrashNotFound
+	}
+	if err := os.Remove(trash[i].path()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeJSONFile(trashFile, append(trash[:i], trash[i+1:]...))
+}
+
+// PurgeExpired removes every clip that has been in the trash for longer
+// than TrashRetention at now. It returns the number of clips removed.
+func PurgeExpired(now time.Time) (int, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	trash, err := readTrash()
+	if err != nil {
+		return 0, err
+	}
+	kept := trash[:0]
+	purged := 0
+	for _, trashed := range trash {
+		if now.Before(trashed.PurgeAt()) {
+			kept = append(kept, trashed)
+			continue
+		}
+		if err := os.Remove(trashed.path()); err != nil && !os.IsNotExist(err) {
+			return purged, err
+		}
+		slog.Info("Purged deleted clip", "name", trashed.Clip.Name, "deleted_at", trashed.DeletedAt)
+		purged++
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, writeJSONFile(trashFile, kept)
+}
+
+// AutoPurge calls PurgeExpired every interval until ctx is cancelled.
+func AutoPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := PurgeExpired(time.Now()); err != nil {
+			slog.Error("Failed to purge trash", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trashIndex returns the index of the trashed clip with the given ID, or -1.
+func trashIndex(trash []TrashedClip, id string) int {
+	return slices.IndexFunc(trash, func(trashed TrashedClip) bool {
+		return trashed.ID == id
+	})
+}
@@ -0,0 +1,100 @@
+package library
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRename(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, "bell.wav"), testWav(time.Second), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "gong.wav"), testWav(time.Second), 0644)
+	UpdateClip("bell.wav", ClipEdit{Title: "Bell", Category: CategoryAlert, Enabled: true})
+
+	clip, err := Rename("bell.wav", "../Lunch Bell.mp3")
+	if err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if clip.Name != "Lunch-Bell.wav" || clip.Title != "Bell" {
+		t.Errorf("Unexpected clip after rename: %+v", clip)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "bell.wav")); !os.IsNotExist(err) {
+		t.Error("Expected the old file to be gone")
+	}
+	if stored, _ := GetClip("Lunch-Bell.wav"); stored == nil || stored.Category != CategoryAlert {
+		t.Errorf("Expected metadata to move along, got %+v", stored)
+	}
+	if old, _ := GetClip("bell.wav"); old != nil {
+		t.Errorf("Expected no metadata under the old name, got %+v", old)
+	}
+
+	if _, err := Rename("gong.wav", "Lunch-Bell"); !errors.Is(err, ErrClipExists) {
+		t.Errorf("Expected ErrClipExists, got %v", err)
+	}
+	if _, err := Rename("missing.wav", "other"); !errors.Is(err, ErrClipNotFound) {
+		t.Errorf("Expected ErrClipNotFound, got %v", err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, "bell.wav"), testWav(time.Second), 0644)
+	UpdateClip("bell.wav", ClipEdit{Title: "Bell", Category: CategorySong, Enabled: true})
+
+	// 1. Deleted clips disappear from the library but stay in the trash
+	trashed, err := Delete("bell.wav", "admin")
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if clips, _ := ListClips(); len(clips) != 0 {
+		t.Errorf("Expected an empty library, got %v", clips)
+	}
+	trash, _ := GetTrash()
+	if len(trash) != 1 || trash[0].Clip.Title != "Bell" || trash[0].DeletedBy != "admin" {
+		t.Fatalf("Unexpected trash: %+v", trash)
+	}
+
+	// 2. Restoring picks a unique name if the old one was taken meanwhile
+	os.WriteFile(filepath.Join(tmpDir, "bell.wav"), testWav(time.Second), 0644)
+	clip, err := Restore(trashed.ID)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if clip.Name != "bell-2.wav" || clip.Title != "Bell" {
+		t.Errorf("Unexpected restored clip: %+v", clip)
+	}
+	if trash, _ := GetTrash(); len(trash) != 0 {
+		t.Errorf("Expected an empty trash, got %+v", trash)
+	}
+	if _, err := Restore(trashed.ID); !errors.Is(err, ErrTrashNotFound) {
+		t.Errorf("Expected ErrTrashNotFound, got %v", err)
+	}
+
+	// 3. Expired clips are purged, recent ones kept
+	Delete("bell.wav", "")
+	recent, _ := Delete("bell-2.wav", "")
+	purged, err := PurgeExpired(recent.DeletedAt.Add(TrashRetention))
+	if err != nil || purged != 2 {
+		t.Errorf("Expected both clips to be purged, got %d (%v)", purged, err)
+	}
+	if _, err := os.Stat(recent.path()); !os.IsNotExist(err) {
+		t.Error("Expected the purged file to be removed")
+	}
+
+	os.WriteFile(filepath.Join(tmpDir, "gong.wav"), testWav(time.Second), 0644)
+	gong, _ := Delete("gong.wav", "")
+	if purged, _ := PurgeExpired(time.Now()); purged != 0 {
+		t.Errorf("Expected recently deleted clips to be kept, purged %d", purged)
+	}
+	if err := Purge(gong.ID); err != nil {
+		t.Errorf("Purge failed: %v", err)
+	}
+	if trash, _ := GetTrash(); len(trash) != 0 {
+		t.Errorf("Expected an empty trash, got %+v", trash)
+	}
+}
@@ -0,0 +1,216 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Clip categories.
+const (
+	CategorySong   = "song"
+	CategoryJingle = "jingle"
+	CategoryAlert  = "alert"
+)
+
+// Categories lists the valid clip categories.
+var Categories = []string{CategorySong, CategoryJingle, CategoryAlert}
+
+// ErrClipNotFound is returned when editing a clip that is not in the data directory.
+var ErrClipNotFound = errors.New("clip not found")
+
+// ErrInvalidMetadata is returned when an edit contains invalid values.
+var ErrInvalidMetadata = errors.New("invalid clip metadata")
+
+// DefaultClip returns the metadata of a clip that has never been edited:
+// an enabled song without title or tags.
+func DefaultClip(name string) Clip {
+	return Clip{Name: name, Category: CategorySong, Enabled: true}
+}
+
+// UnmarshalJSON fills in the defaults for fields missing from older records.
+func (c *Clip) UnmarshalJSON(data []byte) error {
+	type plain Clip
+	clip := plain(DefaultClip(""))
+	if err := json.Unmarshal(data, &clip); err != nil {
+		return err
+	}
+	*c = Clip(clip)
+	return nil
+}
+
+// DisplayTitle returns the title of the clip, or its name if it has none.
+func (c Clip) DisplayTitle() string {
+	if c.Title != "" {
+		return c.Title
+	}
+	return c.Name
+}
+
+// HasTag reports whether the clip carries tag.
+func (c Clip) HasTag(tag string) bool {
+	return slices.Contains(c.Tags, strings.ToLower(tag))
+}
+
+// Matches reports whether every word of query occurs in the clip's name,
+// title, category or tags, ignoring case. An empty query matches everything.
+func (c Clip) Matches(query string) bool {
+	haystack := strings.ToLower(strings.Join(append([]string{c.Name, c.Title, c.Category}, c.Tags...), " "))
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(haystack, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTags splits a comma or whitespace separated list into normalized,
+// deduplicated lowercase tags.
+func ParseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		tag = strings.Trim(tag, "#")
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Filter selects the clips the fish may pick when it sings on its own.
+type Filter struct {
+	// Categories allowed, all if empty.
+	Categories []string
+	// Tags of which a clip must carry at least one, any clip if empty.
+	Tags []string
+}
+
+// Allows reports whether clip is enabled and passes the filter.
+func (f Filter) Allows(clip Clip) bool {
+	if !clip.Enabled {
+		return false
+	}
+	if len(f.Categories) > 0 && !slices.Contains(f.Categories, clip.Category) {
+		return false
+	}
+	if len(f.Tags) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(f.Tags, clip.HasTag)
+}
+
+// ListClips returns every clip in the data directory sorted by name, with
+// its stored metadata or the defaults if it has none.
+func ListClips() ([]Clip, error) {
+	mu.Lock()
+	dir := dataDir
+	mu.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Clip{}, nil
+		}
+		return nil, err
+	}
+	stored, err := GetClips()
+	if err != nil {
+		return nil, err
+	}
+
+	clips := []Clip{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isAudioFile(entry.Name()) {
+			continue
+		}
+		clip, ok := stored[entry.Name()]
+		if !ok {
+			clip = DefaultClip(entry.Name())
+		}
+		clips = append(clips, clip)
+	}
+	sort.Slice(clips, func(i, j int) bool {
+		return clips[i].Name < clips[j].Name
+	})
+	return clips, nil
+}
+
+// isAudioFile reports whether name is a clip the fish can play.
+func isAudioFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return !IsReservedName(name) && (ext == ".wav" || ext == ".mp3")
+}
+
+// ClipEdit holds the user-editable metadata of a clip.
+type ClipEdit struct {
+	Title    string
+	Tags     []string
+	Category string
+	Enabled  bool
+}
+
+// UpdateClip applies edit to the metadata of the clip called name.
+func UpdateClip(name string, edit ClipEdit) (*Clip, error) {
+	if !slices.Contains(Categories, edit.Category) {
+		return nil, fmt.Errorf("%w: unknown category '%s'", ErrInvalidMetadata, edit.Category)
+	}
+	title := strings.TrimSpace(edit.Title)
+	if len(title) > 100 {
+		return nil, fmt.Errorf("%w: title is longer than 100 characters", ErrInvalidMetadata)
+	}
+	return modifyClip(name, func(clip *Clip) {
+		clip.Title = title
+		clip.Tags = edit.Tags
+		clip.Category = edit.Category
+		clip.Enabled = edit.Enabled
+	})
+}
+
+// IncrementPlayCount records that the fish played the clip called name.
+func IncrementPlayCount(name string) error {
+	_, err := modifyClip(name, func(clip *Clip) {
+		clip.PlayCount++
+	})
+	return err
+}
+
+// modifyClip applies change to the stored metadata of a clip, starting from
+// the defaults if the clip has no record yet.
+func modifyClip(name string, change func(*Clip)) (*Clip, error) {
+	if !isAudioFile(name) || filepath.Base(name) != name {
+		return nil, ErrClipNotFound
+	}
+	if _, err := os.Stat(clipPath(name)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrClipNotFound
+		}
+		return nil, err
+	}
+
+	unlock, err := lockStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	clips, err := readClips()
+	if err != nil {
+		return nil, err
+	}
+	clip, ok := clips[name]
+	if !ok {
+		clip = DefaultClip(name)
+	}
+	change(&clip)
+	clips[name] = clip
+	if err := writeClips(clips); err != nil {
+		return nil, err
+	}
+	return &clip, nil
+}
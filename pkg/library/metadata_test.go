@@ -0,0 +1,109 @@
+package library
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseTags(t *testing.T) {
+	got := ParseTags(" Lunch, #Friday  lunch\tbell,")
+	if want := []string{"lunch", "friday", "bell"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	song := Clip{Name: "a.mp3", Category: CategorySong, Tags: []string{"friday"}, Enabled: true}
+	jingle := Clip{Name: "b.wav", Category: CategoryJingle, Enabled: true}
+	disabled := Clip{Name: "c.wav", Category: CategorySong, Enabled: false}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		clip   Clip
+		want   bool
+	}{
+		{"no filter", Filter{}, jingle, true},
+		{"disabled", Filter{}, disabled, false},
+		{"category", Filter{Categories: []string{CategorySong}}, jingle, false},
+		{"tag", Filter{Tags: []string{"Friday"}}, song, true},
+		{"missing tag", Filter{Tags: []string{"monday"}}, song, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Allows(tt.clip); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClipMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, "bell.wav"), testWav(time.Second), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "gong.mp3"), []byte{}, 0644)
+	os.WriteFile(filepath.Join(tmpDir, "played.json"), []byte("[]"), 0644)
+
+	// Unedited clips are listed with the defaults
+	clips, err := ListClips()
+	if err != nil {
+		t.Fatalf("ListClips failed: %v", err)
+	}
+	if len(clips) != 2 || clips[0].Name != "bell.wav" || !clips[0].Enabled || clips[0].Category != CategorySong {
+		t.Fatalf("Unexpected clips: %+v", clips)
+	}
+
+	// Edits and play counts are persisted
+	edit := ClipEdit{Title: " Lunch Bell ", Tags: []string{"lunch"}, Category: CategoryJingle}
+	if _, err := UpdateClip("bell.wav", edit); err != nil {
+		t.Fatalf("UpdateClip failed: %v", err)
+	}
+	if err := IncrementPlayCount("bell.wav"); err != nil {
+		t.Fatalf("IncrementPlayCount failed: %v", err)
+	}
+	clip, _ := GetClip("bell.wav")
+	if clip.DisplayTitle() != "Lunch Bell" || clip.Enabled || clip.PlayCount != 1 || !clip.HasTag("lunch") {
+		t.Errorf("Unexpected clip after edit: %+v", clip)
+	}
+	if !clip.Matches("LUNCH jingle") || clip.Matches("lunch song") {
+		t.Error("Search does not match title, tags and category")
+	}
+
+	// Measuring again keeps the edits
+	if _, err := Measure("bell.wav"); err != nil {
+		t.Fatalf("Measure failed: %v", err)
+	}
+	clip, _ = GetClip("bell.wav")
+	if clip.Title != "Lunch Bell" || clip.PlayCount != 1 || clip.Loudness == nil {
+		t.Errorf("Edits lost after measuring: %+v", clip)
+	}
+
+	// Invalid edits
+	if _, err := UpdateClip("bell.wav", ClipEdit{Category: "podcast"}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("Expected ErrInvalidMetadata, got %v", err)
+	}
+	for _, name := range []string{"missing.wav", "played.json", "../bell.wav"} {
+		if _, err := UpdateClip(name, edit); !errors.Is(err, ErrClipNotFound) {
+			t.Errorf("%s: expected ErrClipNotFound, got %v", name, err)
+		}
+	}
+}
+
+func TestClipDefaultsFromOlderRecords(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, metadataFile), []byte(`{"bell.wav": {"name": "bell.wav", "gain_db": 3}}`), 0644)
+
+	clip, err := GetClip("bell.wav")
+	if err != nil || clip == nil {
+		t.Fatalf("GetClip failed: %v", err)
+	}
+	if !clip.Enabled || clip.Category != CategorySong || clip.Gain != 3 {
+		t.Errorf("Expected defaults for missing fields, got %+v", clip)
+	}
+}
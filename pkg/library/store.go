@@ -0,0 +1,232 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/filelock"
+)
+
+// metadataFile holds the Clip records of all clips in the data directory.
+const metadataFile = "library.json"
+
+// storeMu guards metadataFile. It is separate from mu so that slow
+// measurements never block path lookups.
+var storeMu sync.Mutex
+
+// lockStore locks metadataFile for a read-modify-write, both against other
+// goroutines and against the fish, which counts plays from its own
+// process. The returned function releases the lock.
+func lockStore() (unlock func(), err error) {
+	storeMu.Lock()
+	unlockFile, err := filelock.Lock(clipPath(metadataFile))
+	if err != nil {
+		storeMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		storeMu.Unlock()
+	}, nil
+}
+
+// clipPath returns the path of a clip in the data directory.
+func clipPath(name string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	return filepath.Join(dataDir, name)
+}
+
+// readJSONFile decodes the JSON file name in the data directory into v.
+// A missing or empty file leaves v untouched and is not an error.
+func readJSONFile(name string, v any) error {
+	data, err := os.ReadFile(clipPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile replaces the file name in the data directory atomically,
+// since the fish reads it from another process.
+func writeJSONFile(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := clipPath(name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readClips() (map[string]Clip, error) {
+	clips := map[string]Clip{}
+	if err := readJSONFile(metadataFile, &clips); err != nil {
+		return nil, err
+	}
+	return clips, nil
+}
+
+func writeClips(clips map[string]Clip) error {
+	return writeJSONFile(metadataFile, clips)
+}
+
+// GetClips returns the stored metadata of all clips, keyed by name.
+func GetClips() (map[string]Clip, error) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	return readClips()
+}
+
+// GetClip returns the stored metadata of a clip, or nil if it has none.
+func GetClip(name string) (*Clip, error) {
+	clips, err := GetClips()
+	if err != nil {
+		return nil, err
+	}
+	clip, ok := clips[name]
+	if !ok {
+		return nil, nil
+	}
+	return &clip, nil
+}
+
+// SaveClip stores the metadata of a clip, replacing any previous record.
+func SaveClip(clip Clip) error {
+	unlock, err := lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	clips, err := readClips()
+	if err != nil {
+		return err
+	}
+	clips[clip.Name] = clip
+	return writeClips(clips)
+}
+
+// Measure decodes a stored clip, measures its loudness and saves the result.
+func Measure(name string) (*Clip, error) {
+	return analyze(name, nil)
+}
+
+// SetEdit validates and stores the trim, fade and gain settings of a clip.
+// The loudness is measured again, since trimming changes it.
+func SetEdit(name string, edit audio.Edit) (*Clip, error) {
+	return analyze(name, &edit)
+}
+
+// analyze decodes a stored clip and saves its measurements, replacing its
+// edit first unless edit is nil. Other metadata is kept.
+func analyze(name string, edit *audio.Edit) (*Clip, error) {
+	if !isAudioFile(name) || filepath.Base(name) != name {
+		return nil, ErrClipNotFound
+	}
+	path := clipPath(name)
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrClipNotFound
+		}
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pcm, format, err := audio.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode '%s': %w", name, err)
+	}
+
+	stored, err := GetClip(name)
+	if err != nil {
+		return nil, err
+	}
+	measured := DefaultClip(name)
+	if stored != nil {
+		measured = *stored
+	}
+	if edit != nil {
+		if err := edit.Validate(pcm.Duration()); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+		}
+		measured.Edit = *edit
+	}
+	// Decoding and measuring is slow, so the store is only locked to merge
+	// the result into the current record.
+	measured.setAudio(format, info.Size(), pcm)
+
+	return modifyClip(name, func(clip *Clip) {
+		if clip.UploadedAt.IsZero() {
+			clip.UploadedAt = info.ModTime()
+		}
+		clip.Format = measured.Format
+		clip.Size = measured.Size
+		clip.Duration = measured.Duration
+		clip.SampleRate = measured.SampleRate
+		clip.Channels = measured.Channels
+		clip.Loudness = measured.Loudness
+		clip.Gain = measured.Gain
+		clip.Waveform = measured.Waveform
+		if edit != nil {
+			clip.Edit = *edit
+		}
+	})
+}
+
+// MeasureMissing measures every clip in the data directory that has no
+// loudness measurement or waveform yet, e.g. clips uploaded before these
+// were introduced. Clips that cannot be decoded are logged and skipped.
+func MeasureMissing() error {
+	mu.Lock()
+	dir := dataDir
+	mu.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	clips, err := GetClips()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isAudioFile(name) {
+			continue
+		}
+		if clip, ok := clips[name]; ok && clip.Loudness != nil && clip.Waveform != nil {
+			continue
+		}
+		clip, err := Measure(name)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Failed to measure clip loudness", "name", name, "error", err)
+			}
+			continue
+		}
+		slog.Info("Measured clip loudness", "name", name, "lufs", clip.Loudness.Integrated, "gain", clip.Gain)
+	}
+	return nil
+}
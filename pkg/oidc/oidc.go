@@ -0,0 +1,317 @@
+// Package oidc implements the OpenID Connect authorization code flow with
+// PKCE for the sounds web UI, including discovery, ID token verification
+// and the mapping of identity provider groups to user roles.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
+)
+
+// ErrNotAllowed is returned for identities the configuration rejects, e.g.
+// because of their e-mail domain or groups.
+var ErrNotAllowed = errors.New("not allowed to sign in")
+
+// Config configures the identity provider and who may sign in.
+type Config struct {
+	// Issuer is the URL of the identity provider, used for discovery.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL registered with the provider.
+	RedirectURL string
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+	// GroupsClaim is the ID token claim holding the user's groups.
+	// Defaults to "groups".
+	GroupsClaim string
+	// AllowedDomains restricts sign-in to verified e-mail addresses of these
+	// domains. Anyone the provider authenticates may sign in if it is empty.
+	AllowedDomains []string
+	// GroupRoles maps provider groups to roles. Members of several groups
+	// get the most privileged role.
+	GroupRoles map[string]users.Role
+	// DefaultRole is given to users in none of GroupRoles. If it is empty,
+	// such users may not sign in.
+	DefaultRole users.Role
+}
+
+// RoleFor returns the most privileged role any of groups maps to, falling
+// back to DefaultRole. It returns false if the user gets no role at all.
+func (c Config) RoleFor(groups []string) (users.Role, bool) {
+	for _, role := range users.Roles {
+		for _, group := range groups {
+			if c.GroupRoles[group] == role {
+				return role, true
+			}
+		}
+	}
+	return c.DefaultRole, c.DefaultRole != ""
+}
+
+// ParseGroupRoles parses a mapping of groups to roles written as
+// "group=role,group=role".
+func ParseGroupRoles(s string) (map[string]users.Role, error) {
+	groupRoles := map[string]users.Role{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || !slices.Contains(users.Roles, users.Role(role)) {
+			return nil, fmt.Errorf("invalid group mapping %q, expected group=role with role one of %v", pair, users.Roles)
+		}
+		groupRoles[group] = users.Role(role)
+	}
+	return groupRoles, nil
+}
+
+// RoleFor returns the role the provider's configuration gives to members
+// of groups, see Config.RoleFor.
+func (p *Provider) RoleFor(groups []string) (users.Role, bool) {
+	return p.config.RoleFor(groups)
+}
+
+// Claims is the verified identity from an ID token.
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Groups            []string
+}
+
+// Username returns the name the user is known by in the web UI. The e-mail
+// address is only used once the provider verified it, as users may enter
+// anyone's address otherwise.
+func (c Claims) Username() string {
+	if c.VerifiedUsername() {
+		return c.Email
+	}
+	if c.PreferredUsername != "" {
+		return c.PreferredUsername
+	}
+	return c.Subject
+}
+
+// ID identifies the user at the provider for good, unlike the username,
+// which users may be able to change.
+func (c Claims) ID() string {
+	return c.Issuer + " " + c.Subject
+}
+
+// VerifiedUsername reports whether the provider vouches for Username, as
+// it does for verified e-mail addresses.
+func (c Claims) VerifiedUsername() bool {
+	return c.Email != "" && c.EmailVerified
+}
+
+// metadata is the subset of the discovery document used by Provider.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider runs the sign-in flow against one identity provider. The
+// discovery document is fetched on first use, so an unreachable provider
+// does not keep the web UI from starting.
+type Provider struct {
+	config Config
+	client *http.Client
+	now    func() time.Time
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     *keySet
+}
+
+// New returns a Provider for config.
+func New(config Config) *Provider {
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// discover returns the provider metadata, fetching it if necessary.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	var meta metadata
+	if err := p.getJSON(ctx, wellKnown, &meta); err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+	if meta.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovery returned issuer %q, expected %q", meta.Issuer, p.config.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks endpoints")
+	}
+	p.metadata = &meta
+	p.keys = &keySet{uri: meta.JWKSURI, provider: p}
+	return p.metadata, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Flow holds the per-login secrets that must be kept, e.g. in the session,
+// between redirecting to the provider and handling the callback.
+type Flow struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// NewFlow returns fresh random secrets for one sign-in.
+func NewFlow() Flow {
+	return Flow{State: randomString(), Nonce: randomString(), CodeVerifier: randomString()}
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// codeChallenge derives the S256 PKCE challenge from a verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send the browser to for signing in.
+func (p *Provider) AuthCodeURL(ctx context.Context, flow Flow) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {codeChallenge(flow.CodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems the authorization code from the callback, verifies the
+// returned ID token against flow and checks the identity against the
+// allowed domains.
+func (p *Provider) Exchange(ctx context.Context, code string, flow Flow) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {flow.CodeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response lacks an ID token")
+	}
+
+	claims, err := p.verify(ctx, token.IDToken, flow.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkDomain(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (p *Provider) checkDomain(claims *Claims) error {
+	if len(p.config.AllowedDomains) == 0 {
+		return nil
+	}
+	_, domain, ok := strings.Cut(claims.Email, "@")
+	if !ok || !claims.EmailVerified {
+		return fmt.Errorf("%w: a verified e-mail address is required", ErrNotAllowed)
+	}
+	for _, allowed := range p.config.AllowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: e-mail domain %s", ErrNotAllowed, domain)
+}
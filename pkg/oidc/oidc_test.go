@@ -0,0 +1,287 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/users"
+)
+
+// mockIdP is a minimal OpenID provider. It issues a code for every
+// authorization request it is shown and signs ID tokens with claims.
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu       sync.Mutex
+	requests map[string]url.Values // authorization requests by code
+	claims   map[string]any
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, requests: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		auth, ok := idp.requests[r.PostFormValue("code")]
+		claims := idp.claims
+		idp.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+			return
+		}
+		if user, _, _ := r.BasicAuth(); user != "fish" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		token := map[string]any{
+			"iss":   idp.URL,
+			"aud":   "fish",
+			"sub":   "1234",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": auth.Get("nonce"),
+		}
+		for k, v := range claims {
+			token[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, token)})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIdP) sign(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize plays the user signing in: it follows the authorization URL
+// and returns the code the provider would redirect back with.
+func (idp *mockIdP) authorize(t *testing.T, authURL string, claims map[string]any) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, idp.URL+"/authorize?") {
+		t.Fatalf("Unexpected authorization URL %s", authURL)
+	}
+	code := randomString()
+	idp.mu.Lock()
+	idp.requests[code] = u.Query()
+	idp.claims = claims
+	idp.mu.Unlock()
+	return code
+}
+
+func testConfig(idp *mockIdP) Config {
+	return Config{
+		Issuer:         idp.URL,
+		ClientID:       "fish",
+		ClientSecret:   "secret",
+		RedirectURL:    "http://fish.local/login/oidc/callback",
+		Scopes:         []string{"email", "groups"},
+		AllowedDomains: []string{"office.example"},
+		GroupRoles:     map[string]users.Role{"fish-admins": users.RoleAdmin},
+		DefaultRole:    users.RoleViewer,
+	}
+}
+
+func TestSignIn(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := New(testConfig(idp))
+	ctx := context.Background()
+
+	flow := NewFlow()
+	authURL, err := provider.AuthCodeURL(ctx, flow)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	params, _ := url.ParseQuery(authURL[strings.Index(authURL, "?")+1:])
+	if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == flow.CodeVerifier {
+		t.Errorf("Expected an S256 PKCE challenge, got %v", params)
+	}
+	if params.Get("state") != flow.State || params.Get("scope") != "openid email groups" {
+		t.Errorf("Unexpected parameters %v", params)
+	}
+
+	code := idp.authorize(t, authURL, map[string]any{
+		"email":          "mia@office.example",
+		"email_verified": true,
+		"groups":         []string{"staff", "fish-admins"},
+	})
+	claims, err := provider.Exchange(ctx, code, flow)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if claims.Username() != "mia@office.example" {
+		t.Errorf("Expected e-mail as username, got %q", claims.Username())
+	}
+	if claims.ID() != idp.URL+" "+claims.Subject || claims.Subject == "" {
+		t.Errorf("Expected the issuer and subject as ID, got %q", claims.ID())
+	}
+	if role, ok := provider.RoleFor(claims.Groups); !ok || role != users.RoleAdmin {
+		t.Errorf("Expected fish-admins to map to admin, got %q", role)
+	}
+}
+
+func TestSignInRejected(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := New(testConfig(idp))
+	ctx := context.Background()
+	allowed := map[string]any{"email": "mia@office.example", "email_verified": true}
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		change func(flow *Flow)
+	}{
+		{"wrong verifier", allowed, func(flow *Flow) { flow.CodeVerifier = "guessed" }},
+		{"wrong nonce", allowed, func(flow *Flow) { flow.Nonce = "replayed" }},
+		{"other domain", map[string]any{"email": "eve@elsewhere.example", "email_verified": true}, nil},
+		{"unverified email", map[string]any{"email": "eve@office.example", "email_verified": false}, nil},
+		{"expired", map[string]any{"email": "mia@office.example", "email_verified": true, "exp": time.Now().Add(-time.Hour).Unix()}, nil},
+		{"other audience", map[string]any{"email": "mia@office.example", "email_verified": true, "aud": "printer"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := NewFlow()
+			authURL, err := provider.AuthCodeURL(ctx, flow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := idp.authorize(t, authURL, tt.claims)
+			if tt.change != nil {
+				tt.change(&flow)
+			}
+			if _, err := provider.Exchange(ctx, code, flow); err == nil {
+				t.Error("Expected sign-in to be rejected")
+			}
+		})
+	}
+
+	// Domain rejections are reported as such, so the UI can explain them
+	flow := NewFlow()
+	authURL, _ := provider.AuthCodeURL(ctx, flow)
+	code := idp.authorize(t, authURL, map[string]any{"email": "eve@elsewhere.example", "email_verified": true})
+	if _, err := provider.Exchange(ctx, code, flow); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("Expected ErrNotAllowed, got %v", err)
+	}
+}
+
+func TestForgedToken(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := New(testConfig(idp))
+	ctx := context.Background()
+	if _, err := provider.discover(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]any{"iss": idp.URL, "aud": "fish", "sub": "1", "exp": time.Now().Add(time.Hour).Unix(), "nonce": "n"}
+	token := idp.sign(t, claims)
+	if _, err := provider.verify(ctx, token, "n"); err != nil {
+		t.Fatalf("Expected genuine token to verify, got %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	claims["sub"] = "admin"
+	payload, _ := json.Marshal(claims)
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+	if _, err := provider.verify(ctx, forged, "n"); err == nil {
+		t.Error("Expected tampered token to be rejected")
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"test"}`))
+	if _, err := provider.verify(ctx, header+"."+parts[1]+".", "n"); err == nil {
+		t.Error("Expected unsigned token to be rejected")
+	}
+}
+
+func TestUsername(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims Claims
+		want   string
+	}{
+		{"verified email", Claims{Subject: "42", Email: "mia@office.example", EmailVerified: true, PreferredUsername: "mia"}, "mia@office.example"},
+		{"unverified email", Claims{Subject: "42", Email: "admin@office.example", PreferredUsername: "mia"}, "mia"},
+		{"subject", Claims{Subject: "42", Email: "admin@office.example"}, "42"},
+	}
+	for _, tt := range tests {
+		if got := tt.claims.Username(); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseGroupRoles(t *testing.T) {
+	groupRoles, err := ParseGroupRoles("fish-admins=admin, staff=member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groupRoles["fish-admins"] != users.RoleAdmin || groupRoles["staff"] != users.RoleMember {
+		t.Errorf("Unexpected mapping %v", groupRoles)
+	}
+	if _, err := ParseGroupRoles("staff=owner"); err == nil {
+		t.Error("Expected unknown role to be rejected")
+	}
+
+	config := Config{GroupRoles: groupRoles}
+	if _, ok := config.RoleFor([]string{"visitors"}); ok {
+		t.Error("Expected no role without a default")
+	}
+	if role, _ := config.RoleFor([]string{"staff"}); role != users.RoleMember {
+		t.Errorf("Expected member, got %q", role)
+	}
+}
@@ -0,0 +1,260 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is the leeway when checking token expiry and issue times.
+const clockSkew = time.Minute
+
+// keySet caches the provider's signing keys by key ID. Unknown key IDs
+// trigger a refetch, so key rotation is picked up without a restart.
+type keySet struct {
+	uri      string
+	provider *Provider
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	// Don't let tokens with made up key IDs hammer the provider
+	if time.Since(k.fetched) < 10*time.Second && k.keys != nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := k.provider.getJSON(ctx, k.uri, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	k.fetched = time.Now()
+	k.keys = map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			k.keys[jwk.Kid] = key
+		}
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// stringList accepts claims such as "aud" both as a string and as an array.
+type stringList []string
+
+func (a *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = stringList{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// verify checks the signature and standard claims of an ID token and
+// returns the identity it holds.
+func (p *Provider) verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature: %w", err)
+	}
+
+	p.mu.Lock()
+	keys := p.keys
+	p.mu.Unlock()
+	key, err := keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, digest[:], signature); err != nil {
+		return nil, err
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	var std struct {
+		Issuer            string     `json:"iss"`
+		Subject           string     `json:"sub"`
+		Audience          stringList `json:"aud"`
+		AuthorizedParty   string     `json:"azp"`
+		Expiry            int64      `json:"exp"`
+		IssuedAt          int64      `json:"iat"`
+		Nonce             string     `json:"nonce"`
+		Email             string     `json:"email"`
+		EmailVerified     any        `json:"email_verified"`
+		Name              string     `json:"name"`
+		PreferredUsername string     `json:"preferred_username"`
+	}
+	if err := decodeSegment(parts[1], &std); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	now := p.now()
+	switch {
+	case std.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("ID token issued by %q, expected %q", std.Issuer, p.config.Issuer)
+	case !slices.Contains(std.Audience, p.config.ClientID):
+		return nil, errors.New("ID token is not meant for this client")
+	case len(std.Audience) > 1 && std.AuthorizedParty != p.config.ClientID:
+		return nil, errors.New("ID token is authorized for another client")
+	case std.Expiry == 0 || now.After(time.Unix(std.Expiry, 0).Add(clockSkew)):
+		return nil, errors.New("ID token has expired")
+	case std.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(std.IssuedAt, 0)):
+		return nil, errors.New("ID token is issued in the future")
+	case std.Nonce != nonce:
+		return nil, errors.New("ID token nonce does not match")
+	case std.Subject == "":
+		return nil, errors.New("ID token lacks a subject")
+	}
+
+	claims := &Claims{
+		Issuer:            std.Issuer,
+		Subject:           std.Subject,
+		Email:             std.Email,
+		Name:              std.Name,
+		PreferredUsername: std.PreferredUsername,
+	}
+	// Some providers send email_verified as a string
+	switch verified := std.EmailVerified.(type) {
+	case bool:
+		claims.EmailVerified = verified
+	case string:
+		claims.EmailVerified = verified == "true"
+	}
+	if groups, ok := raw[p.config.GroupsClaim]; ok {
+		// Groups are usually a list, but a single group may come as a string
+		var list stringList
+		if err := json.Unmarshal(groups, &list); err == nil {
+			claims.Groups = list
+		}
+	}
+	return claims, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, digest, signature []byte) error {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("ID token algorithm does not match its key")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, signature); err != nil {
+			return errors.New("invalid ID token signature")
+		}
+		return nil
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("ID token algorithm does not match its key")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid ID token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported ID token algorithm %q", alg)
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
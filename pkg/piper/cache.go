@@ -0,0 +1,133 @@
+package piper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize bounds the cache, enough for a few hundred phrases.
+const DefaultCacheSize = 100 << 20
+
+// Cache keeps synthesized WAV files on disk, addressed by a hash of the
+// text and voice parameters, so repeated phrases do not need Piper. When
+// the files exceed the size limit the least recently used are removed.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu sync.Mutex
+}
+
+// NewCache returns a cache in dir, which is created if needed, holding at
+// most maxSize bytes.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create tts cache: %w", err)
+	}
+	return &Cache{dir: dir, maxSize: maxSize}, nil
+}
+
+// CacheKey returns the name text spoken in voice is cached under. The key
+// changes with any parameter that changes the audio.
+func CacheKey(text string, voice Voice) string {
+	data, _ := json.Marshal(SynthesizeRequest{Text: text, Voice: voice})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+".wav")
+}
+
+// Get returns the cached audio for text in voice and marks it as recently
+// used. It reports whether the audio was cached.
+func (c *Cache) Get(text string, voice Voice) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.path(CacheKey(text, voice))
+	wavData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		slog.Warn("Failed to touch cached audio", "path", path, "error", err)
+	}
+	return wavData, true
+}
+
+// Put stores the audio for text in voice and evicts the least recently
+// used files if the cache grew beyond its size.
+func (c *Cache) Put(text string, voice Voice, wavData []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Write to a temporary file first, so readers never see half a file
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to cache audio: %w", err)
+	}
+	if _, err := tmp.Write(wavData); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to cache audio: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to cache audio: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path(CacheKey(text, voice))); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to cache audio: %w", err)
+	}
+	return c.evict()
+}
+
+// evict removes the least recently used files until the cache fits.
+func (c *Cache) evict() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read tts cache: %w", err)
+	}
+
+	var files []os.FileInfo
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".wav") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		size += info.Size()
+	}
+	if size <= c.maxSize {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, file := range files {
+		if size <= c.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to evict cached audio: %w", err)
+		}
+		size -= file.Size()
+	}
+	return nil
+}
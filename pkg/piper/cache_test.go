@@ -0,0 +1,100 @@
+package piper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// wavData is the smallest response that passes as a WAV file.
+var wavData = []byte("RIFF\x24\x00\x00\x00WAVEfmt ")
+
+func newTestClient(url string) *PiperClient {
+	client := NewPiperClient(url)
+	client.Backoff = time.Millisecond
+	return client
+}
+
+func TestCacheKey(t *testing.T) {
+	slow := Voice{LengthScale: 1.5}
+	if CacheKey("Hallo", Voice{}) != CacheKey("Hallo", Voice{}) {
+		t.Error("Expected the same key for the same text and voice")
+	}
+	if CacheKey("Hallo", Voice{}) == CacheKey("Hallo", slow) {
+		t.Error("Expected the voice parameters to change the key")
+	}
+	if CacheKey("Hallo", Voice{}) == CacheKey("Hallo!", Voice{}) {
+		t.Error("Expected the text to change the key")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewCache(filepath.Join(dir, "tts"), 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audio := []byte("0123456789")
+	for _, text := range []string{"one", "two"} {
+		if err := cache.Put(text, Voice{}, audio); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Make "one" older than "two", then use it again
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(cache.path(CacheKey("one", Voice{})), old, old)
+	os.Chtimes(cache.path(CacheKey("two", Voice{})), old.Add(time.Minute), old.Add(time.Minute))
+	if got, ok := cache.Get("one", Voice{}); !ok || string(got) != string(audio) {
+		t.Fatalf("Expected the cached audio, got %q", got)
+	}
+
+	if err := cache.Put("three", Voice{}, audio); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("two", Voice{}); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	for _, text := range []string{"one", "three"} {
+		if _, ok := cache.Get(text, Voice{}); !ok {
+			t.Errorf("Expected %q to stay cached", text)
+		}
+	}
+}
+
+func TestSynthesizeUsesCache(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write(wavData)
+	}))
+
+	cache, err := NewCache(t.TempDir(), DefaultCacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(server.URL)
+	client.Cache = cache
+	ctx := context.Background()
+
+	if _, err := client.Synthesize(ctx, "Hallo", Voice{}); err != nil {
+		t.Fatal(err)
+	}
+	// Piper is gone, but the text is cached
+	server.Close()
+	audio, err := client.Synthesize(ctx, "Hallo", Voice{})
+	if err != nil || string(audio) != string(wavData) {
+		t.Fatalf("Expected the cached audio, got %q, %v", audio, err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Expected a single request, got %d", requests.Load())
+	}
+	if _, err := client.Synthesize(ctx, "Hallo", Voice{LengthScale: 2}); err == nil {
+		t.Error("Expected a different voice to need Piper")
+	}
+}
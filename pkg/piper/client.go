@@ -2,44 +2,271 @@ package piper
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"math/rand/v2"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const (
+	// DefaultTimeout bounds a single synthesis request. Piper needs a few
+	// seconds for long texts on a Raspberry Pi.
+	DefaultTimeout = 30 * time.Second
+	// DefaultRetries is how often a failed request is retried.
+	DefaultRetries = 2
+	// DefaultBackoff is the wait before the first retry, doubled for each
+	// further one.
+	DefaultBackoff = 500 * time.Millisecond
+
+	// maxBackoff caps the wait between retries and the Retry-After honored.
+	maxBackoff = 10 * time.Second
+	// maxResponseSize bounds the audio read, about 8 minutes of speech.
+	maxResponseSize = 20 << 20
+	// maxErrorBody is how much of an error response is kept for the error.
+	maxErrorBody = 512
 )
 
+// ErrInvalidAudio is returned when Piper answers with something that is
+// not a WAV file, e.g. an HTML error page.
+var ErrInvalidAudio = errors.New("piper returned invalid audio")
+
+// StatusError is returned when Piper answers with an error status.
+type StatusError struct {
+	StatusCode int
+	// Body is the start of the response, to help diagnose the failure.
+	Body string
+	// RetryAfter is the wait Piper asked for, or 0.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("piper returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("piper returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether retrying the request may succeed.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// RequestError is returned when Piper cannot be reached or the request
+// times out.
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return "piper request failed: " + e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
 type PiperClient struct {
 	BaseURL    string
 	HTTPClient *http.Client
+	// Timeout bounds each attempt, including reading the audio.
+	Timeout time.Duration
+	// Retries is how often a request is retried after a network error, a
+	// timeout or a 429 or 5xx status.
+	Retries int
+	// Backoff is the wait before the first retry. It doubles for each
+	// further retry, with some jitter.
+	Backoff time.Duration
+	// Cache, if set, answers repeated texts without asking Piper, which
+	// also keeps them working while Piper is down.
+	Cache *Cache
 }
 
+// NewPiperClient returns a client with the default timeout and retries
+// whose requests show up in traces.
 func NewPiperClient(baseURL string) *PiperClient {
 	return &PiperClient{
-		BaseURL:    baseURL,
-		HTTPClient: &http.Client{},
+		BaseURL: baseURL,
+		HTTPClient: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport,
+				otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+					return "piper " + r.Method
+				}),
+			),
+		},
+		Timeout: DefaultTimeout,
+		Retries: DefaultRetries,
+		Backoff: DefaultBackoff,
 	}
 }
 
 type SynthesizeRequest struct {
 	Text string `json:"text"`
+	Voice
+}
+
+// Synthesize returns text spoken in voice as a WAV file, from the cache
+// if possible. Transient failures are retried until ctx is done. Errors
+// are a *StatusError, a *RequestError or wrap ErrInvalidAudio.
+func (c *PiperClient) Synthesize(ctx context.Context, text string, voice Voice) ([]byte, error) {
+	if wavData, ok := c.Cached(text, voice); ok {
+		return wavData, nil
+	}
+
+	wavData, err := c.synthesizeWithRetries(ctx, text, voice)
+	if err == nil && c.Cache != nil {
+		if err := c.Cache.Put(text, voice, wavData); err != nil {
+			slog.Warn("Failed to cache synthesized audio", "error", err)
+		}
+	}
+	return wavData, err
 }
 
-func (c *PiperClient) Synthesize(text string) ([]byte, error) {
-	requestBody, err := json.Marshal(SynthesizeRequest{Text: text})
+// synthesizeWithRetries asks Piper, retrying transient failures.
+func (c *PiperClient) synthesizeWithRetries(ctx context.Context, text string, voice Voice) ([]byte, error) {
+	requestBody, err := json.Marshal(SynthesizeRequest{Text: text, Voice: voice})
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", c.BaseURL, bytes.NewBuffer(requestBody))
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		wavData, err := c.synthesize(ctx, requestBody)
+		if err == nil || attempt >= c.Retries || !temporary(err) || ctx.Err() != nil {
+			return wavData, err
+		}
+
+		wait := backoff
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > wait {
+			wait = statusErr.RetryAfter
+		}
+		// Up to a quarter of jitter, so clients do not retry in lockstep
+		wait = min(wait+rand.N(wait/4+1), maxBackoff)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// synthesize makes a single attempt.
+func (c *PiperClient) synthesize(ctx context.Context, requestBody []byte) ([]byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL, bytes.NewReader(requestBody))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "audio/wav")
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &RequestError{Err: err}
 	}
 	defer resp.Body.Close()
 
-	return io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(body)),
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !(strings.HasPrefix(mediaType, "audio/") || mediaType == "application/octet-stream") {
+			return nil, fmt.Errorf("%w: unexpected content type %q", ErrInvalidAudio, contentType)
+		}
+	}
+
+	wavData, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, &RequestError{Err: err}
+	}
+	if len(wavData) > maxResponseSize {
+		return nil, fmt.Errorf("%w: response larger than %d bytes", ErrInvalidAudio, maxResponseSize)
+	}
+	if len(wavData) < 12 || string(wavData[0:4]) != "RIFF" || string(wavData[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%w: missing RIFF/WAVE header", ErrInvalidAudio)
+	}
+	return wavData, nil
+}
+
+// Name identifies the engine in logs and traces.
+func (c *PiperClient) Name() string {
+	return "piper"
+}
+
+// Cached returns the audio for text in voice if it is in the cache.
+func (c *PiperClient) Cached(text string, voice Voice) ([]byte, bool) {
+	if c.Cache == nil {
+		return nil, false
+	}
+	return c.Cache.Get(text, voice)
+}
+
+// Healthy reports whether the Piper server answers. Any response short of
+// a server error counts, as the server has no dedicated health endpoint.
+func (c *PiperClient) Healthy(ctx context.Context) error {
+	timeout := c.Timeout
+	if timeout <= 0 || timeout > 5*time.Second {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.BaseURL, "/")+"/voices", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return &RequestError{Err: err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode >= 500 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// temporary reports whether err is worth retrying.
+func temporary(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var requestErr *RequestError
+	return errors.As(err, &requestErr)
+}
+
+// retryAfter parses a Retry-After header given in seconds.
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, maxBackoff)
 }
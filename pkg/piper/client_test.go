@@ -1,47 +1,211 @@
-package piper
+package piper_test
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper/pipertest"
 )
 
+func newTestClient(url string) *piper.PiperClient {
+	client := piper.NewPiperClient(url)
+	client.Backoff = time.Millisecond
+	return client
+}
+
 func TestSynthesize(t *testing.T) {
-	// Mock Piper Server
+	fake := pipertest.NewServer()
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Verify Request
+		// Verify the request as sent, the fake only keeps it decoded
 		if r.Method != "POST" {
 			t.Errorf("Expected POST, got %s", r.Method)
 		}
 		if r.Header.Get("Content-Type") != "application/json" {
 			t.Errorf("Expected Content-Type application/json, got %s", r.Header.Get("Content-Type"))
 		}
-
 		body, _ := io.ReadAll(r.Body)
-		var req SynthesizeRequest
-		if err := json.Unmarshal(body, &req); err != nil {
-			t.Errorf("Failed to unmarshal request: %v", err)
-		}
-
-		if req.Text != "Hello Fish" {
-			t.Errorf("Expected 'Hello Fish', got '%s'", req.Text)
+		// Unset parameters are left to the server
+		if strings.Contains(string(body), "noise_scale") {
+			t.Errorf("Expected unset parameters to be omitted, got %s", body)
 		}
-
-		// Return Audio Data
-		w.Write([]byte("mock-audio-data"))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fake.ServeHTTP(w, r)
 	}))
 	defer server.Close()
 
-	// Test Client
-	client := NewPiperClient(server.URL)
-	audio, err := client.Synthesize("Hello Fish")
-
+	client := newTestClient(server.URL)
+	speaker := 0
+	voice := piper.Voice{Voice: "en_US-lessac-low", SpeakerID: &speaker, LengthScale: 1.2}
+	audio, err := client.Synthesize(context.Background(), "Hello Fish", voice)
 	if err != nil {
 		t.Fatalf("Synthesize failed: %v", err)
 	}
-	if string(audio) != "mock-audio-data" {
-		t.Errorf("Expected 'mock-audio-data', got '%s'", string(audio))
+	if !bytes.Equal(audio, pipertest.Speak("Hello Fish", voice, pipertest.DefaultSampleRate)) {
+		t.Errorf("Expected the WAV data of the fake, got %d bytes", len(audio))
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 1 || requests[0].Text != "Hello Fish" {
+		t.Fatalf("Expected one request for 'Hello Fish', got %+v", requests)
+	}
+	req := requests[0]
+	if req.Voice.Voice != "en_US-lessac-low" || req.SpeakerID == nil || *req.SpeakerID != 0 || req.LengthScale != 1.2 {
+		t.Errorf("Expected the voice parameters, got %+v", req.Voice)
+	}
+}
+
+func TestSynthesizeRetries(t *testing.T) {
+	fake := pipertest.NewServer()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	fake.FailNext(2, http.StatusServiceUnavailable)
+	if _, err := client.Synthesize(context.Background(), "Hello", piper.Voice{}); err != nil {
+		t.Fatalf("Expected the third attempt to succeed, got %v", err)
+	}
+	if got := len(fake.Requests()); got != 3 {
+		t.Errorf("Expected 3 requests, got %d", got)
+	}
+
+	// Out of retries
+	fake.FailNext(10, http.StatusServiceUnavailable)
+	_, err := client.Synthesize(context.Background(), "Hello", piper.Voice{})
+	var statusErr *piper.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable || statusErr.Body != http.StatusText(http.StatusServiceUnavailable) {
+		t.Fatalf("Expected a 503 StatusError, got %v", err)
+	}
+	if got := len(fake.Requests()); got != 6 {
+		t.Errorf("Expected 1 attempt and 2 retries, got %d requests", got-3)
+	}
+}
+
+func TestSynthesizeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   func() http.Handler
+		check    func(error) bool
+		requests int32
+	}{
+		{
+			name: "client error",
+			server: func() http.Handler {
+				fake := pipertest.NewServer()
+				fake.FailNext(1, http.StatusBadRequest)
+				return fake
+			},
+			check: func(err error) bool {
+				var statusErr *piper.StatusError
+				return errors.As(err, &statusErr) && !statusErr.Temporary()
+			},
+			requests: 1,
+		},
+		{
+			name: "html page",
+			server: func() http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "text/html")
+					w.Write([]byte("<html>Internal error</html>"))
+				})
+			},
+			check:    func(err error) bool { return errors.Is(err, piper.ErrInvalidAudio) },
+			requests: 1,
+		},
+		{
+			name: "not a wav",
+			server: func() http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "audio/wav")
+					w.Write([]byte("mock-audio-data"))
+				})
+			},
+			check:    func(err error) bool { return errors.Is(err, piper.ErrInvalidAudio) },
+			requests: 1,
+		},
+		{
+			name: "timeout",
+			server: func() http.Handler {
+				fake := pipertest.NewServer()
+				fake.Latency = 200 * time.Millisecond
+				return fake
+			},
+			check: func(err error) bool {
+				var requestErr *piper.RequestError
+				return errors.As(err, &requestErr) && errors.Is(err, context.DeadlineExceeded)
+			},
+			requests: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			handler := tt.server()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			client := newTestClient(server.URL)
+			client.Timeout = 20 * time.Millisecond
+			audio, err := client.Synthesize(context.Background(), "Hello", piper.Voice{})
+			if audio != nil || !tt.check(err) {
+				t.Errorf("Unexpected result %q, %v", audio, err)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("Expected %d requests, got %d", tt.requests, got)
+			}
+		})
+	}
+}
+
+func TestSynthesizeCanceled(t *testing.T) {
+	fake := pipertest.NewServer()
+	fake.FailEvery = 1
+	fake.FailStatus = http.StatusTooManyRequests
+	fake.RetryAfter = 5 * time.Second
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := newTestClient(server.URL).Synthesize(ctx, "Hello", piper.Voice{})
+	var statusErr *piper.StatusError
+	if !errors.As(err, &statusErr) || statusErr.RetryAfter != 5*time.Second {
+		t.Fatalf("Expected a 429 StatusError, got %v", err)
+	}
+	if time.Since(start) > time.Second || len(fake.Requests()) != 1 {
+		t.Errorf("Expected to give up when the context is done, took %v and %d requests", time.Since(start), len(fake.Requests()))
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	server := httptest.NewServer(pipertest.NewServer())
+	client := newTestClient(server.URL)
+	if err := client.Healthy(context.Background()); err != nil {
+		t.Errorf("Expected the server to be healthy, got %v", err)
+	}
+
+	server.Close()
+	var requestErr *piper.RequestError
+	if err := client.Healthy(context.Background()); !errors.As(err, &requestErr) {
+		t.Errorf("Expected a RequestError, got %v", err)
+	}
+
+	// Any answer counts, even from a server without the voices endpoint
+	server = httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	if err := newTestClient(server.URL).Healthy(context.Background()); err != nil {
+		t.Errorf("Expected any answer to count as healthy, got %v", err)
 	}
 }
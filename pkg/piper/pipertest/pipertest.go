@@ -0,0 +1,214 @@
+// Package pipertest provides a fake Piper server that speaks the wire
+// protocol of the Piper HTTP server, so the fish, the piper command and
+// tests run without the real one.
+//
+// Instead of speech, the server answers with a WAV of beeps, one per
+// letter, with silences at spaces and punctuation. The same request always
+// gets the same audio, and the mouth of the fish moves to it like to
+// speech.
+package pipertest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+)
+
+const (
+	// DefaultSampleRate is the rate of the low quality voices.
+	DefaultSampleRate = 16000
+	// letterDuration is how long a letter takes at a length scale of 1,
+	// about the pace of the real voices.
+	letterDuration = 70 * time.Millisecond
+	// maxTextLength bounds the text, so the audio stays within what the
+	// client accepts.
+	maxTextLength = 10000
+)
+
+// DefaultVoices are the voices downloaded in the piper container.
+var DefaultVoices = []string{"de_DE-karlsson-low", "en_US-lessac-low"}
+
+// Server is a fake Piper server. Set its fields before serving requests.
+type Server struct {
+	// Voices are listed by /voices. Requests for other voices fail, except
+	// without a voice, which get the first one.
+	Voices []string
+	// SampleRate of the audio, DefaultSampleRate if zero.
+	SampleRate int
+	// Latency delays every synthesis, as a slow server would.
+	Latency time.Duration
+	// FailEvery makes every FailEvery-th synthesis fail with FailStatus,
+	// zero never fails.
+	FailEvery int
+	// FailStatus is the status of failed requests, 503 if zero.
+	FailStatus int
+	// RetryAfter is sent with failed requests, as a proxy in front of a
+	// busy server would. Zero sends none.
+	RetryAfter time.Duration
+
+	mu       sync.Mutex
+	requests []piper.SynthesizeRequest
+	failNext []int
+}
+
+// NewServer returns a server with the default voices that never fails.
+func NewServer() *Server {
+	return &Server{Voices: slices.Clone(DefaultVoices)}
+}
+
+// FailNext makes the next n syntheses fail with status, before FailEvery
+// applies again.
+func (s *Server) FailNext(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range n {
+		s.failNext = append(s.failNext, status)
+	}
+}
+
+// Requests returns the synthesis requests received so far, including the
+// failed ones.
+func (s *Server) Requests() []piper.SynthesizeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/voices" && r.Method == http.MethodGet:
+		s.listVoices(w)
+	case r.URL.Path == "/" && r.Method == http.MethodPost:
+		s.synthesize(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// listVoices answers like Piper: an object of the voices by name, each
+// with the parts of its model config clients look at.
+func (s *Server) listVoices(w http.ResponseWriter) {
+	type voiceConfig struct {
+		Audio struct {
+			SampleRate int `json:"sample_rate"`
+		} `json:"audio"`
+		NumSpeakers int `json:"num_speakers"`
+	}
+	voices := map[string]voiceConfig{}
+	for _, name := range s.Voices {
+		var config voiceConfig
+		config.Audio.SampleRate = s.sampleRate()
+		config.NumSpeakers = 1
+		voices[name] = config
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(voices)
+}
+
+func (s *Server) synthesize(w http.ResponseWriter, r *http.Request) {
+	var req piper.SynthesizeRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req)
+
+	s.mu.Lock()
+	if err == nil {
+		s.requests = append(s.requests, req)
+	}
+	status := 0
+	if len(s.failNext) > 0 {
+		status = s.failNext[0]
+		s.failNext = s.failNext[1:]
+	} else if s.FailEvery > 0 && len(s.requests)%s.FailEvery == 0 {
+		status = s.FailStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-time.After(s.Latency):
+	case <-r.Context().Done():
+		return
+	}
+
+	switch {
+	case err != nil:
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+	case status != 0:
+		if s.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(s.RetryAfter.Seconds())))
+		}
+		http.Error(w, http.StatusText(status), status)
+	case strings.TrimSpace(req.Text) == "":
+		http.Error(w, "no text", http.StatusBadRequest)
+	case len(req.Text) > maxTextLength:
+		http.Error(w, "text too long", http.StatusRequestEntityTooLarge)
+	case req.Voice.Voice != "" && !slices.Contains(s.Voices, req.Voice.Voice):
+		http.Error(w, "unknown voice: "+req.Voice.Voice, http.StatusBadRequest)
+	default:
+		if err := req.Voice.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write(Speak(req.Text, req.Voice, s.sampleRate()))
+	}
+}
+
+func (s *Server) sampleRate() int {
+	if s.SampleRate > 0 {
+		return s.SampleRate
+	}
+	return DefaultSampleRate
+}
+
+// Speak returns the audio the server answers for text in voice: a mono
+// 16-bit WAV at sampleRate with a beep per letter or digit and a silence
+// for anything else. The pitch of a beep depends on the letter and the
+// voice, its length on the length scale of the voice.
+func Speak(text string, voice piper.Voice, sampleRate int) []byte {
+	lengthScale := voice.LengthScale
+	if lengthScale == 0 {
+		lengthScale = 1
+	}
+	frames := int(letterDuration.Seconds() * lengthScale * float64(sampleRate))
+	base := voiceBase(voice)
+
+	var data []byte
+	for _, r := range strings.TrimSpace(text) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			data = append(data, make([]byte, frames*2)...)
+			continue
+		}
+		freq := base * math.Pow(2, float64(unicode.ToLower(r)%12)/12)
+		for i := range frames {
+			// Fade in and out over the beep, so beeps do not click
+			envelope := math.Sin(math.Pi * float64(i) / float64(frames))
+			sample := int16(12000 * envelope * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate)))
+			data = binary.LittleEndian.AppendUint16(data, uint16(sample))
+		}
+	}
+	return audio.EncodeWAV(&audio.PCM{Data: data, SampleRate: sampleRate, Channels: 1})
+}
+
+// voiceBase returns the lowest pitch of voice, so voices and speakers
+// sound different.
+func voiceBase(voice piper.Voice) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(voice.Voice))
+	if voice.SpeakerID != nil {
+		binary.Write(h, binary.LittleEndian, int64(*voice.SpeakerID))
+	}
+	return 180 + float64(h.Sum32()%120)
+}
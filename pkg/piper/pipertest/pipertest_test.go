@@ -0,0 +1,123 @@
+package pipertest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/audio"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+)
+
+func newClient(url string) *piper.PiperClient {
+	client := piper.NewPiperClient(url)
+	client.Backoff = time.Millisecond
+	return client
+}
+
+func TestSynthesize(t *testing.T) {
+	fake := NewServer()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := newClient(server.URL)
+
+	voice := piper.Voice{Voice: "en_US-lessac-low", LengthScale: 2}
+	wavData, err := client.Synthesize(context.Background(), "Hello fish", voice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcm, format, err := audio.Decode(wavData)
+	if err != nil || format != audio.FormatWAV {
+		t.Fatalf("Expected a WAV file, got %v, %v", format, err)
+	}
+	// Ten characters at twice the usual length
+	if pcm.SampleRate != DefaultSampleRate || pcm.Channels != 1 || pcm.Duration() != 1400*time.Millisecond {
+		t.Errorf("Unexpected audio: %d Hz, %d channels, %v", pcm.SampleRate, pcm.Channels, pcm.Duration())
+	}
+
+	again, _ := client.Synthesize(context.Background(), "Hello fish", voice)
+	if string(again) != string(wavData) {
+		t.Error("Expected the same audio for the same request")
+	}
+	if other, _ := client.Synthesize(context.Background(), "Hello fish", piper.Voice{}); string(other) == string(wavData) {
+		t.Error("Expected another voice to sound different")
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 3 || requests[0].Text != "Hello fish" || requests[0].LengthScale != 2 {
+		t.Errorf("Unexpected requests %+v", requests)
+	}
+}
+
+func TestVoices(t *testing.T) {
+	fake := NewServer()
+	fake.Voices = []string{"de_DE-karlsson-low"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := newClient(server.URL)
+
+	if err := client.Healthy(context.Background()); err != nil {
+		t.Errorf("Expected the server to be healthy, got %v", err)
+	}
+	resp, err := http.Get(server.URL + "/voices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var voices map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&voices); err != nil || len(voices) != 1 || voices["de_DE-karlsson-low"] == nil {
+		t.Errorf("Expected the configured voice, got %v, %v", voices, err)
+	}
+
+	_, err = client.Synthesize(context.Background(), "Hallo", piper.Voice{Voice: "en_US-lessac-low"})
+	var statusErr *piper.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an unknown voice to fail, got %v", err)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	fake := NewServer()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := newClient(server.URL)
+
+	// The client retries twice
+	fake.FailNext(2, http.StatusServiceUnavailable)
+	if _, err := client.Synthesize(context.Background(), "Hallo", piper.Voice{}); err != nil {
+		t.Errorf("Expected the retries to succeed, got %v", err)
+	}
+	if got := len(fake.Requests()); got != 3 {
+		t.Errorf("Expected 3 requests, got %d", got)
+	}
+
+	fake.FailEvery = 1
+	fake.FailStatus = http.StatusInternalServerError
+	_, err := client.Synthesize(context.Background(), "Hallo", piper.Voice{})
+	var statusErr *piper.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected the server to fail, got %v", err)
+	}
+
+	fake.FailStatus = http.StatusTooManyRequests
+	fake.RetryAfter = 2 * time.Second
+	client.Retries = 0
+	_, err = client.Synthesize(context.Background(), "Hallo", piper.Voice{})
+	if !errors.As(err, &statusErr) || statusErr.RetryAfter != 2*time.Second {
+		t.Errorf("Expected the server to ask for a wait, got %v", err)
+	}
+
+	fake.FailEvery = 0
+	fake.Latency = 300 * time.Millisecond
+	client.Timeout = 50 * time.Millisecond
+	client.Retries = 0
+	_, err = client.Synthesize(context.Background(), "Hallo", piper.Voice{})
+	var requestErr *piper.RequestError
+	if !errors.As(err, &requestErr) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package piper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Voice selects how Piper speaks. Zero fields leave the server's defaults.
+type Voice struct {
+	// Voice is the Piper model, e.g. "en_US-lessac-medium". The server
+	// must have it downloaded.
+	Voice string `json:"voice,omitempty"`
+	// SpeakerID picks a speaker of a multi-speaker model.
+	SpeakerID *int `json:"speaker_id,omitempty"`
+	// LengthScale stretches the speech: above 1 is slower, below 1 faster.
+	LengthScale float64 `json:"length_scale,omitempty"`
+	// NoiseScale varies the audio, NoiseW the phoneme lengths.
+	NoiseScale float64 `json:"noise_scale,omitempty"`
+	NoiseW     float64 `json:"noise_w,omitempty"`
+}
+
+// Validate checks that the parameters are within the ranges Piper handles.
+func (v Voice) Validate() error {
+	if v.SpeakerID != nil && *v.SpeakerID < 0 {
+		return fmt.Errorf("speaker_id must not be negative")
+	}
+	if v.LengthScale < 0 || v.LengthScale > 5 {
+		return fmt.Errorf("length_scale must be between 0 and 5")
+	}
+	if v.NoiseScale < 0 || v.NoiseScale > 2 {
+		return fmt.Errorf("noise_scale must be between 0 and 2")
+	}
+	if v.NoiseW < 0 || v.NoiseW > 2 {
+		return fmt.Errorf("noise_w must be between 0 and 2")
+	}
+	return nil
+}
+
+// Profiles are named voices, so phrases, queue items and schedules can
+// refer to a "character" instead of repeating its parameters.
+type Profiles map[string]Voice
+
+// DefaultProfile is the profile used when none is given.
+const DefaultProfile = "default"
+
+// DefaultProfiles are available without a profiles file. The models are
+// the ones downloaded in the piper container.
+var DefaultProfiles = Profiles{
+	DefaultProfile: {},
+	"karlsson":     {Voice: "de_DE-karlsson-low"},
+	"hectic":       {Voice: "de_DE-karlsson-low", LengthScale: 0.7, NoiseScale: 0.9},
+	"sleepy":       {Voice: "de_DE-karlsson-low", LengthScale: 1.5, NoiseW: 0.3},
+	"english":      {Voice: "en_US-lessac-low"},
+}
+
+// LoadProfiles returns DefaultProfiles extended and overridden by the
+// JSON object of profiles in the file at path. A missing file is not an
+// error.
+func LoadProfiles(path string) (Profiles, error) {
+	profiles := Profiles{}
+	for name, voice := range DefaultProfiles {
+		profiles[name] = voice
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profiles, nil
+		}
+		return profiles, err
+	}
+	var custom Profiles
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return profiles, fmt.Errorf("invalid voice profiles in %s: %w", path, err)
+	}
+	for name, voice := range custom {
+		if err := voice.Validate(); err != nil {
+			return profiles, fmt.Errorf("invalid voice profile %q: %w", name, err)
+		}
+		profiles[name] = voice
+	}
+	return profiles, nil
+}
+
+// Get returns the profile called name, the default profile for an empty
+// name. It reports whether the profile exists.
+func (p Profiles) Get(name string) (Voice, bool) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	voice, ok := p[name]
+	return voice, ok
+}
+
+// Names returns the profile names, sorted with the default first.
+func (p Profiles) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == DefaultProfile) != (names[j] == DefaultProfile) {
+			return names[i] == DefaultProfile
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
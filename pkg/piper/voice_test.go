@@ -0,0 +1,53 @@
+package piper
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadProfiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "voices.json")
+
+	profiles, err := LoadProfiles(path)
+	if err != nil {
+		t.Fatalf("Expected a missing file to be fine, got %v", err)
+	}
+	if _, ok := profiles.Get("english"); !ok {
+		t.Error("Expected the default profiles")
+	}
+
+	os.WriteFile(path, []byte(`{
+		"pirate": {"voice": "en_GB-alan-low", "length_scale": 1.3},
+		"english": {"voice": "en_US-lessac-medium"}
+	}`), 0644)
+	profiles, err = LoadProfiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if voice, _ := profiles.Get("pirate"); voice.Voice != "en_GB-alan-low" || voice.LengthScale != 1.3 {
+		t.Errorf("Expected the custom profile, got %+v", voice)
+	}
+	if voice, _ := profiles.Get("english"); voice.Voice != "en_US-lessac-medium" {
+		t.Errorf("Expected the custom profile to override the default, got %+v", voice)
+	}
+	if voice, ok := profiles.Get(""); !ok || voice != (Voice{}) {
+		t.Errorf("Expected an empty name to select the default voice, got %+v", voice)
+	}
+	if _, ok := profiles.Get("klingon"); ok {
+		t.Error("Expected unknown profiles to be reported")
+	}
+	if names := profiles.Names(); names[0] != DefaultProfile || !slices.IsSorted(names[1:]) {
+		t.Errorf("Expected the default profile first, got %v", names)
+	}
+	if _, ok := DefaultProfiles["pirate"]; ok {
+		t.Error("Expected the default profiles to be left alone")
+	}
+
+	os.WriteFile(path, []byte(`{"fast": {"length_scale": -1}}`), 0644)
+	if _, err := LoadProfiles(path); err == nil {
+		t.Error("Expected invalid parameters to be rejected")
+	}
+}
@@ -0,0 +1,59 @@
+package playlist
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// CycleInterval is how often the fish takes the next item from the queue.
+	CycleInterval = time.Minute
+	// cycleLeadIn is the time between the start of a cycle and the start of playback.
+	cycleLeadIn = 1 * time.Second
+	// cycleLeadOut is the time the fish spends moving after playback finished.
+	cycleLeadOut = 4 * time.Second
+	// speechCharsPerSecond approximates how fast the TTS voice speaks.
+	speechCharsPerSecond = 14
+)
+
+// QueueETA is a queued item together with its expected playback window.
+type QueueETA struct {
+	QueueItem
+	Duration time.Duration
+	StartsAt time.Time
+}
+
+// EstimateSpeechDuration guesses how long it takes the fish to say text.
+func EstimateSpeechDuration(text string) time.Duration {
+	chars := utf8.RuneCountInString(text)
+	return time.Duration(float64(chars) / speechCharsPerSecond * float64(time.Second))
+}
+
+// EstimateQueue predicts when each queued item will start playing.
+// The fish takes one item per cycle and skips cycles while it is still busy,
+// so every item starts at the first cycle boundary after the previous one
+// (or the currently playing item) has finished. durationOf returns the
+// expected playback length of an item.
+func EstimateQueue(now time.Time, current *NowPlaying, items []QueueItem, durationOf func(QueueItem) time.Duration) []QueueETA {
+	busyUntil := now
+	if current != nil {
+		busyUntil = now.Add(current.Remaining(now) + cycleLeadOut)
+	}
+
+	etas := make([]QueueETA, 0, len(items))
+	for _, item := range items {
+		cycleStart := busyUntil.Truncate(CycleInterval)
+		if cycleStart.Before(busyUntil) {
+			cycleStart = cycleStart.Add(CycleInterval)
+		}
+		duration := durationOf(item)
+		startsAt := cycleStart.Add(cycleLeadIn)
+		etas = append(etas, QueueETA{
+			QueueItem: item,
+			Duration:  duration,
+			StartsAt:  startsAt,
+		})
+		busyUntil = startsAt.Add(duration + cycleLeadOut)
+	}
+	return etas
+}
@@ -0,0 +1,63 @@
+package playlist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNowPlayingProgress(t *testing.T) {
+	start := time.Date(2025, 3, 12, 11, 45, 1, 0, time.UTC)
+	np := NowPlaying{Name: "bell.mp3", Type: "song", StartedAt: start, Duration: 20 * time.Second}
+
+	if got := np.Elapsed(start.Add(5 * time.Second)); got != 5*time.Second {
+		t.Errorf("Expected 5s elapsed, got %v", got)
+	}
+	if got := np.Progress(start.Add(5 * time.Second)); got != 25 {
+		t.Errorf("Expected 25%% progress, got %v", got)
+	}
+	if got := np.Remaining(start.Add(time.Minute)); got != 0 {
+		t.Errorf("Expected nothing remaining after the end, got %v", got)
+	}
+}
+
+func TestEstimateQueue(t *testing.T) {
+	now := time.Date(2025, 3, 12, 11, 45, 10, 0, time.UTC)
+	current := &NowPlaying{
+		Name:      "long-song.mp3",
+		Type:      "song",
+		StartedAt: now.Add(-9 * time.Second),
+		Duration:  70 * time.Second,
+	}
+	items := []QueueItem{
+		{Name: "short.mp3", Type: "song"},
+		{Name: "Hallo", Type: "text"},
+	}
+	durations := map[string]time.Duration{
+		"short.mp3": 10 * time.Second,
+		"Hallo":     2 * time.Second,
+	}
+
+	etas := EstimateQueue(now, current, items, func(item QueueItem) time.Duration {
+		return durations[item.Name]
+	})
+	if len(etas) != 2 {
+		t.Fatalf("Expected 2 estimates, got %d", len(etas))
+	}
+
+	// The current song runs until 11:46:11, so the 11:46 cycle is skipped.
+	if want := time.Date(2025, 3, 12, 11, 47, 1, 0, time.UTC); !etas[0].StartsAt.Equal(want) {
+		t.Errorf("Expected first item at %v, got %v", want, etas[0].StartsAt)
+	}
+	if want := time.Date(2025, 3, 12, 11, 48, 1, 0, time.UTC); !etas[1].StartsAt.Equal(want) {
+		t.Errorf("Expected second item at %v, got %v", want, etas[1].StartsAt)
+	}
+	if etas[1].Duration != 2*time.Second || etas[1].Name != "Hallo" {
+		t.Errorf("Unexpected estimate %+v", etas[1])
+	}
+
+	// An idle fish plays the first item at the next cycle.
+	etas = EstimateQueue(now, nil, items[:1], func(QueueItem) time.Duration { return time.Second })
+	if want := time.Date(2025, 3, 12, 11, 46, 1, 0, time.UTC); !etas[0].StartsAt.Equal(want) {
+		t.Errorf("Expected idle estimate %v, got %v", want, etas[0].StartsAt)
+	}
+}
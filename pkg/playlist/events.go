@@ -0,0 +1,139 @@
+package playlist
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// Change identifies which part of the shared playlist state was modified.
+type Change string
+
+const (
+	ChangeQueue      Change = "queue"
+	ChangeHistory    Change = "history"
+	ChangeSchedule   Change = "schedule"
+	ChangeNowPlaying Change = "nowplaying"
+)
+
+// subscriberBuffer is how many pending changes a slow subscriber may lag
+// behind before further changes are dropped for it.
+const subscriberBuffer = 32
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+var (
+	subMu       sync.Mutex
+	subscribers = map[chan Change]struct{}{}
+	// lastSeen records the last known stamp per file, so that Watch only
+	// reports writes it has not already been notified about in-process.
+	lastSeen = map[string]fileStamp{}
+)
+
+// Subscribe registers for change notifications. The returned function
+// must be called to unsubscribe once the caller is no longer interested.
+func Subscribe() (<-chan Change, func()) {
+	ch := make(chan Change, subscriberBuffer)
+	subMu.Lock()
+	subscribers[ch] = struct{}{}
+	subMu.Unlock()
+
+	return ch, func() {
+		subMu.Lock()
+		defer subMu.Unlock()
+		if _, ok := subscribers[ch]; ok {
+			delete(subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func stampOf(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// notify broadcasts change to all subscribers after path was written by this process.
+func notify(change Change, path string) {
+	stamp := stampOf(path)
+
+	subMu.Lock()
+	defer subMu.Unlock()
+	lastSeen[path] = stamp
+	broadcast(change)
+}
+
+// broadcast must be called with subMu held.
+func broadcast(change Change) {
+	for ch := range subscribers {
+		select {
+		case ch <- change:
+		default:
+			// Subscriber is not keeping up; it will catch up on the next change.
+		}
+	}
+}
+
+// watchedFiles returns the state files written by the other service.
+func watchedFiles() map[string]Change {
+	files := map[string]Change{}
+
+	mu.Lock()
+	files[filePath] = ChangeHistory
+	mu.Unlock()
+
+	queueMu.Lock()
+	files[queuePath] = ChangeQueue
+	queueMu.Unlock()
+
+	scheduleMu.Lock()
+	files[schedulePath] = ChangeSchedule
+	scheduleMu.Unlock()
+
+	nowPlayingMu.Lock()
+	files[nowPlayingPath] = ChangeNowPlaying
+	nowPlayingMu.Unlock()
+
+	return files
+}
+
+// Watch polls the state files every interval and notifies subscribers about
+// modifications made by other processes, e.g. the fish popping the queue.
+// It blocks until ctx is cancelled.
+func Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Establish a baseline so that existing files are not reported as changes.
+	poll(false)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			poll(true)
+		}
+	}
+}
+
+func poll(report bool) {
+	for path, change := range watchedFiles() {
+		stamp := stampOf(path)
+
+		subMu.Lock()
+		if prev, ok := lastSeen[path]; !ok || prev != stamp {
+			lastSeen[path] = stamp
+			if report && ok {
+				broadcast(change)
+			}
+		}
+		subMu.Unlock()
+	}
+}
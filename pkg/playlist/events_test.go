@@ -0,0 +1,91 @@
+package playlist
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func expectChange(t *testing.T, changes <-chan Change, want Change) {
+	t.Helper()
+	select {
+	case got := <-changes:
+		if got != want {
+			t.Errorf("Expected change %q, got %q", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for change %q", want)
+	}
+}
+
+func TestSubscribeLocalChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+
+	changes, unsubscribe := Subscribe()
+	defer unsubscribe()
+
+	if _, err := AddToQueue(QueueItem{Name: "test.mp3", Type: "song"}); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, ChangeQueue)
+
+	if err := AddPlayedItem(PlayedItem{Name: "test.mp3", Type: "song", Timestamp: time.Now()}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, ChangeHistory)
+
+	if err := SetNowPlaying(NowPlaying{Name: "test.mp3", Type: "song", StartedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, ChangeNowPlaying)
+
+	current, err := GetNowPlaying()
+	if err != nil || current == nil || current.Name != "test.mp3" {
+		t.Fatalf("Expected test.mp3 to be playing, got %v (%v)", current, err)
+	}
+
+	if err := ClearNowPlaying(); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, ChangeNowPlaying)
+
+	current, err = GetNowPlaying()
+	if err != nil || current != nil {
+		t.Errorf("Expected nothing playing, got %v (%v)", current, err)
+	}
+}
+
+func TestWatchExternalChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+
+	changes, unsubscribe := Subscribe()
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Watch(ctx, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	// Simulate the other service writing the queue file directly.
+	data := []byte(`[{"name": "external.mp3", "type": "song"}]`)
+	if err := os.WriteFile(filepath.Join(tmpDir, "queue.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, ChangeQueue)
+
+	// Local writes are announced once, not again by the watcher.
+	if _, err := AddToQueue(QueueItem{Name: "local.mp3", Type: "song"}); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, ChangeQueue)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case got := <-changes:
+		t.Errorf("Unexpected duplicate change %q", got)
+	default:
+	}
+}
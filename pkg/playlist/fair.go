@@ -0,0 +1,38 @@
+package playlist
+
+import "sort"
+
+// Requesters take turns: every queued item belongs to a round, and the
+// queue plays round by round, each in the order the items were queued.
+// A requester's next item goes into the round after their last queued
+// one, but never into a round that is already over, so someone who queues
+// many items cannot keep the others waiting and newcomers join the
+// current round.
+
+// nextRound returns the round a new item of requester belongs to.
+func nextRound(queue []QueueItem, requester string) int {
+	if len(queue) == 0 {
+		return 0
+	}
+	round := queue[0].Round
+	for _, item := range queue {
+		round = min(round, item.Round)
+	}
+	for _, item := range queue {
+		if item.RequestedBy == requester {
+			round = max(round, item.Round+1)
+		}
+	}
+	return round
+}
+
+// fairOrder sorts the queue by round. Items queued before rounds existed
+// are all in round 0 and keep their order.
+func fairOrder(queue []QueueItem) []QueueItem {
+	fair := make([]QueueItem, len(queue))
+	copy(fair, queue)
+	sort.SliceStable(fair, func(i, j int) bool {
+		return fair[i].Round < fair[j].Round
+	})
+	return fair
+}
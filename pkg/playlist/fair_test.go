@@ -0,0 +1,89 @@
+package playlist
+
+import (
+	"slices"
+	"testing"
+)
+
+func names(items []QueueItem) []string {
+	var out []string
+	for _, item := range items {
+		out = append(out, item.Name)
+	}
+	return out
+}
+
+func TestNextRound(t *testing.T) {
+	var queue []QueueItem
+	for _, item := range []QueueItem{
+		{Name: "a1", RequestedBy: "mia"},
+		{Name: "a2", RequestedBy: "mia"},
+		{Name: "a3", RequestedBy: "mia"},
+		{Name: "b1", RequestedBy: "tom"},
+		{Name: "c1", RequestedBy: "ana"},
+		{Name: "b2", RequestedBy: "tom"},
+	} {
+		item.Round = nextRound(queue, item.RequestedBy)
+		queue = append(queue, item)
+	}
+	want := []string{"a1", "b1", "c1", "a2", "b2", "a3"}
+	if got := names(fairOrder(queue)); !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Once the first round is over, newcomers join the second
+	if got := nextRound(queue[1:3], "ana"); got != 1 {
+		t.Errorf("Expected round 1, got %d", got)
+	}
+}
+
+func TestFairOrderKeepsOldQueues(t *testing.T) {
+	queue := []QueueItem{{Name: "a1", RequestedBy: "mia"}, {Name: "a2", RequestedBy: "mia"}, {Name: "b1", RequestedBy: "tom"}}
+	want := []string{"a1", "a2", "b1"}
+	if got := names(fairOrder(queue)); !slices.Equal(got, want) {
+		t.Errorf("Expected queues without rounds to keep their order, got %v", got)
+	}
+}
+
+func TestGetNextQueueItemTakesTurns(t *testing.T) {
+	Init(t.TempDir())
+	for _, item := range []QueueItem{
+		{Name: "a1", Type: "song", RequestedBy: "mia"},
+		{Name: "a2", Type: "song", RequestedBy: "mia"},
+		{Name: "a3", Type: "song", RequestedBy: "mia"},
+		{Name: "b1", Type: "song", RequestedBy: "tom"},
+	} {
+		if _, err := AddToQueue(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queued, err := GetQueueItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a1", "b1", "a2", "a3"}
+	if got := names(queued); !slices.Equal(got, want) {
+		t.Errorf("Expected the queue in fair order %v, got %v", want, got)
+	}
+
+	// Items queued while others play still wait for their turn
+	var played []string
+	for i := 0; ; i++ {
+		item, err := GetNextQueueItem()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item == nil {
+			break
+		}
+		played = append(played, item.Name)
+		if i == 0 {
+			AddToQueue(QueueItem{Name: "c1", Type: "song", RequestedBy: "ana"})
+		}
+	}
+	want = []string{"a1", "b1", "c1", "a2", "a3"}
+	if !slices.Equal(played, want) {
+		t.Errorf("Expected %v to be played, got %v", want, played)
+	}
+}
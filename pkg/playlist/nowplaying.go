@@ -0,0 +1,117 @@
+package playlist
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Sources describe why the fish is playing an item.
+const (
+	SourceQueue    = "queue"
+	SourceRandom   = "random"
+	SourceSchedule = "schedule"
+)
+
+// NowPlaying describes what the fish is currently playing or saying.
+type NowPlaying struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`   // "song" or "text"
+	Source string `json:"source"` // SourceQueue, SourceRandom or SourceSchedule
+	// RequestedBy is the user who queued the item, empty if the fish picked it.
+	RequestedBy string        `json:"requested_by,omitempty"`
+	StartedAt   time.Time     `json:"started_at"`
+	Duration    time.Duration `json:"duration"`
+	// Position is the playback position last reported by the fish.
+	Position time.Duration `json:"position"`
+}
+
+// Elapsed returns how far playback has progressed at now, capped at Duration.
+func (n NowPlaying) Elapsed(now time.Time) time.Duration {
+	elapsed := now.Sub(n.StartedAt)
+	if elapsed < n.Position {
+		elapsed = n.Position
+	}
+	if elapsed < 0 {
+		return 0
+	}
+	if n.Duration > 0 && elapsed > n.Duration {
+		return n.Duration
+	}
+	return elapsed
+}
+
+// Remaining returns the expected playback time left at now.
+func (n NowPlaying) Remaining(now time.Time) time.Duration {
+	return n.Duration - n.Elapsed(now)
+}
+
+// Progress returns the playback progress at now as a percentage.
+func (n NowPlaying) Progress(now time.Time) float64 {
+	if n.Duration <= 0 {
+		return 0
+	}
+	return float64(n.Elapsed(now)) / float64(n.Duration) * 100
+}
+
+var (
+	nowPlayingPath = "./sound-data/nowplaying.json"
+	nowPlayingMu   sync.Mutex
+)
+
+// SetNowPlaying records the item the fish has started playing.
+func SetNowPlaying(item NowPlaying) error {
+	nowPlayingMu.Lock()
+	defer nowPlayingMu.Unlock()
+
+	if err := writeJSONFile(nowPlayingPath, item); err != nil {
+		return err
+	}
+	notify(ChangeNowPlaying, nowPlayingPath)
+	return nil
+}
+
+// UpdateNowPlayingPosition records the current playback position of the item
+// named name. It is a no-op if a different item (or nothing) is playing.
+func UpdateNowPlayingPosition(name string, position time.Duration) error {
+	nowPlayingMu.Lock()
+	defer nowPlayingMu.Unlock()
+
+	var item *NowPlaying
+	if err := readJSONFile(nowPlayingPath, &item); err != nil {
+		return err
+	}
+	if item == nil || item.Name != name {
+		return nil
+	}
+	item.Position = position
+	if err := writeJSONFile(nowPlayingPath, item); err != nil {
+		return err
+	}
+	notify(ChangeNowPlaying, nowPlayingPath)
+	return nil
+}
+
+// ClearNowPlaying records that the fish has finished playing.
+func ClearNowPlaying() error {
+	nowPlayingMu.Lock()
+	defer nowPlayingMu.Unlock()
+
+	if err := os.Remove(nowPlayingPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	notify(ChangeNowPlaying, nowPlayingPath)
+	return nil
+}
+
+// GetNowPlaying returns the item currently playing, or nil if the fish is idle.
+func GetNowPlaying() (*NowPlaying, error) {
+	nowPlayingMu.Lock()
+	defer nowPlayingMu.Unlock()
+
+	var item *NowPlaying
+	if err := readJSONFile(nowPlayingPath, &item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
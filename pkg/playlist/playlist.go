@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 )
@@ -12,18 +13,42 @@ type PlayedItem struct {
 	Name      string    `json:"name"`
 	Type      string    `json:"type"` // "song" or "text"
 	Timestamp time.Time `json:"timestamp"`
+	// RequestedBy is the user who queued the item, empty if the fish picked it.
+	RequestedBy string `json:"requested_by,omitempty"`
+	// Source is why the fish played the item, one of the Source* constants.
+	// Older entries have none.
+	Source string `json:"source,omitempty"`
 }
 
 type QueueItem struct {
-	Name string `json:"name"`
-	Type string `json:"type"` // "song" or "text"
+	// ID identifies the item while it is queued, set by AddToQueue.
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`             // "song" or "text"
+	Source string `json:"source,omitempty"` // SourceSchedule for promoted scheduled items, empty otherwise
+	// RequestedBy is the user who queued or scheduled the item.
+	RequestedBy string `json:"requested_by,omitempty"`
+	// Round is the requester's turn, set by AddToQueue. See fairOrder.
+	Round int `json:"round,omitempty"`
+	// Voice is the name of the voice profile texts are said in, empty for
+	// the default voice.
+	Voice string `json:"voice,omitempty"`
+	// Effects is the audio effects chain the item is played through, see
+	// dsp.Parse. Empty plays it as is.
+	Effects string `json:"effects,omitempty"`
 }
 
+// HistoryRetention is how long played items are kept. It covers the
+// longest quota window, so the sounds service can rebuild usage from it.
+const HistoryRetention = 24 * time.Hour
+
 var (
-	mu        sync.Mutex
-	filePath  = "./sound-data/played.json"
-	queuePath = "./sound-data/queue.json"
-	queueMu   sync.Mutex
+	mu           sync.Mutex
+	filePath     = "./sound-data/played.json"
+	queuePath    = "./sound-data/queue.json"
+	queueMu      sync.Mutex
+	schedulePath = "./sound-data/schedule.json"
+	scheduleMu   sync.Mutex
 )
 
 // Init initializes the playlist configuration with a custom data directory.
@@ -33,9 +58,15 @@ func Init(dataDir string) {
 	defer mu.Unlock()
 	queueMu.Lock()
 	defer queueMu.Unlock()
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	nowPlayingMu.Lock()
+	defer nowPlayingMu.Unlock()
 
 	filePath = filepath.Join(dataDir, "played.json")
 	queuePath = filepath.Join(dataDir, "queue.json")
+	schedulePath = filepath.Join(dataDir, "schedule.json")
+	nowPlayingPath = filepath.Join(dataDir, "nowplaying.json")
 }
 
 // ensureDir creates the directory if it doesn't exist
@@ -44,6 +75,53 @@ func ensureDir(path string) error {
 	return os.MkdirAll(dir, 0755)
 }
 
+// readJSONFile decodes the JSON file at path into v.
+// A missing or empty file leaves v untouched and is not an error.
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile encodes v as indented JSON and replaces the file at path.
+// The data is written to a temporary file first and renamed into place, so
+// readers in the other service never observe a half-written file. Each write
+// gets its own temporary file, since both services write some of the files.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ensureDir(path); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 // GetPlayedItems reads the list of played items from the JSON file.
 func GetPlayedItems() ([]PlayedItem, error) {
 	mu.Lock()
@@ -103,22 +181,15 @@ func AddPlayedItem(item PlayedItem, retentionPeriod time.Duration) error {
 		}
 	}
 
-	// Write back to file
-	newData, err := json.MarshalIndent(recentItems, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	// Ensure directory exists
-	if err := ensureDir(filePath); err != nil {
+	if err := writeJSONFile(filePath, recentItems); err != nil {
 		return err
 	}
-
-	return os.WriteFile(filePath, newData, 0644)
+	notify(ChangeHistory, filePath)
+	return nil
 }
 
-// AddToQueue adds an item to the playback queue.
-func AddToQueue(item QueueItem) error {
+// AddToQueue adds an item to the playback queue and returns it as queued.
+func AddToQueue(item QueueItem) (*QueueItem, error) {
 	queueMu.Lock()
 	defer queueMu.Unlock()
 
@@ -127,7 +198,7 @@ func AddToQueue(item QueueItem) error {
 	var queue []QueueItem
 	if err != nil {
 		if !os.IsNotExist(err) {
-			return err
+			return nil, err
 		}
 		queue = []QueueItem{}
 	} else if len(data) > 0 {
@@ -136,25 +207,27 @@ func AddToQueue(item QueueItem) error {
 		}
 	}
 
-	// Add new item
+	item.ID = newID()
+	// Add new item after everyone else's items of the same turn
+	item.Round = nextRound(queue, item.RequestedBy)
 	queue = append(queue, item)
 
-	// Write back to file
-	newData, err := json.MarshalIndent(queue, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	// Ensure directory exists
-	if err := ensureDir(queuePath); err != nil {
-		return err
+	if err := writeJSONFile(queuePath, queue); err != nil {
+		return nil, err
 	}
-
-	return os.WriteFile(queuePath, newData, 0644)
+	notify(ChangeQueue, queuePath)
+	return &item, nil
 }
 
-// GetNextQueueItem retrieves and removes the first item from the queue.
+// GetNextQueueItem retrieves and removes the next item from the queue.
+// Requesters take turns, see fairOrder.
 func GetNextQueueItem() (*QueueItem, error) {
+	return TakeQueueItem(func(QueueItem) bool { return true })
+}
+
+// TakeQueueItem retrieves and removes the first item in playback order for
+// which match returns true. It returns nil if no item matches.
+func TakeQueueItem(match func(QueueItem) bool) (*QueueItem, error) {
 	queueMu.Lock()
 	defer queueMu.Unlock()
 
@@ -175,33 +248,26 @@ func GetNextQueueItem() (*QueueItem, error) {
 		return nil, err
 	}
 
-	if len(queue) == 0 {
+	// Find the first matching item in fair order
+	queue = fairOrder(queue)
+	i := slices.IndexFunc(queue, match)
+	if i < 0 {
 		return nil, nil
 	}
-
-	// Get first item
-	item := queue[0]
-	queue = queue[1:]
+	item := queue[i]
+	queue = slices.Delete(queue, i, i+1)
 
 	// Write remaining items back
-	newData, err := json.MarshalIndent(queue, "", "  ")
-	if err != nil {
-		return nil, err
-	}
-
-	// Ensure directory exists
-	if err := ensureDir(queuePath); err != nil {
-		return nil, err
-	}
-
-	if err := os.WriteFile(queuePath, newData, 0644); err != nil {
+	if err := writeJSONFile(queuePath, queue); err != nil {
 		return nil, err
 	}
+	notify(ChangeQueue, queuePath)
 
 	return &item, nil
 }
 
-// GetQueueItems retrieves all items in the queue without removing them.
+// GetQueueItems retrieves all items in the queue without removing them,
+// in the order they will be played.
 func GetQueueItems() ([]QueueItem, error) {
 	queueMu.Lock()
 	defer queueMu.Unlock()
@@ -223,5 +289,5 @@ func GetQueueItems() ([]QueueItem, error) {
 		return []QueueItem{}, nil
 	}
 
-	return queue, nil
+	return fairOrder(queue), nil
 }
@@ -1,6 +1,8 @@
 package playlist
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -21,16 +23,20 @@ func TestQueueOperations(t *testing.T) {
 
 	// 2. Test AddToQueue
 	testItem := QueueItem{Name: "test.mp3", Type: "song"}
-	if err := AddToQueue(testItem); err != nil {
+	queued, err := AddToQueue(testItem)
+	if err != nil {
 		t.Fatalf("Failed to add to queue: %v", err)
 	}
+	if queued.ID == "" {
+		t.Error("Expected the queued item to have an ID")
+	}
 
 	// 3. Test GetNextQueueItem
 	item, err = GetNextQueueItem()
 	if err != nil {
 		t.Fatalf("Failed to get item: %v", err)
 	}
-	if item == nil || item.Name != "test.mp3" {
+	if item == nil || item.Name != "test.mp3" || item.ID != queued.ID {
 		t.Errorf("Expected test.mp3, got %v", item)
 	}
 
@@ -41,6 +47,30 @@ func TestQueueOperations(t *testing.T) {
 	}
 }
 
+func TestTakeQueueItem(t *testing.T) {
+	Init(t.TempDir())
+	for _, name := range []string{"song.mp3", "alarm.mp3", "other.mp3"} {
+		if _, err := AddToQueue(QueueItem{Name: name, Type: "song"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	isAlarm := func(item QueueItem) bool { return item.Name == "alarm.mp3" }
+	item, err := TakeQueueItem(isAlarm)
+	if err != nil || item == nil || item.Name != "alarm.mp3" {
+		t.Fatalf("Expected alarm.mp3, got %v, %v", item, err)
+	}
+	if item, _ := TakeQueueItem(isAlarm); item != nil {
+		t.Errorf("Expected no more matches, got %v", item)
+	}
+
+	// The other items keep their order
+	items, _ := GetQueueItems()
+	if len(items) != 2 || items[0].Name != "song.mp3" || items[1].Name != "other.mp3" {
+		t.Errorf("Unexpected queue %v", items)
+	}
+}
+
 func TestPlayedItemsRetention(t *testing.T) {
 	// Setup temp dir
 	tmpDir := t.TempDir()
@@ -70,3 +100,27 @@ func TestPlayedItemsRetention(t *testing.T) {
 		t.Errorf("Expected 'new' item, got %s", items[0].Name)
 	}
 }
+
+func TestWriteJSONFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "schedule.json")
+
+	if err := writeJSONFile(path, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := readJSONFile(path, &got); err != nil || len(got) != 1 {
+		t.Fatalf("Expected the written list, got %v (%v)", got, err)
+	}
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left behind, got %d entries", len(entries))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Expected the file to be readable by the other service, got %v", info.Mode())
+	}
+}
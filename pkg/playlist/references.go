@@ -0,0 +1,124 @@
+package playlist
+
+// RenameSong updates the queued, played and scheduled songs called oldName,
+// so they keep referring to the clip after it was renamed to newName.
+func RenameSong(oldName, newName string) error {
+	return rewriteSongs(oldName, func(item *string) bool {
+		*item = newName
+		return true
+	})
+}
+
+// RemoveSong removes the queued songs called name, so the fish does not try
+// to play the clip after it was deleted. The history keeps what was played.
+// Schedules are kept as well, so they run again if the clip is restored;
+// until then PromoteDueItems skips them.
+func RemoveSong(name string) error {
+	return rewriteQueue(name, func(*string) bool {
+		return false
+	})
+}
+
+// rewriteSongs calls update for the name of every song entry called name
+// in the queue, the history and the schedule. Entries for which update
+// returns false are removed. Files without matching entries are not
+// written.
+func rewriteSongs(name string, update func(name *string) bool) error {
+	if err := rewriteQueue(name, update); err != nil {
+		return err
+	}
+	if err := rewriteHistory(name, update); err != nil {
+		return err
+	}
+	return rewriteSchedule(name, update)
+}
+
+func rewriteQueue(name string, update func(*string) bool) error {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	items := []QueueItem{}
+	if err := readJSONFile(queuePath, &items); err != nil {
+		return err
+	}
+	kept := items[:0]
+	changed := false
+	for _, item := range items {
+		if item.Type == "song" && item.Name == name {
+			changed = true
+			if !update(&item.Name) {
+				continue
+			}
+		}
+		kept = append(kept, item)
+	}
+	if !changed {
+		return nil
+	}
+	if err := writeJSONFile(queuePath, kept); err != nil {
+		return err
+	}
+	notify(ChangeQueue, queuePath)
+	return nil
+}
+
+func rewriteHistory(name string, update func(*string) bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	items := []PlayedItem{}
+	if err := readJSONFile(filePath, &items); err != nil {
+		return err
+	}
+	kept := items[:0]
+	changed := false
+	for _, item := range items {
+		if item.Type == "song" && item.Name == name {
+			changed = true
+			if !update(&item.Name) {
+				continue
+			}
+		}
+		kept = append(kept, item)
+	}
+	if !changed {
+		return nil
+	}
+	if err := writeJSONFile(filePath, kept); err != nil {
+		return err
+	}
+	notify(ChangeHistory, filePath)
+	return nil
+}
+
+func rewriteSchedule(name string, update func(*string) bool) error {
+	unlock, err := lockSchedule()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	items, err := readSchedule()
+	if err != nil {
+		return err
+	}
+	kept := items[:0]
+	changed := false
+	for _, item := range items {
+		if item.Type == "song" && item.Name == name {
+			changed = true
+			if !update(&item.Name) {
+				continue
+			}
+		}
+		kept = append(kept, item)
+	}
+	if !changed {
+		return nil
+	}
+	if err := writeJSONFile(schedulePath, kept); err != nil {
+		return err
+	}
+	notify(ChangeSchedule, schedulePath)
+	return nil
+}
@@ -0,0 +1,51 @@
+package playlist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenameAndRemoveSong(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+	now := time.Now()
+
+	AddToQueue(QueueItem{Name: "bell.mp3", Type: "song"})
+	AddToQueue(QueueItem{Name: "bell.mp3", Type: "text"})
+	AddToQueue(QueueItem{Name: "gong.wav", Type: "song"})
+	AddPlayedItem(PlayedItem{Name: "bell.mp3", Type: "song", Timestamp: now}, time.Hour)
+	if _, err := AddScheduledItem(ScheduledItem{Name: "bell.mp3", Type: "song", Cron: "0 12 * * *"}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1. Rename updates songs only, texts with the same content are left alone
+	if err := RenameSong("bell.mp3", "lunch-bell.mp3"); err != nil {
+		t.Fatalf("RenameSong failed: %v", err)
+	}
+	queue, _ := GetQueueItems()
+	if len(queue) != 3 || queue[0].Name != "lunch-bell.mp3" || queue[1].Name != "bell.mp3" {
+		t.Errorf("Unexpected queue after rename: %v", queue)
+	}
+	played, _ := GetPlayedItems()
+	if len(played) != 1 || played[0].Name != "lunch-bell.mp3" {
+		t.Errorf("Unexpected history after rename: %v", played)
+	}
+	scheduled, _ := GetScheduledItems()
+	if len(scheduled) != 1 || scheduled[0].Name != "lunch-bell.mp3" {
+		t.Errorf("Unexpected schedule after rename: %v", scheduled)
+	}
+
+	// 2. Remove only drops the queued songs, the history and schedule stay
+	if err := RemoveSong("lunch-bell.mp3"); err != nil {
+		t.Fatalf("RemoveSong failed: %v", err)
+	}
+	queue, _ = GetQueueItems()
+	if len(queue) != 2 || queue[0].Type != "text" || queue[1].Name != "gong.wav" {
+		t.Errorf("Unexpected queue after remove: %v", queue)
+	}
+	played, _ = GetPlayedItems()
+	scheduled, _ = GetScheduledItems()
+	if len(played) != 1 || len(scheduled) != 1 {
+		t.Errorf("Expected the history and schedule to be kept, got %v and %v", played, scheduled)
+	}
+}
@@ -0,0 +1,284 @@
+package playlist
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/filelock"
+)
+
+// MissedScheduleGrace is how late a scheduled item may still be promoted.
+// Runs missed by more than this (e.g. because the fish was offline) are
+// skipped instead of firing long after the fact.
+const MissedScheduleGrace = 10 * time.Minute
+
+var (
+	// ErrScheduleNotFound is returned when no scheduled item has the given ID.
+	ErrScheduleNotFound = errors.New("scheduled item not found")
+	// ErrInvalidSchedule is returned when a scheduled item is malformed.
+	ErrInvalidSchedule = errors.New("invalid scheduled item")
+)
+
+// ScheduledItem is a queue entry that is moved into the live queue when due.
+// It either runs once At a fixed time or recurs according to a standard
+// five-field Cron expression (optionally prefixed with CRON_TZ=...).
+type ScheduledItem struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"` // "song" or "text"
+	At        time.Time `json:"at,omitzero"`
+	Cron      string    `json:"cron,omitempty"`
+	NextRun   time.Time `json:"next_run"`
+	LastRun   time.Time `json:"last_run,omitzero"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	// Voice is the name of the voice profile a text is said in.
+	Voice string `json:"voice,omitempty"`
+	// Effects is the audio effects chain the item is played through.
+	Effects string `json:"effects,omitempty"`
+}
+
+// Recurring reports whether the item repeats on a cron schedule.
+func (s ScheduledItem) Recurring() bool {
+	return s.Cron != ""
+}
+
+// Runs returns when the item runs after from and up to until. Items with
+// an invalid cron expression never run.
+func (s ScheduledItem) Runs(from, until time.Time) []time.Time {
+	if !s.Recurring() {
+		if s.At.After(from) && !s.At.After(until) {
+			return []time.Time{s.At}
+		}
+		return nil
+	}
+	var runs []time.Time
+	next := from
+	for {
+		var err error
+		if next, err = nextCronRun(s.Cron, next); err != nil || next.After(until) {
+			return runs
+		}
+		runs = append(runs, next)
+	}
+}
+
+// nextCronRun returns the first activation of spec strictly after from.
+// Expressions are evaluated in the location of from unless they carry a
+// CRON_TZ prefix.
+func nextCronRun(spec string, from time.Time) (time.Time, error) {
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: cron %q: %v", ErrInvalidSchedule, spec, err)
+	}
+	next := sched.Next(from)
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: cron %q never fires", ErrInvalidSchedule, spec)
+	}
+	return next, nil
+}
+
+// newID returns a random ID for a scheduled or queued item.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func readSchedule() ([]ScheduledItem, error) {
+	items := []ScheduledItem{}
+	if err := readJSONFile(schedulePath, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// lockSchedule locks the schedule for a read-modify-write, both against
+// other goroutines and against the other service. The returned function
+// releases the lock.
+func lockSchedule() (unlock func(), err error) {
+	scheduleMu.Lock()
+	unlockFile, err := filelock.Lock(schedulePath)
+	if err != nil {
+		scheduleMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		scheduleMu.Unlock()
+	}, nil
+}
+
+// AddScheduledItem validates item, computes its first run relative to now
+// and persists it. The stored item, including its generated ID, is returned.
+func AddScheduledItem(item ScheduledItem, now time.Time) (*ScheduledItem, error) {
+	item.Name = strings.TrimSpace(item.Name)
+	item.Cron = strings.TrimSpace(item.Cron)
+	if item.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidSchedule)
+	}
+	if item.Type != "song" && item.Type != "text" {
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidSchedule, item.Type)
+	}
+	if item.Type == "song" {
+		// Sounds are not spoken
+		item.Voice = ""
+	}
+
+	switch {
+	case item.Cron != "" && !item.At.IsZero():
+		return nil, fmt.Errorf("%w: set either a time or a cron expression, not both", ErrInvalidSchedule)
+	case item.Cron != "":
+		next, err := nextCronRun(item.Cron, now)
+		if err != nil {
+			return nil, err
+		}
+		item.NextRun = next
+	case !item.At.IsZero():
+		if !item.At.After(now) {
+			return nil, fmt.Errorf("%w: time %s is in the past", ErrInvalidSchedule, item.At.Format(time.RFC3339))
+		}
+		item.NextRun = item.At
+	default:
+		return nil, fmt.Errorf("%w: a time or a cron expression is required", ErrInvalidSchedule)
+	}
+
+	item.ID = newID()
+	item.CreatedAt = now
+	item.LastRun = time.Time{}
+
+	unlock, err := lockSchedule()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	items, err := readSchedule()
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, item)
+	if err := writeJSONFile(schedulePath, items); err != nil {
+		return nil, err
+	}
+	notify(ChangeSchedule, schedulePath)
+	return &item, nil
+}
+
+// GetScheduledItems returns all scheduled items ordered by their next run.
+func GetScheduledItems() ([]ScheduledItem, error) {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+
+	items, err := readSchedule()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].NextRun.Before(items[j].NextRun)
+	})
+	return items, nil
+}
+
+// RemoveScheduledItem deletes the scheduled item with the given ID.
+func RemoveScheduledItem(id string) error {
+	unlock, err := lockSchedule()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	items, err := readSchedule()
+	if err != nil {
+		return err
+	}
+	for i, item := range items {
+		if item.ID == id {
+			items = append(items[:i], items[i+1:]...)
+			if err := writeJSONFile(schedulePath, items); err != nil {
+				return err
+			}
+			notify(ChangeSchedule, schedulePath)
+			return nil
+		}
+	}
+	return ErrScheduleNotFound
+}
+
+// PromoteDueItems moves every scheduled item whose next run is at or before
+// now into the playback queue. One-off items are removed afterwards, while
+// recurring items are rescheduled to their next activation after now.
+// Songs for which exists returns false, e.g. because the clip is in the
+// trash, are skipped like missed runs; a nil exists promotes every song.
+// It returns the queue items that were added.
+func PromoteDueItems(now time.Time, exists func(name string) bool) ([]QueueItem, error) {
+	unlock, err := lockSchedule()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	items, err := readSchedule()
+	if err != nil {
+		return nil, err
+	}
+
+	var promoted []QueueItem
+	var remaining []ScheduledItem
+	var queueErr error
+	changed := false
+	for _, item := range items {
+		if item.NextRun.After(now) {
+			remaining = append(remaining, item)
+			continue
+		}
+
+		if now.Sub(item.NextRun) > MissedScheduleGrace {
+			slog.Warn("Skipping missed scheduled item", "id", item.ID, "name", item.Name, "due", item.NextRun)
+		} else if item.Type == "song" && exists != nil && !exists(item.Name) {
+			slog.Warn("Skipping scheduled sound that is not in the library", "id", item.ID, "name", item.Name, "due", item.NextRun)
+		} else {
+			queueItem := QueueItem{Name: item.Name, Type: item.Type, Source: SourceSchedule, RequestedBy: item.CreatedBy, Voice: item.Voice, Effects: item.Effects}
+			queued, err := AddToQueue(queueItem)
+			if err != nil {
+				// Leave the item untouched so the next call retries it.
+				queueErr = err
+				remaining = append(remaining, item)
+				continue
+			}
+			promoted = append(promoted, *queued)
+			item.LastRun = now
+		}
+		changed = true
+
+		if !item.Recurring() {
+			continue
+		}
+		next, err := nextCronRun(item.Cron, now)
+		if err != nil {
+			slog.Error("Dropping scheduled item with invalid cron expression", "id", item.ID, "error", err)
+			continue
+		}
+		item.NextRun = next
+		remaining = append(remaining, item)
+	}
+
+	if !changed {
+		return nil, queueErr
+	}
+	if remaining == nil {
+		remaining = []ScheduledItem{}
+	}
+	if err := writeJSONFile(schedulePath, remaining); err != nil {
+		return promoted, err
+	}
+	notify(ChangeSchedule, schedulePath)
+	return promoted, queueErr
+}
@@ -0,0 +1,177 @@
+package playlist
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScheduleOneOff(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+
+	now := time.Date(2025, 3, 12, 9, 0, 0, 0, time.UTC)
+	at := now.Add(1 * time.Hour)
+
+	item, err := AddScheduledItem(ScheduledItem{Name: "Alles Gute, Jana!", Type: "text", At: at, Effects: "reverb"}, now)
+	if err != nil {
+		t.Fatalf("Failed to add scheduled item: %v", err)
+	}
+	if item.ID == "" || !item.NextRun.Equal(at) {
+		t.Fatalf("Unexpected stored item: %+v", item)
+	}
+
+	// 1. Not yet due
+	promoted, err := PromoteDueItems(now.Add(30*time.Minute), nil)
+	if err != nil {
+		t.Fatalf("Failed to promote: %v", err)
+	}
+	if len(promoted) != 0 {
+		t.Errorf("Expected nothing to be promoted, got %v", promoted)
+	}
+
+	// 2. Due: moves into the queue and disappears from the schedule
+	promoted, err = PromoteDueItems(at, nil)
+	if err != nil {
+		t.Fatalf("Failed to promote: %v", err)
+	}
+	if len(promoted) != 1 || promoted[0].Source != "schedule" {
+		t.Fatalf("Expected one scheduled queue item, got %v", promoted)
+	}
+
+	queued, _ := GetNextQueueItem()
+	if queued == nil || queued.Name != "Alles Gute, Jana!" || queued.Type != "text" || queued.Effects != "reverb" {
+		t.Errorf("Expected promoted item in queue, got %v", queued)
+	}
+
+	items, _ := GetScheduledItems()
+	if len(items) != 0 {
+		t.Errorf("One-off item should be removed after promotion, got %v", items)
+	}
+}
+
+func TestScheduleRecurring(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+
+	// Wednesday morning
+	now := time.Date(2025, 3, 12, 9, 0, 0, 0, time.UTC)
+	item, err := AddScheduledItem(ScheduledItem{Name: "lunch-bell.mp3", Type: "song", Cron: "45 11 * * 1-5"}, now)
+	if err != nil {
+		t.Fatalf("Failed to add scheduled item: %v", err)
+	}
+	firstRun := time.Date(2025, 3, 12, 11, 45, 0, 0, time.UTC)
+	if !item.NextRun.Equal(firstRun) {
+		t.Fatalf("Expected first run %v, got %v", firstRun, item.NextRun)
+	}
+
+	promoted, err := PromoteDueItems(firstRun.Add(30*time.Second), nil)
+	if err != nil {
+		t.Fatalf("Failed to promote: %v", err)
+	}
+	if len(promoted) != 1 {
+		t.Fatalf("Expected one promoted item, got %v", promoted)
+	}
+
+	items, _ := GetScheduledItems()
+	if len(items) != 1 {
+		t.Fatalf("Recurring item should stay scheduled, got %v", items)
+	}
+	if want := firstRun.Add(24 * time.Hour); !items[0].NextRun.Equal(want) {
+		t.Errorf("Expected next run %v, got %v", want, items[0].NextRun)
+	}
+
+	// Friday's run is missed entirely (fish offline), so it must be skipped
+	// and rescheduled for Monday instead of firing late.
+	promoted, err = PromoteDueItems(time.Date(2025, 3, 14, 15, 0, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatalf("Failed to promote: %v", err)
+	}
+	if len(promoted) != 0 {
+		t.Errorf("Missed run should not be promoted, got %v", promoted)
+	}
+	items, _ = GetScheduledItems()
+	if want := time.Date(2025, 3, 17, 11, 45, 0, 0, time.UTC); len(items) != 1 || !items[0].NextRun.Equal(want) {
+		t.Errorf("Expected reschedule to %v, got %v", want, items)
+	}
+
+	if err := RemoveScheduledItem(item.ID); err != nil {
+		t.Fatalf("Failed to remove item: %v", err)
+	}
+	if err := RemoveScheduledItem(item.ID); !errors.Is(err, ErrScheduleNotFound) {
+		t.Errorf("Expected ErrScheduleNotFound, got %v", err)
+	}
+}
+
+func TestScheduleValidation(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+
+	now := time.Now()
+	invalid := []ScheduledItem{
+		{Name: "", Type: "song", Cron: "* * * * *"},
+		{Name: "x.mp3", Type: "video", Cron: "* * * * *"},
+		{Name: "x.mp3", Type: "song"},
+		{Name: "x.mp3", Type: "song", Cron: "not a cron"},
+		{Name: "x.mp3", Type: "song", At: now.Add(-time.Minute)},
+		{Name: "x.mp3", Type: "song", At: now.Add(time.Minute), Cron: "* * * * *"},
+	}
+	for _, item := range invalid {
+		if _, err := AddScheduledItem(item, now); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("Expected ErrInvalidSchedule for %+v, got %v", item, err)
+		}
+	}
+}
+
+func TestScheduleRuns(t *testing.T) {
+	from := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+	hourly := ScheduledItem{Cron: "30 * * * *"}
+	if runs := hourly.Runs(from, from.Add(3*time.Hour)); len(runs) != 3 || !runs[0].Equal(from.Add(30*time.Minute)) {
+		t.Errorf("Expected three runs from 8:30, got %v", runs)
+	}
+	once := ScheduledItem{At: from.Add(time.Hour)}
+	if runs := once.Runs(from, from.Add(time.Hour)); len(runs) != 1 {
+		t.Errorf("Expected the one-off run, got %v", runs)
+	}
+	if runs := once.Runs(from.Add(time.Hour), from.Add(2*time.Hour)); len(runs) != 0 {
+		t.Errorf("Expected no run after the one-off, got %v", runs)
+	}
+	if runs := (ScheduledItem{Cron: "not cron"}).Runs(from, from.Add(time.Hour)); len(runs) != 0 {
+		t.Errorf("Expected an invalid cron expression never to run, got %v", runs)
+	}
+}
+
+func TestScheduleSkipsMissingSounds(t *testing.T) {
+	Init(t.TempDir())
+
+	now := time.Date(2025, 3, 12, 9, 0, 0, 0, time.UTC)
+	if _, err := AddScheduledItem(ScheduledItem{Name: "gong.wav", Type: "song", Cron: "0 * * * *"}, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddScheduledItem(ScheduledItem{Name: "gong.wav", Type: "text", Cron: "0 * * * *"}, now); err != nil {
+		t.Fatal(err)
+	}
+	deleted := func(string) bool { return false }
+
+	// The clip is in the trash: the song is skipped, texts are not
+	promoted, err := PromoteDueItems(now.Add(time.Hour), deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promoted) != 1 || promoted[0].Type != "text" {
+		t.Errorf("Expected only the text to be promoted, got %v", promoted)
+	}
+	items, _ := GetScheduledItems()
+	if len(items) != 2 || !items[0].NextRun.Equal(now.Add(2*time.Hour)) || !items[1].NextRun.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("Expected both items to be rescheduled, got %v", items)
+	}
+
+	// Restored, the song runs again
+	promoted, err = PromoteDueItems(now.Add(2*time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promoted) != 2 {
+		t.Errorf("Expected both items to be promoted, got %v", promoted)
+	}
+}
@@ -0,0 +1,311 @@
+// Package quota limits how much each user may queue, so one person cannot
+// monopolize the fish.
+package quota
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/tts"
+)
+
+// Reasons a request is rejected, for clients of the API.
+const (
+	ReasonQueueFull    = "queue_full"
+	ReasonPlaysPerHour = "plays_per_hour"
+	ReasonTTSChars     = "tts_chars_per_day"
+	ReasonScheduleFull = "schedule_full"
+)
+
+const (
+	playWindow = time.Hour
+	ttsWindow  = 24 * time.Hour
+)
+
+// Limits are the per-user limits. Zero disables a limit.
+type Limits struct {
+	// MaxQueued is the number of items a user may have waiting in the queue,
+	// including the items their schedules added.
+	MaxQueued int
+	// PlaysPerHour is the number of items a user may queue within an hour.
+	PlaysPerHour int
+	// TTSCharsPerDay is the number of characters of text a user may queue
+	// within 24 hours.
+	TTSCharsPerDay int
+	// MaxScheduled is the number of scheduled items a user may have.
+	MaxScheduled int
+}
+
+// ExceededError is returned when a request would exceed a limit.
+type ExceededError struct {
+	// Reason is a machine-readable code, one of the Reason* constants.
+	Reason string
+	// Message is a human-readable explanation suitable for the UI.
+	Message string
+	// RetryAfter is how long until the request would be allowed, or 0 if
+	// that depends on the queue.
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return e.Message
+}
+
+// Quota tracks what each user queued recently. Usage is kept in memory; Load
+// rebuilds it from the history and the queue after a restart.
+type Quota struct {
+	Limits
+
+	mu    sync.Mutex
+	usage map[string][]use
+	// charged is the newest history entry Load counted, ChargeScheduled
+	// picks up from there.
+	charged time.Time
+}
+
+type use struct {
+	at    time.Time
+	chars int
+}
+
+// New returns a quota enforcing limits.
+func New(limits Limits) *Quota {
+	return &Quota{Limits: limits}
+}
+
+// Load returns a quota enforcing limits that counts what the fish played
+// within the limits' windows and what is still waiting in the queue, so a
+// restart does not reset anyone's limits. Played items count from when
+// they were played rather than when they were queued.
+func Load(limits Limits, now time.Time) (*Quota, error) {
+	played, err := playlist.GetPlayedItems()
+	if err != nil {
+		return nil, err
+	}
+	queue, err := playlist.GetQueueItems()
+	if err != nil {
+		return nil, err
+	}
+	q := New(limits)
+	for _, item := range played {
+		if item.RequestedBy == "" || now.Sub(item.Timestamp) >= max(playWindow, ttsWindow) {
+			continue
+		}
+		q.Charge(item.RequestedBy, playlist.QueueItem{Name: item.Name, Type: item.Type, Source: item.Source}, item.Timestamp)
+	}
+	for _, item := range queue {
+		if item.RequestedBy != "" {
+			q.Charge(item.RequestedBy, item, now)
+		}
+	}
+	q.charged = latest(played)
+	return q, nil
+}
+
+// Take checks whether user may add item to queue, the items currently
+// waiting, and if so counts it against the user's limits. It returns an
+// *ExceededError otherwise.
+func (q *Quota) Take(user string, item playlist.QueueItem, queue []playlist.QueueItem, now time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.usage == nil {
+		q.usage = map[string][]use{}
+	}
+	q.prune(now)
+
+	if q.MaxQueued > 0 {
+		queued := 0
+		for _, queuedItem := range queue {
+			if queuedItem.RequestedBy == user {
+				queued++
+			}
+		}
+		if queued >= q.MaxQueued {
+			return &ExceededError{
+				Reason:  ReasonQueueFull,
+				Message: fmt.Sprintf("You already have %d %s in the queue. Wait until one has played.", queued, plural(queued, "item")),
+			}
+		}
+	}
+
+	uses := q.usage[user]
+	if q.PlaysPerHour > 0 && len(uses) >= q.PlaysPerHour {
+		// Uses are in order, so the oldest within the window frees up first
+		var inWindow []use
+		for _, u := range uses {
+			if now.Sub(u.at) < playWindow {
+				inWindow = append(inWindow, u)
+			}
+		}
+		if len(inWindow) >= q.PlaysPerHour {
+			retry := inWindow[len(inWindow)-q.PlaysPerHour].at.Add(playWindow).Sub(now)
+			return &ExceededError{
+				Reason:     ReasonPlaysPerHour,
+				Message:    fmt.Sprintf("You queued %d items within the last hour. Try again in %s.", len(inWindow), wait(retry)),
+				RetryAfter: retry,
+			}
+		}
+	}
+
+	chars := spokenChars(item)
+	if q.TTSCharsPerDay > 0 && chars > 0 {
+		if chars > q.TTSCharsPerDay {
+			return &ExceededError{
+				Reason:  ReasonTTSChars,
+				Message: fmt.Sprintf("Texts may be at most %d characters long.", q.TTSCharsPerDay),
+			}
+		}
+		used := 0
+		for _, u := range uses {
+			used += u.chars
+		}
+		if used+chars > q.TTSCharsPerDay {
+			// Wait until enough of the oldest texts have left the window
+			var retry time.Duration
+			free := q.TTSCharsPerDay - used
+			for _, u := range uses {
+				free += u.chars
+				if u.chars > 0 && free >= chars {
+					retry = u.at.Add(ttsWindow).Sub(now)
+					break
+				}
+			}
+			return &ExceededError{
+				Reason:     ReasonTTSChars,
+				Message:    fmt.Sprintf("You have %d of %d characters of speech left for today. Try again in %s.", max(q.TTSCharsPerDay-used, 0), q.TTSCharsPerDay, wait(retry)),
+				RetryAfter: retry,
+			}
+		}
+	}
+
+	q.usage[user] = append(uses, use{at: now, chars: chars})
+	return nil
+}
+
+// Schedule checks whether user may add item to scheduled, the items
+// currently scheduled. Together with the user's other schedules, the item
+// may run no more often than PlaysPerHour allows and say no more than
+// TTSCharsPerDay within the next day. It returns an *ExceededError
+// otherwise. The runs of scheduled items are counted by Charge once they
+// are played.
+func (q *Quota) Schedule(user string, item playlist.ScheduledItem, scheduled []playlist.ScheduledItem, now time.Time) error {
+	var own []playlist.ScheduledItem
+	for _, other := range scheduled {
+		if other.CreatedBy == user {
+			own = append(own, other)
+		}
+	}
+	if q.MaxScheduled > 0 && len(own) >= q.MaxScheduled {
+		return &ExceededError{
+			Reason:  ReasonScheduleFull,
+			Message: fmt.Sprintf("You already have %d scheduled %s. Remove one first.", len(own), plural(len(own), "item")),
+		}
+	}
+	own = append(own, item)
+
+	until := now.Add(ttsWindow)
+	var runs []time.Time
+	chars := 0
+	for _, other := range own {
+		otherRuns := other.Runs(now, until)
+		runs = append(runs, otherRuns...)
+		chars += len(otherRuns) * spokenChars(playlist.QueueItem{Name: other.Name, Type: other.Type, Source: playlist.SourceSchedule})
+	}
+
+	if q.PlaysPerHour > 0 {
+		slices.SortFunc(runs, time.Time.Compare)
+		// The busiest hour starts with one of the runs
+		busiest := 0
+		for i, start := range runs {
+			n := 0
+			for _, run := range runs[i:] {
+				if run.Sub(start) >= playWindow {
+					break
+				}
+				n++
+			}
+			busiest = max(busiest, n)
+		}
+		if busiest > q.PlaysPerHour {
+			return &ExceededError{
+				Reason:  ReasonPlaysPerHour,
+				Message: fmt.Sprintf("Your schedules would play %d items within an hour, but at most %d are allowed.", busiest, q.PlaysPerHour),
+			}
+		}
+	}
+	if q.TTSCharsPerDay > 0 && chars > q.TTSCharsPerDay {
+		return &ExceededError{
+			Reason:  ReasonTTSChars,
+			Message: fmt.Sprintf("Your schedules would say %d characters within a day, but at most %d are allowed.", chars, q.TTSCharsPerDay),
+		}
+	}
+	return nil
+}
+
+// Charge counts item, played for user at the given time, against the
+// user's limits without checking them. It is used for items that were
+// queued without Take, such as the runs of scheduled items.
+func (q *Quota) Charge(user string, item playlist.QueueItem, at time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.usage == nil {
+		q.usage = map[string][]use{}
+	}
+	chars := spokenChars(item)
+	// Keep the uses in order, Take relies on it
+	uses := q.usage[user]
+	i := len(uses)
+	for i > 0 && uses[i-1].at.After(at) {
+		i--
+	}
+	q.usage[user] = slices.Insert(uses, i, use{at: at, chars: chars})
+}
+
+// spokenChars returns the number of characters of item the fish says.
+// Scheduled texts may contain markup, which is not said.
+func spokenChars(item playlist.QueueItem) int {
+	if item.Type != "text" {
+		return 0
+	}
+	text := item.Name
+	if item.Source == playlist.SourceSchedule {
+		text = tts.StripMarkup(text)
+	}
+	return utf8.RuneCountInString(text)
+}
+
+// prune drops the uses that no longer count against any limit.
+func (q *Quota) prune(now time.Time) {
+	for user, uses := range q.usage {
+		i := 0
+		for i < len(uses) && now.Sub(uses[i].at) >= max(playWindow, ttsWindow) {
+			i++
+		}
+		if i == len(uses) {
+			delete(q.usage, user)
+		} else if i > 0 {
+			q.usage[user] = uses[i:]
+		}
+	}
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+// wait formats d for humans, rounded up to whole minutes.
+func wait(d time.Duration) string {
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	if minutes < 60 {
+		return fmt.Sprintf("%d %s", minutes, plural(minutes, "minute"))
+	}
+	hours := (minutes + 59) / 60
+	return fmt.Sprintf("%d %s", hours, plural(hours, "hour"))
+}
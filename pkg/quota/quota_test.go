@@ -0,0 +1,248 @@
+package quota
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+)
+
+func reason(err error) string {
+	var exceeded *ExceededError
+	if errors.As(err, &exceeded) {
+		return exceeded.Reason
+	}
+	return ""
+}
+
+func TestMaxQueued(t *testing.T) {
+	q := New(Limits{MaxQueued: 2})
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	song := playlist.QueueItem{Name: "bell.wav", Type: "song"}
+	queue := []playlist.QueueItem{
+		{Name: "a.wav", RequestedBy: "mia"},
+		{Name: "b.wav", RequestedBy: "tom"},
+	}
+
+	if err := q.Take("mia", song, queue, now); err != nil {
+		t.Fatal(err)
+	}
+	// Items added by mia's schedules count as hers
+	queue = append(queue, playlist.QueueItem{Name: "c.wav", RequestedBy: "mia", Source: playlist.SourceSchedule})
+	err := q.Take("mia", song, queue, now)
+	if reason(err) != ReasonQueueFull {
+		t.Fatalf("Expected the queue to be full, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "2 items") {
+		t.Errorf("Expected the message to name the limit, got %q", err)
+	}
+	if err := q.Take("tom", song, queue, now); err != nil {
+		t.Errorf("Expected tom to be unaffected, got %v", err)
+	}
+}
+
+func TestPlaysPerHour(t *testing.T) {
+	q := New(Limits{PlaysPerHour: 3})
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	song := playlist.QueueItem{Name: "bell.wav", Type: "song"}
+
+	for i := range 3 {
+		if err := q.Take("mia", song, nil, now.Add(time.Duration(i)*10*time.Minute)); err != nil {
+			t.Fatalf("Play %d: %v", i, err)
+		}
+	}
+	err := q.Take("mia", song, nil, now.Add(30*time.Minute))
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Reason != ReasonPlaysPerHour {
+		t.Fatalf("Expected the hourly limit, got %v", err)
+	}
+	if exceeded.RetryAfter != 30*time.Minute {
+		t.Errorf("Expected to retry when the first play leaves the window, got %v", exceeded.RetryAfter)
+	}
+	if err := q.Take("mia", song, nil, now.Add(time.Hour)); err != nil {
+		t.Errorf("Expected a play to be allowed an hour later, got %v", err)
+	}
+}
+
+func TestTTSCharsPerDay(t *testing.T) {
+	q := New(Limits{TTSCharsPerDay: 20})
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	text := func(s string) playlist.QueueItem { return playlist.QueueItem{Name: s, Type: "text"} }
+
+	if err := q.Take("mia", text("Lunch is ready"), nil, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Take("mia", playlist.QueueItem{Name: "a-very-long-file-name.wav", Type: "song"}, nil, now); err != nil {
+		t.Errorf("Expected songs not to count, got %v", err)
+	}
+	err := q.Take("mia", text("Meeting now"), nil, now.Add(time.Hour))
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Reason != ReasonTTSChars {
+		t.Fatalf("Expected the daily limit, got %v", err)
+	}
+	if exceeded.RetryAfter != 23*time.Hour {
+		t.Errorf("Expected to retry when the first text leaves the window, got %v", exceeded.RetryAfter)
+	}
+	if err := q.Take("mia", text("Hi"), nil, now.Add(time.Hour)); err != nil {
+		t.Errorf("Expected a short text to fit, got %v", err)
+	}
+	if reason(q.Take("tom", text(strings.Repeat("x", 21)), nil, now)) != ReasonTTSChars {
+		t.Error("Expected texts over the daily limit to be rejected")
+	}
+	if err := q.Take("mia", text("Meeting now"), nil, now.Add(24*time.Hour)); err != nil {
+		t.Errorf("Expected the limit to reset after a day, got %v", err)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	q := New(Limits{})
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for range 100 {
+		if err := q.Take("mia", playlist.QueueItem{Name: "Hello", Type: "text"}, nil, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	tests := map[time.Duration]string{
+		30 * time.Second:           "1 minute",
+		12 * time.Minute:           "12 minutes",
+		90 * time.Minute:           "2 hours",
+		23*time.Hour + time.Second: "24 hours",
+	}
+	for d, want := range tests {
+		if got := wait(d); got != want {
+			t.Errorf("wait(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestMaxScheduled(t *testing.T) {
+	q := New(Limits{MaxScheduled: 2})
+	scheduled := []playlist.ScheduledItem{
+		{Name: "a.wav", CreatedBy: "mia"},
+		{Name: "b.wav", CreatedBy: "tom"},
+	}
+
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	item := playlist.ScheduledItem{Name: "c.wav", CreatedBy: "mia"}
+
+	if err := q.Schedule("mia", item, scheduled, now); err != nil {
+		t.Fatal(err)
+	}
+	scheduled = append(scheduled, item)
+	if err := q.Schedule("mia", item, scheduled, now); reason(err) != ReasonScheduleFull {
+		t.Errorf("Expected the schedule to be full, got %v", err)
+	}
+	if err := q.Schedule("tom", item, scheduled, now); err != nil {
+		t.Errorf("Expected tom to be unaffected, got %v", err)
+	}
+}
+
+func TestScheduleRuns(t *testing.T) {
+	q := New(Limits{PlaysPerHour: 3, TTSCharsPerDay: 100})
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	scheduled := []playlist.ScheduledItem{
+		{Name: "gong.wav", Type: "song", Cron: "0,30 * * * *", CreatedBy: "mia"},
+		{Name: "a.wav", Type: "song", Cron: "* * * * *", CreatedBy: "tom"},
+	}
+
+	if err := q.Schedule("mia", playlist.ScheduledItem{Name: "b.wav", Type: "song", At: now.Add(time.Hour), CreatedBy: "mia"}, scheduled, now); err != nil {
+		t.Errorf("Expected a third run within the hour to be allowed, got %v", err)
+	}
+	if err := q.Schedule("mia", playlist.ScheduledItem{Name: "b.wav", Type: "song", Cron: "15 * * * *", CreatedBy: "mia"}, scheduled, now); err != nil {
+		t.Errorf("Expected three runs an hour to be allowed, got %v", err)
+	}
+	if err := q.Schedule("mia", playlist.ScheduledItem{Name: "b.wav", Type: "song", Cron: "*/20 * * * *", CreatedBy: "mia"}, scheduled, now); reason(err) != ReasonPlaysPerHour {
+		t.Errorf("Expected five runs an hour to exceed the limit, got %v", err)
+	}
+	if err := q.Schedule("ana", playlist.ScheduledItem{Name: "c.wav", Type: "song", Cron: "* * * * *", CreatedBy: "ana"}, scheduled, now); reason(err) != ReasonPlaysPerHour {
+		t.Errorf("Expected a run every minute to exceed the limit, got %v", err)
+	}
+
+	// Twice a day, 60 spoken characters each
+	text := strings.Repeat("a", 60) + " [pause 1s]"
+	if err := q.Schedule("ana", playlist.ScheduledItem{Name: text, Type: "text", Cron: "0 9 * * *", CreatedBy: "ana"}, nil, now); err != nil {
+		t.Errorf("Expected a daily text to be allowed, got %v", err)
+	}
+	if err := q.Schedule("ana", playlist.ScheduledItem{Name: text, Type: "text", Cron: "0 9,17 * * *", CreatedBy: "ana"}, nil, now); reason(err) != ReasonTTSChars {
+		t.Errorf("Expected the text twice a day to exceed the limit, got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	playlist.Init(t.TempDir())
+	now := time.Now()
+	played := []playlist.PlayedItem{
+		{Name: "a.wav", Type: "song", Timestamp: now.Add(-30 * time.Minute), RequestedBy: "mia", Source: playlist.SourceQueue},
+		{Name: "Guten Morgen", Type: "text", Timestamp: now.Add(-5 * time.Hour), RequestedBy: "mia", Source: playlist.SourceSchedule},
+		{Name: "b.wav", Type: "song", Timestamp: now.Add(-20 * time.Minute), Source: playlist.SourceRandom},
+	}
+	for _, item := range played {
+		if err := playlist.AddPlayedItem(item, playlist.HistoryRetention); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := playlist.AddToQueue(playlist.QueueItem{Name: "c.wav", Type: "song", RequestedBy: "mia"}); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := Load(Limits{PlaysPerHour: 3, TTSCharsPerDay: 15}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	song := playlist.QueueItem{Name: "d.wav", Type: "song"}
+	if err := q.Take("mia", song, nil, now); err != nil {
+		t.Fatalf("Expected the played and queued items to leave one play, got %v", err)
+	}
+	if err := q.Take("mia", song, nil, now); reason(err) != ReasonPlaysPerHour {
+		t.Errorf("Expected the hourly limit after a restart, got %v", err)
+	}
+	if err := q.Take("mia", playlist.QueueItem{Name: "Hallo", Type: "text"}, nil, now.Add(2*time.Hour)); reason(err) != ReasonTTSChars {
+		t.Errorf("Expected the text from this morning to count after a restart, got %v", err)
+	}
+	if !q.charged.Equal(latest(played)) {
+		t.Errorf("Expected ChargeScheduled to pick up after %v, got %v", latest(played), q.charged)
+	}
+}
+
+func TestChargeScheduled(t *testing.T) {
+	q := New(Limits{PlaysPerHour: 2, TTSCharsPerDay: 10})
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	played := []playlist.PlayedItem{
+		{Name: "old.wav", Type: "song", Timestamp: now.Add(-time.Minute), RequestedBy: "mia", Source: playlist.SourceSchedule},
+		{Name: "Guten Morgen", Type: "text", Timestamp: now.Add(10 * time.Minute), RequestedBy: "mia", Source: playlist.SourceSchedule},
+		{Name: "a.wav", Type: "song", Timestamp: now.Add(20 * time.Minute), RequestedBy: "mia", Source: playlist.SourceQueue},
+		{Name: "b.wav", Type: "song", Timestamp: now.Add(30 * time.Minute), RequestedBy: "tom", Source: playlist.SourceSchedule},
+	}
+	q.chargePlayed(played, now)
+
+	song := playlist.QueueItem{Name: "bell.wav", Type: "song"}
+	if err := q.Take("mia", song, nil, now.Add(40*time.Minute)); err != nil {
+		t.Fatalf("Expected only the scheduled text to count, got %v", err)
+	}
+	if err := q.Take("mia", song, nil, now.Add(45*time.Minute)); reason(err) != ReasonPlaysPerHour {
+		t.Errorf("Expected the hourly limit, got %v", err)
+	}
+	text := playlist.QueueItem{Name: "Hallo", Type: "text"}
+	if err := q.Take("mia", text, nil, now.Add(2*time.Hour)); reason(err) != ReasonTTSChars {
+		t.Errorf("Expected the scheduled text to use up speech, got %v", err)
+	}
+
+	// Only the spoken text of scheduled markup counts
+	q.chargePlayed([]playlist.PlayedItem{
+		{Name: "Hi [pause 1s] [move tail] du", Type: "text", Timestamp: now, RequestedBy: "ana", Source: playlist.SourceSchedule},
+	}, now.Add(-time.Minute))
+	if err := q.Take("ana", playlist.QueueItem{Name: "Hallo", Type: "text"}, nil, now.Add(time.Minute)); err != nil {
+		t.Errorf("Expected the markup not to count, got %v", err)
+	}
+	if err := q.Take("tom", song, nil, now.Add(40*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Take("tom", song, nil, now.Add(41*time.Minute)); reason(err) != ReasonPlaysPerHour {
+		t.Errorf("Expected tom's scheduled run to count, got %v", err)
+	}
+}
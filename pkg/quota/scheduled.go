@@ -0,0 +1,72 @@
+package quota
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/playlist"
+)
+
+// ChargeScheduled charges the runs of scheduled items to the users who
+// scheduled them as the fish plays them, see Charge. It picks up after the
+// history counted by Load; for a quota from New, runs played before it was
+// called are not counted. It relies on playlist.Watch to learn about the
+// history written by the fish and blocks until ctx is cancelled.
+func (q *Quota) ChargeScheduled(ctx context.Context) {
+	changes, unsubscribe := playlist.Subscribe()
+	defer unsubscribe()
+
+	q.mu.Lock()
+	last := q.charged
+	q.mu.Unlock()
+	if last.IsZero() {
+		if played, err := playlist.GetPlayedItems(); err == nil {
+			last = latest(played)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			if change != playlist.ChangeHistory {
+				continue
+			}
+			played, err := playlist.GetPlayedItems()
+			if err != nil {
+				slog.Error("Failed to read the history", "error", err)
+				continue
+			}
+			q.chargePlayed(played, last)
+			if newest := latest(played); newest.After(last) {
+				last = newest
+			}
+		}
+	}
+}
+
+// chargePlayed charges the scheduled runs in played that are newer than
+// since.
+func (q *Quota) chargePlayed(played []playlist.PlayedItem, since time.Time) {
+	for _, item := range played {
+		if item.Source != playlist.SourceSchedule || item.RequestedBy == "" || !item.Timestamp.After(since) {
+			continue
+		}
+		q.Charge(item.RequestedBy, playlist.QueueItem{Name: item.Name, Type: item.Type, Source: item.Source}, item.Timestamp)
+	}
+}
+
+// latest returns the newest timestamp in played.
+func latest(played []playlist.PlayedItem) time.Time {
+	var last time.Time
+	for _, item := range played {
+		if item.Timestamp.After(last) {
+			last = item.Timestamp
+		}
+	}
+	return last
+}
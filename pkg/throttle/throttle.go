@@ -0,0 +1,90 @@
+// Package throttle locks out keys, such as client IPs or user names, after
+// too many failed attempts.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// maxKeys bounds the number of keys remembered before expired ones are
+// dropped, so a flood of distinct keys cannot grow memory without limit.
+const maxKeys = 10000
+
+// Limiter counts failures per key. A key that fails MaxFailures times
+// within Window is locked out for Lockout.
+type Limiter struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*record
+}
+
+type record struct {
+	failures    int
+	firstFailed time.Time
+	lockedUntil time.Time
+}
+
+// New returns a limiter with the given policy.
+func New(maxFailures int, window, lockout time.Duration) *Limiter {
+	return &Limiter{MaxFailures: maxFailures, Window: window, Lockout: lockout}
+}
+
+// Locked returns how long key is still locked out at now, or 0 if it may
+// try again.
+func (l *Limiter) Locked(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.keys[key]
+	if !ok || !now.Before(r.lockedUntil) {
+		return 0
+	}
+	return r.lockedUntil.Sub(now)
+}
+
+// Fail records a failed attempt of key at now. It reports whether the
+// failure locked key out.
+func (l *Limiter) Fail(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.keys == nil {
+		l.keys = map[string]*record{}
+	}
+	if len(l.keys) >= maxKeys {
+		l.prune(now)
+	}
+
+	r, ok := l.keys[key]
+	if !ok || now.Sub(r.firstFailed) > l.Window {
+		r = &record{firstFailed: now}
+		l.keys[key] = r
+	}
+	r.failures++
+	if r.failures < l.MaxFailures {
+		return false
+	}
+	// Start counting afresh once the lockout is over
+	r.failures = 0
+	r.firstFailed = now.Add(l.Lockout)
+	r.lockedUntil = now.Add(l.Lockout)
+	return true
+}
+
+// Reset forgets the failures of key, e.g. after a successful attempt.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, key)
+}
+
+// prune drops the keys that are neither locked nor within their window.
+func (l *Limiter) prune(now time.Time) {
+	for key, r := range l.keys {
+		if !now.Before(r.lockedUntil) && now.Sub(r.firstFailed) > l.Window {
+			delete(l.keys, key)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package throttle
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	l := New(3, time.Minute, 10*time.Minute)
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	if l.Fail("mia", now) || l.Fail("mia", now.Add(time.Second)) {
+		t.Fatal("Expected no lockout before the third failure")
+	}
+	if l.Locked("mia", now) != 0 {
+		t.Error("Expected mia to be allowed")
+	}
+	if !l.Fail("mia", now.Add(2*time.Second)) {
+		t.Fatal("Expected the third failure to lock out")
+	}
+	if got := l.Locked("mia", now.Add(2*time.Second)); got != 10*time.Minute {
+		t.Errorf("Expected a 10 minute lockout, got %v", got)
+	}
+	if l.Locked("tom", now) != 0 {
+		t.Error("Expected other keys to be unaffected")
+	}
+	if l.Locked("mia", now.Add(11*time.Minute)) != 0 {
+		t.Error("Expected the lockout to end")
+	}
+
+	// A single failure after the lockout does not lock out again
+	if l.Fail("mia", now.Add(11*time.Minute)) {
+		t.Error("Expected failures to be counted afresh after the lockout")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	l := New(2, time.Minute, time.Hour)
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	l.Fail("mia", now)
+	if l.Fail("mia", now.Add(2*time.Minute)) {
+		t.Error("Expected failures outside the window not to add up")
+	}
+
+	l.Fail("tom", now)
+	l.Reset("tom")
+	if l.Fail("tom", now) {
+		t.Error("Expected Reset to forget failures")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l := New(1, time.Minute, time.Minute)
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := range maxKeys {
+		l.Fail(fmt.Sprint(i), now)
+	}
+	l.Fail("late", now.Add(time.Hour))
+	if len(l.keys) != 1 {
+		t.Errorf("Expected expired keys to be pruned, %d left", len(l.keys))
+	}
+}
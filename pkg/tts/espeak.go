@@ -0,0 +1,87 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+)
+
+// espeakWordsPerMinute is espeak-ng's default speed, stretched by the
+// voice's length scale.
+const espeakWordsPerMinute = 175
+
+// Espeak synthesizes with a local espeak-ng binary. It sounds robotic, but
+// needs neither the network nor downloaded models.
+type Espeak struct {
+	// Binary is the espeak-ng executable, looked up in PATH if it has no
+	// directory.
+	Binary string
+	// Language is used for voices without a model, e.g. "de".
+	Language string
+}
+
+// NewEspeak returns an engine running espeak-ng in German.
+func NewEspeak() *Espeak {
+	return &Espeak{Binary: "espeak-ng", Language: "de"}
+}
+
+func (e *Espeak) Name() string {
+	return "espeak"
+}
+
+// Synthesize runs espeak-ng in the language of voice's model and at its
+// speed. The other Piper parameters have no espeak equivalent.
+func (e *Espeak) Synthesize(ctx context.Context, text string, voice piper.Voice) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Binary, e.args(voice)...)
+	// Pass the text on stdin, so it cannot be mistaken for an option
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("espeak-ng failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	wavData := stdout.Bytes()
+	if len(wavData) < 12 || string(wavData[0:4]) != "RIFF" || string(wavData[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("espeak-ng returned invalid audio")
+	}
+	return wavData, nil
+}
+
+// args returns the command line for voice, reading the text from stdin.
+func (e *Espeak) args(voice piper.Voice) []string {
+	args := []string{"--stdout", "--stdin", "-v", e.language(voice)}
+	if voice.LengthScale > 0 {
+		args = append(args, "-s", strconv.Itoa(int(espeakWordsPerMinute/voice.LengthScale)))
+	}
+	return args
+}
+
+// language maps a Piper model such as "de_DE-karlsson-low" to the espeak
+// voice "de". English keeps its region, e.g. "en-us", as espeak has
+// distinct accents for it.
+func (e *Espeak) language(voice piper.Voice) string {
+	locale, _, _ := strings.Cut(voice.Voice, "-")
+	language, region, _ := strings.Cut(locale, "_")
+	if language == "" {
+		return e.Language
+	}
+	language = strings.ToLower(language)
+	if language == "en" && region != "" {
+		return language + "-" + strings.ToLower(region)
+	}
+	return language
+}
+
+// Healthy checks that the binary is installed.
+func (e *Espeak) Healthy(ctx context.Context) error {
+	if _, err := exec.LookPath(e.Binary); err != nil {
+		return fmt.Errorf("espeak-ng not available: %w", err)
+	}
+	return nil
+}
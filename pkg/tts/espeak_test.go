@@ -0,0 +1,56 @@
+package tts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+)
+
+func TestEspeakArgs(t *testing.T) {
+	e := NewEspeak()
+	tests := []struct {
+		voice piper.Voice
+		want  []string
+	}{
+		{piper.Voice{}, []string{"--stdout", "--stdin", "-v", "de"}},
+		{piper.Voice{Voice: "de_DE-karlsson-low"}, []string{"--stdout", "--stdin", "-v", "de"}},
+		{piper.Voice{Voice: "en_US-lessac-low", LengthScale: 0.5}, []string{"--stdout", "--stdin", "-v", "en-us", "-s", "350"}},
+	}
+	for _, tt := range tests {
+		if got := e.args(tt.voice); !slices.Equal(got, tt.want) {
+			t.Errorf("args(%+v) = %v, want %v", tt.voice, got, tt.want)
+		}
+	}
+}
+
+func TestEspeakSynthesize(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a shell script as fake espeak-ng")
+	}
+	dir := t.TempDir()
+	// A fake espeak-ng that echoes the text behind a WAV header
+	binary := filepath.Join(dir, "espeak-ng")
+	os.WriteFile(binary, []byte("#!/bin/sh\nprintf 'RIFF\\044\\000\\000\\000WAVE'\ncat\n"), 0755)
+
+	e := &Espeak{Binary: binary, Language: "de"}
+	if err := e.Healthy(context.Background()); err != nil {
+		t.Fatalf("Expected the binary to be found, got %v", err)
+	}
+	wavData, err := e.Synthesize(context.Background(), "-v Hallo", piper.Voice{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(wavData[12:]) != "-v Hallo" {
+		t.Errorf("Expected the text on stdin, got %q", wavData[12:])
+	}
+
+	e.Binary = filepath.Join(dir, "missing")
+	if err := e.Healthy(context.Background()); err == nil {
+		t.Error("Expected a missing binary to be unhealthy")
+	}
+}
@@ -0,0 +1,143 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+)
+
+// Failover tries its engines in order until one succeeds. Engines that
+// failed are skipped until a health check finds them working again, so a
+// missing Piper does not delay every text by its timeouts.
+type Failover struct {
+	engines []Synthesizer
+
+	mu sync.Mutex
+	// down holds the last error of each engine, nil while it is up.
+	down []error
+}
+
+// NewFailover returns a synthesizer using engines in order of preference.
+func NewFailover(engines ...Synthesizer) *Failover {
+	return &Failover{engines: engines, down: make([]error, len(engines))}
+}
+
+// Name lists the engines in order of preference.
+func (f *Failover) Name() string {
+	names := make([]string, len(f.engines))
+	for i, engine := range f.engines {
+		names[i] = engine.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Synthesize returns cached audio of any engine if there is some, and
+// otherwise asks the engines that are up, then the ones that are down as
+// a last resort. The error joins the failures of all engines.
+func (f *Failover) Synthesize(ctx context.Context, text string, voice piper.Voice) ([]byte, error) {
+	for _, engine := range f.engines {
+		if cacher, ok := engine.(Cacher); ok {
+			if wavData, ok := cacher.Cached(text, voice); ok {
+				return wavData, nil
+			}
+		}
+	}
+
+	var errs []error
+	for n, i := range f.order() {
+		engine := f.engines[i]
+		wavData, err := engine.Synthesize(ctx, text, voice)
+		if err == nil {
+			f.setDown(i, nil)
+			if n > 0 {
+				slog.Info("Synthesized with fallback engine", "engine", engine.Name())
+			}
+			return wavData, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", engine.Name(), err))
+		if ctx.Err() != nil {
+			break
+		}
+		f.setDown(i, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no tts engine configured")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// order returns the indexes of the engines that are up before those of
+// the engines that are down.
+func (f *Failover) order() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var up, down []int
+	for i := range f.engines {
+		if f.down[i] != nil {
+			down = append(down, i)
+		} else {
+			up = append(up, i)
+		}
+	}
+	return append(up, down...)
+}
+
+// setDown records the error of the i-th engine, nil if it worked, and
+// logs when it goes down or comes back.
+func (f *Failover) setDown(i int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch wasDown := f.down[i] != nil; {
+	case err != nil && !wasDown:
+		slog.Warn("TTS engine is down", "engine", f.engines[i].Name(), "error", err)
+	case err == nil && wasDown:
+		slog.Info("TTS engine is up again", "engine", f.engines[i].Name())
+	}
+	f.down[i] = err
+}
+
+// Healthy checks every engine and returns an error if none is working.
+func (f *Failover) Healthy(ctx context.Context) error {
+	var errs []error
+	for i, engine := range f.engines {
+		err := engine.Healthy(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		f.setDown(i, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", engine.Name(), err))
+		}
+	}
+	if len(errs) == len(f.engines) {
+		if len(errs) == 0 {
+			return errors.New("no tts engine configured")
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// Watch checks the engines every interval until ctx is done.
+func (f *Failover) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := f.Healthy(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("No TTS engine is available", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+)
+
+// fakeEngine answers with its name, or fails while err is set.
+type fakeEngine struct {
+	name   string
+	err    error
+	cached map[string]bool
+	calls  int
+}
+
+func (e *fakeEngine) Name() string { return e.name }
+
+func (e *fakeEngine) Synthesize(ctx context.Context, text string, voice piper.Voice) ([]byte, error) {
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	return []byte(e.name), nil
+}
+
+func (e *fakeEngine) Healthy(ctx context.Context) error { return e.err }
+
+func (e *fakeEngine) Cached(text string, voice piper.Voice) ([]byte, bool) {
+	if e.cached[text] {
+		return []byte(e.name + " cache"), true
+	}
+	return nil, false
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	primary := &fakeEngine{name: "primary", cached: map[string]bool{"Hallo": true}}
+	fallback := &fakeEngine{name: "fallback"}
+	synth := NewFailover(primary, fallback)
+
+	if got, _ := synth.Synthesize(ctx, "Moin", piper.Voice{}); string(got) != "primary" {
+		t.Errorf("Expected the first engine, got %q", got)
+	}
+
+	primary.err = errors.New("connection refused")
+	if got, _ := synth.Synthesize(ctx, "Moin", piper.Voice{}); string(got) != "fallback" {
+		t.Errorf("Expected the fallback, got %q", got)
+	}
+	// The failed engine is skipped until it is healthy again
+	primary.calls = 0
+	if got, _ := synth.Synthesize(ctx, "Moin", piper.Voice{}); string(got) != "fallback" || primary.calls != 0 {
+		t.Errorf("Expected the down engine to be skipped, got %q after %d calls", got, primary.calls)
+	}
+	// Its cache still answers
+	if got, _ := synth.Synthesize(ctx, "Hallo", piper.Voice{}); string(got) != "primary cache" {
+		t.Errorf("Expected the cached audio, got %q", got)
+	}
+
+	primary.err = nil
+	if err := synth.Healthy(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := synth.Synthesize(ctx, "Moin", piper.Voice{}); string(got) != "primary" {
+		t.Errorf("Expected the first engine once it is healthy, got %q", got)
+	}
+}
+
+func TestFailoverAllDown(t *testing.T) {
+	ctx := context.Background()
+	primary := &fakeEngine{name: "primary", err: errors.New("connection refused")}
+	fallback := &fakeEngine{name: "fallback", err: errors.New("not installed")}
+	synth := NewFailover(primary, fallback)
+
+	if err := synth.Healthy(ctx); err == nil {
+		t.Error("Expected an error when no engine is healthy")
+	}
+	_, err := synth.Synthesize(ctx, "Moin", piper.Voice{})
+	if err == nil || !errors.Is(err, primary.err) || !errors.Is(err, fallback.err) {
+		t.Errorf("Expected the errors of all engines, got %v", err)
+	}
+	// Engines that are down are still tried as a last resort
+	if primary.calls != 1 || fallback.calls != 1 {
+		t.Errorf("Expected each engine to be tried, got %d and %d calls", primary.calls, fallback.calls)
+	}
+
+	fallback.err = nil
+	if err := synth.Healthy(ctx); err != nil {
+		t.Errorf("Expected a healthy engine to be enough, got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+package tts
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MarkupTag matches the tags of catalog phrases and scheduled texts, e.g.
+// [pause 500ms] or the closing tag [/emphasis]. The fish parses them into a
+// performance.
+var MarkupTag = regexp.MustCompile(`\[(/?\w+)(?:\s+([^\[\]]*?))?\s*\]`)
+
+// StripMarkup returns text without its tags, which is what the fish says.
+func StripMarkup(text string) string {
+	return strings.Join(strings.Fields(MarkupTag.ReplaceAllString(text, " ")), " ")
+}
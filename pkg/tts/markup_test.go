@@ -0,0 +1,18 @@
+package tts
+
+import "testing"
+
+func TestStripMarkup(t *testing.T) {
+	tests := map[string]string{
+		"Hallo": "Hallo",
+		"Ich bin ein Fisch. [pause 800ms]  Blubb":  "Ich bin ein Fisch. Blubb",
+		"[voice english] I am a fish. [move tail]": "I am a fish.",
+		"Das ist [emphasis]wichtig[/emphasis]!":    "Das ist wichtig !",
+		"[sound airhorn.mp3]":                      "",
+	}
+	for text, want := range tests {
+		if got := StripMarkup(text); got != want {
+			t.Errorf("StripMarkup(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
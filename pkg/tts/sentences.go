@@ -0,0 +1,88 @@
+package tts
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxChunkLength bounds the characters synthesized in one request, so the
+// next chunk is ready before the current one has been spoken.
+const maxChunkLength = 200
+
+// Sentences splits text into chunks to synthesize one after another. The
+// first chunk is the first sentence alone, so speech starts quickly; the
+// following ones combine sentences up to maxChunkLength characters, as
+// every request costs some time. Whitespace is collapsed.
+func Sentences(text string) []string {
+	var chunks []string
+	for _, sentence := range splitSentences(text) {
+		for _, part := range splitLong(sentence) {
+			last := len(chunks) - 1
+			if last > 0 && utf8.RuneCountInString(chunks[last])+1+utf8.RuneCountInString(part) <= maxChunkLength {
+				chunks[last] += " " + part
+			} else {
+				chunks = append(chunks, part)
+			}
+		}
+	}
+	return chunks
+}
+
+// splitSentences splits text after sentence punctuation followed by a
+// space and at line breaks, which end the lines of poems.
+func splitSentences(text string) []string {
+	var sentences []string
+	add := func(sentence string) {
+		if sentence = strings.Join(strings.Fields(sentence), " "); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+
+	runes := []rune(text)
+	start := 0
+	for i, r := range runes {
+		end := r == '\n'
+		if strings.ContainsRune(".!?…", r) {
+			end = i+1 < len(runes) && unicode.IsSpace(runes[i+1])
+		}
+		if end {
+			add(string(runes[start : i+1]))
+			start = i + 1
+		}
+	}
+	add(string(runes[start:]))
+	return sentences
+}
+
+// splitLong splits a sentence longer than maxChunkLength after commas or,
+// failing that, between words.
+func splitLong(sentence string) []string {
+	if utf8.RuneCountInString(sentence) <= maxChunkLength {
+		return []string{sentence}
+	}
+	var parts []string
+	var current []string
+	length := 0
+	for _, word := range strings.Fields(sentence) {
+		wordLength := utf8.RuneCountInString(word)
+		if length > 0 && length+1+wordLength > maxChunkLength {
+			parts = append(parts, strings.Join(current, " "))
+			current, length = nil, 0
+		}
+		current = append(current, word)
+		length += wordLength
+		if len(current) > 1 {
+			length++
+		}
+		// Prefer breaking after a comma once the part has some length
+		if strings.HasSuffix(word, ",") && length > maxChunkLength/2 {
+			parts = append(parts, strings.Join(current, " "))
+			current, length = nil, 0
+		}
+	}
+	if len(current) > 0 {
+		parts = append(parts, strings.Join(current, " "))
+	}
+	return parts
+}
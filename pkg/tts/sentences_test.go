@@ -0,0 +1,48 @@
+package tts
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Einfach mal machen!", []string{"Einfach mal machen!"}},
+		{
+			"Ich muss raus. Ich muss rauuuus! Jetzt?  Ja.",
+			[]string{"Ich muss raus.", "Ich muss rauuuus! Jetzt? Ja."},
+		},
+		{
+			"Dunkel war's, der Mond schien helle,\n\nschneebedeckt die grüne Flur,\n\nals ein Wagen blitzesschnelle",
+			[]string{"Dunkel war's, der Mond schien helle,", "schneebedeckt die grüne Flur, als ein Wagen blitzesschnelle"},
+		},
+		{"Version 1.5 ist da.", []string{"Version 1.5 ist da."}},
+	}
+	for _, tt := range tests {
+		if got := Sentences(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("Sentences(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSentencesSplitsLongText(t *testing.T) {
+	sentence := strings.Repeat("Das ist ein sehr langer Satz, ", 20) + "Ende."
+	chunks := Sentences(sentence + " " + sentence)
+	if len(chunks) < 4 {
+		t.Fatalf("Expected the long sentences to be split, got %q", chunks)
+	}
+	for _, chunk := range chunks {
+		if utf8.RuneCountInString(chunk) > maxChunkLength {
+			t.Errorf("Chunk longer than %d characters: %q", maxChunkLength, chunk)
+		}
+	}
+	if got := strings.Join(chunks, " "); got != sentence+" "+sentence {
+		t.Errorf("Expected the chunks to add up to the text, got %q", got)
+	}
+}
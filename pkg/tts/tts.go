@@ -0,0 +1,26 @@
+// Package tts turns text into speech with interchangeable engines, so the
+// fish keeps talking when one of them is unavailable.
+package tts
+
+import (
+	"context"
+
+	"github.com/wachiwi/sebaschtian-the-fish/pkg/piper"
+)
+
+// Synthesizer is a text to speech engine.
+type Synthesizer interface {
+	// Name identifies the engine in logs and traces.
+	Name() string
+	// Synthesize returns text spoken in voice as a WAV file. Engines that
+	// cannot honor every voice parameter approximate it.
+	Synthesize(ctx context.Context, text string, voice piper.Voice) ([]byte, error)
+	// Healthy returns an error if the engine cannot synthesize right now.
+	Healthy(ctx context.Context) error
+}
+
+// Cacher is implemented by engines that keep earlier results, which are
+// worth using even while the engine itself is down.
+type Cacher interface {
+	Cached(text string, voice piper.Voice) ([]byte, bool)
+}
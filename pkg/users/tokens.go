@@ -0,0 +1,105 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// TokenPrefix starts every API token, so leaked tokens are easy to spot.
+	TokenPrefix = "fish_"
+	// MaxTokens is the number of API tokens a user may have at once.
+	MaxTokens = 20
+)
+
+// ErrTokenNotFound is returned when a user has no token with the given ID.
+var ErrTokenNotFound = errors.New("token not found")
+
+// Token is an API token of a user. Only a hash of the secret is stored, so
+// the secret is shown once when the token is created.
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken adds an API token called name to a user. It returns the
+// secret to send as bearer token, which cannot be retrieved later.
+func CreateToken(userName, name string) (string, *Token, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return "", nil, fmt.Errorf("%w: token names must have 1 to 64 characters", ErrInvalidUser)
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	secret := TokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	id := make([]byte, 8)
+	rand.Read(id)
+	token := Token{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hashToken(secret),
+		CreatedAt: time.Now(),
+	}
+
+	err := modifyUser(userName, func(users map[string]User, user *User) error {
+		if len(user.Tokens) >= MaxTokens {
+			return fmt.Errorf("%w: at most %d tokens are allowed, revoke one first", ErrInvalidUser, MaxTokens)
+		}
+		user.Tokens = append(user.Tokens, token)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, &token, nil
+}
+
+// RevokeToken removes the API token with the given ID from a user.
+func RevokeToken(userName, id string) error {
+	return modifyUser(userName, func(users map[string]User, user *User) error {
+		for i, token := range user.Tokens {
+			if token.ID == id {
+				user.Tokens = append(user.Tokens[:i], user.Tokens[i+1:]...)
+				return nil
+			}
+		}
+		return ErrTokenNotFound
+	})
+}
+
+// AuthenticateToken returns the user an API token belongs to.
+func AuthenticateToken(secret string) (*User, error) {
+	if !strings.HasPrefix(secret, TokenPrefix) {
+		return nil, ErrInvalidCredentials
+	}
+	hash := []byte(hashToken(secret))
+
+	mu.Lock()
+	users, err := readUsers()
+	mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		for _, token := range user.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token.Hash), hash) == 1 {
+				return &user, nil
+			}
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
@@ -0,0 +1,405 @@
+// Package users stores the accounts of the sounds web UI with their bcrypt
+// password hashes and roles.
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role decides which parts of the web UI a user may use.
+type Role string
+
+const (
+	// RoleAdmin may upload, edit and delete sounds and manage users.
+	RoleAdmin Role = "admin"
+	// RoleMember may queue and schedule sounds and announcements.
+	RoleMember Role = "member"
+	// RoleViewer may only watch the camera.
+	RoleViewer Role = "viewer"
+)
+
+// Roles lists all roles, most privileged first.
+var Roles = []Role{RoleAdmin, RoleMember, RoleViewer}
+
+// Includes reports whether r grants everything required grants.
+func (r Role) Includes(required Role) bool {
+	have, want := slices.Index(Roles, r), slices.Index(Roles, required)
+	return have >= 0 && want >= 0 && have <= want
+}
+
+// MinPasswordLength is the minimum length of passwords set via the UI.
+const MinPasswordLength = 8
+
+var (
+	// ErrInvalidCredentials is returned by Authenticate for an unknown user
+	// or a wrong password.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUserNotFound is returned when no user has the given name.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when creating a user whose name is taken.
+	ErrUserExists = errors.New("a user with that name already exists")
+	// ErrLastAdmin is returned when a change would leave no admin behind.
+	ErrLastAdmin = errors.New("there must be at least one admin")
+	// ErrInvalidUser is returned for an invalid name, password or role.
+	ErrInvalidUser = errors.New("invalid user")
+)
+
+// User is an account of the web UI.
+type User struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Role         Role      `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Source names the identity provider of users that sign in via single
+	// sign-on. It is empty for users with a password.
+	Source string `json:"source,omitempty"`
+	// Subject identifies single sign-on users at their identity provider.
+	// Unlike the name it never changes, so it decides who owns the account.
+	Subject string `json:"subject,omitempty"`
+	// Tokens authenticate the user's scripts against the API.
+	Tokens []Token `json:"tokens,omitempty"`
+	// Generation is raised whenever the password or role changes. Sessions
+	// started with an earlier generation are no longer valid.
+	Generation int `json:"generation,omitempty"`
+}
+
+// Can reports whether the user has at least the required role.
+func (u User) Can(required Role) bool {
+	return u.Role.Includes(required)
+}
+
+var (
+	mu       sync.Mutex
+	filePath = "./sound-data/users.json"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// dummyHash is compared against when a user does not exist, so that
+// Authenticate takes as long for unknown users as for wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("sebaschtian"), bcrypt.DefaultCost)
+
+// Init sets the directory the user store is kept in.
+func Init(dataDir string) {
+	mu.Lock()
+	defer mu.Unlock()
+	filePath = filepath.Join(dataDir, "users.json")
+}
+
+func readUsers() (map[string]User, error) {
+	users := map[string]User{}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return users, nil
+		}
+		return nil, err
+	}
+	if len(data) == 0 {
+		return users, nil
+	}
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// writeUsers replaces the user store atomically. The file only holds
+// hashes, but is still kept private to the service.
+func writeUsers(users map[string]User) error {
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+	return string(hash), nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("%w: the password must have at least %d characters", ErrInvalidUser, MinPasswordLength)
+	}
+	return nil
+}
+
+func validateRole(role Role) error {
+	if !slices.Contains(Roles, role) {
+		return fmt.Errorf("%w: unknown role %q", ErrInvalidUser, role)
+	}
+	return nil
+}
+
+// Bootstrap creates an admin with the given credentials if there are no
+// users yet, so that a fresh installation can be logged into. Once users
+// exist the credentials are ignored.
+func Bootstrap(name, password string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	users, err := readUsers()
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return nil
+	}
+	if name == "" || password == "" {
+		return errors.New("no users exist yet, an initial admin name and password are required")
+	}
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidUser, name)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	users[name] = User{Name: name, PasswordHash: hash, Role: RoleAdmin, CreatedAt: time.Now()}
+	return writeUsers(users)
+}
+
+// Authenticate returns the user with the given name if password matches.
+func Authenticate(name, password string) (*User, error) {
+	mu.Lock()
+	users, err := readUsers()
+	mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	user, ok := users[name]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// List returns all users sorted by name.
+func List() ([]User, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	users, err := readUsers()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]User, 0, len(users))
+	for _, user := range users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// Get returns the user with the given name, or ErrUserNotFound.
+func Get(name string) (*User, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	users, err := readUsers()
+	if err != nil {
+		return nil, err
+	}
+	user, ok := users[name]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+// Create adds a user with the given password and role.
+func Create(name, password string, role Role) (*User, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w: names may only contain letters, digits and . _ @ -", ErrInvalidUser)
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+	if err := validateRole(role); err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	users, err := readUsers()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := users[name]; ok {
+		return nil, ErrUserExists
+	}
+	user := User{Name: name, PasswordHash: hash, Role: role, CreatedAt: time.Now()}
+	users[name] = user
+	if err := writeUsers(users); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Identity is who signed in via an identity provider.
+type Identity struct {
+	// Source names the identity provider.
+	Source string
+	// Subject identifies the user at the provider, see User.Subject.
+	Subject string
+	// VerifiedName is set if the provider vouches for the name, such as a
+	// verified e-mail address. Only such names bind accounts that were
+	// created before subjects were recorded.
+	VerifiedName bool
+}
+
+// SyncExternal creates or updates the user name that signed in as identity
+// and gives it role, which the provider decides. Users with a password or
+// another subject cannot be taken over this way.
+func SyncExternal(name string, identity Identity, role Role) (*User, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w: invalid name %q", ErrInvalidUser, name)
+	}
+	if err := validateRole(role); err != nil {
+		return nil, err
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject for %q", ErrInvalidUser, name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	users, err := readUsers()
+	if err != nil {
+		return nil, err
+	}
+	user, ok := users[name]
+	switch {
+	case !ok:
+		user = User{Name: name, CreatedAt: time.Now(), Source: identity.Source, Subject: identity.Subject, Role: role}
+	case user.Source != identity.Source:
+		return nil, ErrUserExists
+	case user.Subject == "" && identity.VerifiedName:
+		user.Subject = identity.Subject
+	case user.Subject != identity.Subject:
+		return nil, ErrUserExists
+	}
+	if user.Role != role {
+		user.Role = role
+		user.Generation++
+	}
+	users[name] = user
+	if err := writeUsers(users); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetPassword replaces the password of a user.
+func SetPassword(name, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return modifyUser(name, func(users map[string]User, user *User) error {
+		if user.Source != "" {
+			return fmt.Errorf("%w: %s signs in via %s", ErrInvalidUser, name, user.Source)
+		}
+		user.PasswordHash = hash
+		user.Generation++
+		return nil
+	})
+}
+
+// SetRole changes the role of a user. The last admin cannot be demoted.
+func SetRole(name string, role Role) error {
+	if err := validateRole(role); err != nil {
+		return err
+	}
+	return modifyUser(name, func(users map[string]User, user *User) error {
+		if user.Role == RoleAdmin && role != RoleAdmin && countAdmins(users) == 1 {
+			return ErrLastAdmin
+		}
+		if user.Role != role {
+			user.Role = role
+			user.Generation++
+		}
+		return nil
+	})
+}
+
+// Delete removes a user. The last admin cannot be deleted.
+func Delete(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	users, err := readUsers()
+	if err != nil {
+		return err
+	}
+	user, ok := users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if user.Role == RoleAdmin && countAdmins(users) == 1 {
+		return ErrLastAdmin
+	}
+	delete(users, name)
+	return writeUsers(users)
+}
+
+// modifyUser applies change to the stored user called name and saves it.
+func modifyUser(name string, change func(users map[string]User, user *User) error) error {
+	mu.Lock()
+	defer mu.Unlock()
+	users, err := readUsers()
+	if err != nil {
+		return err
+	}
+	user, ok := users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := change(users, &user); err != nil {
+		return err
+	}
+	users[name] = user
+	return writeUsers(users)
+}
+
+func countAdmins(users map[string]User) int {
+	n := 0
+	for _, user := range users {
+		if user.Role == RoleAdmin {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,222 @@
+package users
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBootstrapAndAuthenticate(t *testing.T) {
+	tmpDir := t.TempDir()
+	Init(tmpDir)
+
+	if err := Bootstrap("", ""); err == nil {
+		t.Fatal("Expected an error without initial credentials")
+	}
+	if err := Bootstrap("admin", "secret"); err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+	// Once users exist, the initial credentials are ignored
+	if err := Bootstrap("other", "changed"); err != nil {
+		t.Fatalf("Second bootstrap failed: %v", err)
+	}
+
+	user, err := Authenticate("admin", "secret")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if user.Role != RoleAdmin {
+		t.Errorf("Expected bootstrapped user to be an admin, got %q", user.Role)
+	}
+	if _, err := Authenticate("admin", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for a wrong password, got %v", err)
+	}
+	if _, err := Authenticate("other", "changed"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for an unknown user, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Error("Password is stored in plain text")
+	}
+}
+
+func TestManageUsers(t *testing.T) {
+	Init(t.TempDir())
+	if err := Bootstrap("admin", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Create("alice", "short", RoleMember); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("Expected a short password to be rejected, got %v", err)
+	}
+	if _, err := Create("al ice", "long enough", RoleMember); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("Expected an invalid name to be rejected, got %v", err)
+	}
+	if _, err := Create("alice", "long enough", "owner"); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("Expected an unknown role to be rejected, got %v", err)
+	}
+	if _, err := Create("alice", "long enough", RoleMember); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := Create("alice", "long enough", RoleViewer); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+
+	if err := SetPassword("alice", "another one"); err != nil {
+		t.Fatalf("SetPassword failed: %v", err)
+	}
+	if _, err := Authenticate("alice", "another one"); err != nil {
+		t.Errorf("Expected new password to work, got %v", err)
+	}
+	if alice, _ := Get("alice"); alice.Generation != 1 {
+		t.Errorf("Expected a new password to end sessions, got generation %d", alice.Generation)
+	}
+
+	if err := SetRole("admin", RoleMember); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Expected the last admin to stay admin, got %v", err)
+	}
+	if err := Delete("admin"); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Expected the last admin not to be deleted, got %v", err)
+	}
+	if err := SetRole("alice", RoleAdmin); err != nil {
+		t.Fatalf("SetRole failed: %v", err)
+	}
+	if alice, _ := Get("alice"); alice.Generation != 2 {
+		t.Errorf("Expected a new role to end sessions, got generation %d", alice.Generation)
+	}
+	if err := Delete("admin"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := Get("admin"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected deleted user to be gone, got %v", err)
+	}
+
+	list, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "alice" {
+		t.Errorf("Unexpected users %v", list)
+	}
+}
+
+func TestSyncExternal(t *testing.T) {
+	Init(t.TempDir())
+	if err := Bootstrap("admin", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	mia := Identity{Source: "oidc", Subject: "https://idp.example 42", VerifiedName: true}
+	user, err := SyncExternal("mia@office.example", mia, RoleMember)
+	if err != nil {
+		t.Fatalf("SyncExternal failed: %v", err)
+	}
+	if user.Source != "oidc" || user.Role != RoleMember {
+		t.Errorf("Unexpected user %+v", user)
+	}
+	// The provider decides the role on every sign-in
+	if user, err = SyncExternal("mia@office.example", mia, RoleAdmin); err != nil || user.Role != RoleAdmin {
+		t.Errorf("Expected role to be updated, got %+v, %v", user, err)
+	}
+
+	if _, err := SyncExternal("admin", mia, RoleAdmin); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected local user not to be taken over, got %v", err)
+	}
+	// Another identity picking the same name cannot take the account over
+	eve := Identity{Source: "oidc", Subject: "https://idp.example 666"}
+	if _, err := SyncExternal("mia@office.example", eve, RoleAdmin); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected another subject to be rejected, got %v", err)
+	}
+	if err := SetPassword("mia@office.example", "long enough"); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("Expected single sign-on users to have no password, got %v", err)
+	}
+	if _, err := Authenticate("mia@office.example", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected password login to fail, got %v", err)
+	}
+}
+
+func TestSyncExternalLegacy(t *testing.T) {
+	Init(t.TempDir())
+	// Accounts from before subjects were recorded
+	if err := writeUsers(map[string]User{
+		"mia@office.example": {Name: "mia@office.example", Role: RoleMember, Source: "oidc"},
+		"tom":                {Name: "tom", Role: RoleMember, Source: "oidc"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SyncExternal("tom", Identity{Source: "oidc", Subject: "7"}, RoleMember); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected an unverified name not to bind the account, got %v", err)
+	}
+	mia := Identity{Source: "oidc", Subject: "42", VerifiedName: true}
+	if user, err := SyncExternal("mia@office.example", mia, RoleMember); err != nil || user.Subject != "42" {
+		t.Fatalf("Expected a verified name to bind the account, got %+v, %v", user, err)
+	}
+	if _, err := SyncExternal("mia@office.example", Identity{Source: "oidc", Subject: "7", VerifiedName: true}, RoleMember); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected the bound account to keep its subject, got %v", err)
+	}
+}
+
+func TestRoleIncludes(t *testing.T) {
+	tests := []struct {
+		role, required Role
+		want           bool
+	}{
+		{RoleAdmin, RoleMember, true},
+		{RoleMember, RoleMember, true},
+		{RoleMember, RoleAdmin, false},
+		{RoleViewer, RoleMember, false},
+		{RoleViewer, RoleViewer, true},
+		{"", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Includes(tt.required); got != tt.want {
+			t.Errorf("%q.Includes(%q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	Init(t.TempDir())
+	if err := Bootstrap("admin", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := CreateToken("admin", " "); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("Expected a name to be required, got %v", err)
+	}
+	if _, _, err := CreateToken("nobody", "script"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	secret, token, err := CreateToken("admin", "doorbell")
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, TokenPrefix) || strings.Contains(token.Hash, secret) {
+		t.Errorf("Unexpected token %q with hash %q", secret, token.Hash)
+	}
+
+	user, err := AuthenticateToken(secret)
+	if err != nil || user.Name != "admin" {
+		t.Fatalf("Expected token to authenticate admin, got %v, %v", user, err)
+	}
+	if _, err := AuthenticateToken(secret + "x"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected a wrong token to be rejected, got %v", err)
+	}
+
+	if err := RevokeToken("admin", token.ID); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+	if _, err := AuthenticateToken(secret); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected a revoked token to be rejected, got %v", err)
+	}
+	if err := RevokeToken("admin", token.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+// Package volume stores the volume settings of the fish: a master volume
+// that follows a curve over the day, a gain per kind of audio and how much
+// background audio is ducked under speech. The sounds service edits them,
+// the fish plays with them.
+package volume
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source is a kind of audio with its own gain.
+type Source string
+
+const (
+	// SourceSpeech is everything the fish says.
+	SourceSpeech Source = "speech"
+	// SourceSongs are clips from the library, except alerts.
+	SourceSongs Source = "songs"
+	// SourceAlerts are clips in the alert category.
+	SourceAlerts Source = "alerts"
+)
+
+// Sources lists the sources.
+var Sources = []Source{SourceSpeech, SourceSongs, SourceAlerts}
+
+// The range of the gain of a source, in dB.
+const (
+	MinGain = -30.0
+	MaxGain = 6.0
+)
+
+// MaxDuck is the most background audio can be ducked, in dB.
+const MaxDuck = 40.0
+
+// ErrInvalidSettings is returned for settings out of range.
+var ErrInvalidSettings = errors.New("invalid volume settings")
+
+// Point sets the master volume at a time of day. Between points the volume
+// changes gradually, and after the last point of the day it heads towards
+// the first one.
+type Point struct {
+	// Time is the time of day as 15:04.
+	Time string `json:"time"`
+	// Volume is the share of the master volume in percent.
+	Volume int `json:"volume"`
+}
+
+// Settings are the volume settings.
+type Settings struct {
+	// Master is the master volume in percent.
+	Master int `json:"master"`
+	// Gains are the gains of the sources in dB, missing sources play at 0.
+	Gains map[Source]float64 `json:"gains_db,omitempty"`
+	// Curve scales the master volume over the day, an empty curve keeps it.
+	Curve []Point `json:"curve,omitempty"`
+	// Duck lowers background audio while the fish speaks, in dB.
+	Duck float64 `json:"duck_db"`
+}
+
+// Default returns the settings used until some are saved: full volume,
+// without gains or a curve, and background audio ducked by 12 dB.
+func Default() Settings {
+	return Settings{Master: 100, Duck: 12}
+}
+
+// Validate checks that the settings are in range.
+func (s Settings) Validate() error {
+	if s.Master < 0 || s.Master > 100 {
+		return fmt.Errorf("%w: the master volume must be between 0 and 100%%", ErrInvalidSettings)
+	}
+	for source, gain := range s.Gains {
+		if !slices.Contains(Sources, source) {
+			return fmt.Errorf("%w: unknown source %q", ErrInvalidSettings, source)
+		}
+		if gain < MinGain || gain > MaxGain || math.IsNaN(gain) {
+			return fmt.Errorf("%w: the gain of %s must be between %g and %g dB", ErrInvalidSettings, source, MinGain, MaxGain)
+		}
+	}
+	if s.Duck < 0 || s.Duck > MaxDuck || math.IsNaN(s.Duck) {
+		return fmt.Errorf("%w: ducking must be between 0 and %g dB", ErrInvalidSettings, MaxDuck)
+	}
+	seen := map[int]bool{}
+	for _, point := range s.Curve {
+		minute, err := minuteOfDay(point.Time)
+		if err != nil {
+			return fmt.Errorf("%w: invalid time %q in the curve, want e.g. 22:00", ErrInvalidSettings, point.Time)
+		}
+		if seen[minute] {
+			return fmt.Errorf("%w: %s is in the curve twice", ErrInvalidSettings, point.Time)
+		}
+		seen[minute] = true
+		if point.Volume < 0 || point.Volume > 100 {
+			return fmt.Errorf("%w: the volume at %s must be between 0 and 100%%", ErrInvalidSettings, point.Time)
+		}
+	}
+	return nil
+}
+
+// Level returns the master volume at t in percent, following the curve.
+// Curve times are in the location passed to Init.
+func (s Settings) Level(t time.Time) float64 {
+	return float64(s.Master) * s.curveAt(t.In(location)) / 100
+}
+
+// Amplitude returns the factor that samples are scaled by at t for the
+// master volume. Volumes are perceived about linearly if the amplitude
+// follows their square, so 50% is a quarter of the amplitude.
+func (s Settings) Amplitude(t time.Time) float64 {
+	level := s.Level(t) / 100
+	return level * level
+}
+
+// GainFactor returns the factor that samples of source are scaled by for
+// its gain, on top of the master volume.
+func (s Settings) GainFactor(source Source) float64 {
+	return math.Pow(10, s.Gains[source]/20)
+}
+
+// String summarizes the settings for the audit trail, e.g.
+// "80%, songs -6 dB, curve 08:00 100%, 22:00 20%, duck 12 dB".
+func (s Settings) String() string {
+	parts := []string{fmt.Sprintf("%d%%", s.Master)}
+	for _, source := range Sources {
+		if gain := s.Gains[source]; gain != 0 {
+			parts = append(parts, fmt.Sprintf("%s %+g dB", source, gain))
+		}
+	}
+	if len(s.Curve) > 0 {
+		parts = append(parts, "curve "+strings.ReplaceAll(FormatCurve(s.Curve), "\n", ", "))
+	}
+	if s.Duck != 0 {
+		parts = append(parts, fmt.Sprintf("duck %g dB", s.Duck))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// curveAt returns the share of the master volume at t in percent.
+func (s Settings) curveAt(t time.Time) float64 {
+	type point struct {
+		minute int
+		volume float64
+	}
+	var points []point
+	for _, p := range s.Curve {
+		if minute, err := minuteOfDay(p.Time); err == nil {
+			points = append(points, point{minute, float64(p.Volume)})
+		}
+	}
+	if len(points) == 0 {
+		return 100
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].minute < points[j].minute })
+
+	now := float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60
+	// The last point of the day leads to the first one of the next
+	prev, next := points[len(points)-1], points[0]
+	prevMinute, nextMinute := float64(prev.minute-24*60), float64(next.minute)
+	for i, p := range points {
+		if float64(p.minute) > now {
+			break
+		}
+		prev, prevMinute = p, float64(p.minute)
+		if i+1 < len(points) {
+			next, nextMinute = points[i+1], float64(points[i+1].minute)
+		} else {
+			next, nextMinute = points[0], float64(points[0].minute+24*60)
+		}
+	}
+	if nextMinute == prevMinute {
+		return prev.volume
+	}
+	return prev.volume + (next.volume-prev.volume)*(now-prevMinute)/(nextMinute-prevMinute)
+}
+
+// ParseCurve reads a curve written as points separated by commas or
+// newlines, each a time and a volume such as "22:00 30%".
+func ParseCurve(text string) ([]Point, error) {
+	var curve []Point
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		parts := strings.Fields(field)
+		if len(parts) == 0 {
+			continue
+		}
+		volume, err := strconv.Atoi(strings.TrimSuffix(parts[len(parts)-1], "%"))
+		if len(parts) != 2 || err != nil {
+			return nil, fmt.Errorf("%w: invalid point %q in the curve, want e.g. 22:00 30%%", ErrInvalidSettings, strings.TrimSpace(field))
+		}
+		curve = append(curve, Point{Time: parts[0], Volume: volume})
+	}
+	return curve, nil
+}
+
+// FormatCurve writes curve the way ParseCurve reads it.
+func FormatCurve(curve []Point) string {
+	lines := make([]string, len(curve))
+	for i, point := range curve {
+		lines[i] = fmt.Sprintf("%s %d%%", point.Time, point.Volume)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func minuteOfDay(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+var (
+	mu       sync.Mutex
+	filePath = "./sound-data/volume.json"
+	location = time.Local
+)
+
+// Init sets the directory the settings are kept in and the location of the
+// times in curves.
+func Init(dataDir string, loc *time.Location) {
+	mu.Lock()
+	defer mu.Unlock()
+	filePath = filepath.Join(dataDir, "volume.json")
+	location = loc
+}
+
+// Get returns the saved settings, or the defaults if none are saved.
+func Get() (Settings, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	settings := Default()
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
+		}
+		return settings, err
+	}
+	if len(data) == 0 {
+		return settings, nil
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return Default(), err
+	}
+	return settings, nil
+}
+
+// Set validates and saves settings. The file is replaced atomically, so the
+// fish never reads half of it.
+func Set(settings Settings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	settings.Curve = slices.Clone(settings.Curve)
+	sort.Slice(settings.Curve, func(i, j int) bool {
+		a, _ := minuteOfDay(settings.Curve[i].Time)
+		b, _ := minuteOfDay(settings.Curve[j].Time)
+		return a < b
+	})
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
@@ -0,0 +1,104 @@
+package volume
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGetAndSet(t *testing.T) {
+	Init(t.TempDir(), time.UTC)
+
+	settings, err := Get()
+	if err != nil || settings.Master != 100 || settings.Duck != 12 || settings.Level(time.Now()) != 100 {
+		t.Fatalf("Expected full volume before anything is saved, got %+v, %v", settings, err)
+	}
+
+	settings = Settings{
+		Master: 80,
+		Gains:  map[Source]float64{SourceAlerts: 3, SourceSongs: -6},
+		Curve:  []Point{{Time: "22:00", Volume: 20}, {Time: "08:00", Volume: 100}},
+		Duck:   9,
+	}
+	if err := Set(settings); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Master != 80 || saved.Gains[SourceSongs] != -6 || len(saved.Curve) != 2 || saved.Curve[0].Time != "08:00" {
+		t.Errorf("Expected the settings with a sorted curve, got %+v", saved)
+	}
+
+	if got := saved.String(); got != "80%, songs -6 dB, alerts +3 dB, curve 08:00 100%, 22:00 20%, duck 9 dB" {
+		t.Errorf("Unexpected summary %q", got)
+	}
+
+	for _, invalid := range []Settings{
+		{Master: 101},
+		{Master: 50, Gains: map[Source]float64{"doorbell": 0}},
+		{Master: 50, Gains: map[Source]float64{SourceSpeech: 12}},
+		{Master: 50, Curve: []Point{{Time: "25:00", Volume: 50}}},
+		{Master: 50, Curve: []Point{{Time: "08:00", Volume: 50}, {Time: "08:00", Volume: 60}}},
+		{Master: 50, Curve: []Point{{Time: "08:00", Volume: -1}}},
+		{Master: 50, Duck: 50},
+	} {
+		if err := Set(invalid); !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("Expected %+v to be invalid, got %v", invalid, err)
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	Init(t.TempDir(), time.UTC)
+	settings := Settings{
+		Master: 50,
+		Curve:  []Point{{Time: "08:00", Volume: 100}, {Time: "18:00", Volume: 100}, {Time: "22:00", Volume: 20}},
+	}
+	day := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		clock string
+		want  float64
+	}{
+		{"12:00", 50},
+		{"18:00", 50},
+		{"20:00", 30},
+		{"22:00", 10},
+		// Overnight the volume rises from 20% at 22:00 to 100% at 08:00
+		{"03:00", 30},
+		{"08:00", 50},
+	}
+	for _, test := range tests {
+		clock, _ := time.Parse("15:04", test.clock)
+		at := day.Add(time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute)
+		if got := settings.Level(at); math.Abs(got-test.want) > 0.01 {
+			t.Errorf("Level at %s = %v, want %v", test.clock, got, test.want)
+		}
+	}
+
+	settings = Settings{Master: 50, Gains: map[Source]float64{SourceAlerts: 6}}
+	if got := settings.Amplitude(day); got != 0.25 {
+		t.Errorf("Expected half volume to be a quarter of the amplitude, got %v", got)
+	}
+	if got := settings.GainFactor(SourceAlerts); math.Abs(got-math.Pow(10, 6.0/20)) > 1e-9 || settings.GainFactor(SourceSpeech) != 1 {
+		t.Errorf("Expected the alert gain to be applied, got %v", got)
+	}
+}
+
+func TestParseCurve(t *testing.T) {
+	curve, err := ParseCurve("08:00 100%,\n 22:00 20 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(curve) != 2 || curve[1] != (Point{Time: "22:00", Volume: 20}) {
+		t.Errorf("Unexpected curve %+v", curve)
+	}
+	if got := FormatCurve(curve); got != "08:00 100%\n22:00 20%" {
+		t.Errorf("Unexpected formatted curve %q", got)
+	}
+	if _, err := ParseCurve("22:00"); !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("Expected a point without a volume to be invalid, got %v", err)
+	}
+}